@@ -1,9 +1,9 @@
 package cmd
 
 import (
-	"os"
+	"context"
+	"strings"
 
-	"filestore/client/store"
 	"github.com/spf13/cobra"
 )
 
@@ -15,12 +15,15 @@ func init() {
 func RegisterAddCommand() *cobra.Command {
 	c := &cobra.Command{
 		Use:  "add",
+		Args: cobra.MinimumNArgs(1),
 		Run: func(cmd *cobra.Command, args []string) {
-			c := store.NewClient()
-			if err := c.Add(args); err != nil {
-				os.Exit(1)
-			}
+			labels, err := labelsFlag()
+			check(err)
+			check(uploadClient().Add(context.Background(), args, writeOptions(labels)...))
+			newLogger().Infof("Added %s", strings.Join(args, ", "))
 		},
 	}
+	uploadFlags(c)
+	addFlag(c.Flags(), &flag{name: "label", desc: "label to attach to the file as key=value, can be repeated", kind: "stringSlice"})
 	return c
 }
@@ -0,0 +1,29 @@
+package cmd
+
+import (
+	"context"
+	"io"
+	"os"
+
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	rootCmd.AddCommand(RegisterCatCommand())
+}
+
+// RegisterCatCommand register cat subcommand and flags
+func RegisterCatCommand() *cobra.Command {
+	c := &cobra.Command{
+		Use:  "cat",
+		Args: cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			content, err := newClient().Open(context.Background(), args[0], 0)
+			check(err)
+			defer content.Close()
+			_, err = io.Copy(os.Stdout, content)
+			check(err)
+		},
+	}
+	return c
+}
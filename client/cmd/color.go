@@ -0,0 +1,63 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// Escape sequences grep uses to color matches, file names, line numbers and separators
+const (
+	colorMatch     = "\x1b[01;31m\x1b[K"
+	colorFile      = "\x1b[35m\x1b[K"
+	colorLine      = "\x1b[32m\x1b[K"
+	colorSeparator = "\x1b[36m\x1b[K"
+	colorReset     = "\x1b[m\x1b[K"
+)
+
+// colorFlag adds the --color flag of the commands highlighting matches
+func colorFlag(c *cobra.Command) {
+	addFlag(c.Flags(), &flag{name: "color", desc: "highlight matches: auto, always or never", defaultValue: "auto"})
+}
+
+// useColor reports whether matches are highlighted on stdout as --color says, auto
+// highlighting them when stdout is a terminal
+func useColor() (bool, error) {
+	switch mode := viper.GetString("color"); mode {
+	case "always":
+		return true, nil
+	case "never":
+		return false, nil
+	case "auto":
+		fi, err := os.Stdout.Stat()
+		return err == nil && fi.Mode()&os.ModeCharDevice != 0 && os.Getenv("TERM") != "dumb", nil
+	default:
+		return false, fmt.Errorf("invalid color %q, expecting auto, always or never", mode)
+	}
+}
+
+// paint colors s with the escape sequence color
+func paint(s, color string) string {
+	return color + s + colorReset
+}
+
+// highlight colors the byte ranges of s, they are sorted and dont overlap
+func highlight(s string, ranges [][2]int) string {
+	var b strings.Builder
+	last := 0
+	for _, r := range ranges {
+		if r[0] < last || r[1] > len(s) {
+			continue
+		}
+		b.WriteString(s[last:r[0]])
+		b.WriteString(colorMatch)
+		b.WriteString(s[r[0]:r[1]])
+		b.WriteString(colorReset)
+		last = r[1]
+	}
+	b.WriteString(s[last:])
+	return b.String()
+}
@@ -1,26 +1,84 @@
 package cmd
 
 import (
-	"os"
+	"context"
+	"fmt"
+	"strings"
 
 	"filestore/client/store"
 	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
 )
 
 func init() {
 	rootCmd.AddCommand(RegisterCountCommand())
 }
 
-// RegisterCountCommand register count subcommand and flags 
+// countColumns are the counts wc can print, in the order they are printed
+var countColumns = []struct {
+	flag  string
+	value func(*store.Counts) int64
+}{
+	{"lines", func(c *store.Counts) int64 { return int64(c.Lines) }},
+	{"words", func(c *store.Counts) int64 { return int64(c.Words) }},
+	{"chars", func(c *store.Counts) int64 { return int64(c.Chars) }},
+	{"bytes", func(c *store.Counts) int64 { return c.Bytes }},
+	{"max-line-length", func(c *store.Counts) int64 { return int64(c.MaxLineLength) }},
+}
+
+// RegisterCountCommand register count subcommand and flags. Like coreutils wc, it prints
+// lines, words and bytes unless flags select counts, and given files, or --glob and
+// --selector, it prints the counts of every file followed by their total.
 func RegisterCountCommand() *cobra.Command {
 	c := &cobra.Command{
-		Use:  "wc",
+		Use: "wc [FILE...]",
 		Run: func(cmd *cobra.Command, args []string) {
-			c := store.NewClient()
-			if err := c.CountWords(); err != nil {
-				os.Exit(1)
+			c := newClient()
+			opts, err := statsOptions(c, args)
+			check(err)
+			perFile := len(args) > 0 || cmd.Flags().Changed("glob") || cmd.Flags().Changed("selector")
+			total, files, err := c.Count(context.Background(), perFile, opts...)
+			scanErr := scanErrors(err)
+			selected := make(map[string]bool)
+			for _, column := range countColumns {
+				if viper.GetBool(column.flag) {
+					selected[column.flag] = true
+				}
+			}
+			if len(selected) == 0 {
+				selected["lines"], selected["words"], selected["bytes"] = true, true, true
+			}
+			printCounts := func(counts *store.Counts, name string) {
+				var values []string
+				for _, column := range countColumns {
+					if selected[column.flag] {
+						values = append(values, fmt.Sprintf("%3d", column.value(counts)))
+					}
+				}
+				if name != "" {
+					values = append(values, name)
+				}
+				fmt.Println(strings.Join(values, " "))
+			}
+			if !perFile {
+				printCounts(total, "")
+				exitOnScanErrors(cmd, scanErr)
+				return
+			}
+			for i := range files {
+				printCounts(&files[i], files[i].Name)
+			}
+			if len(files) > 1 {
+				printCounts(total, "total")
 			}
+			exitOnScanErrors(cmd, scanErr)
 		},
 	}
+	addFlag(c.Flags(), &flag{name: "lines", short: "l", desc: "print the line counts", kind: "bool"})
+	addFlag(c.Flags(), &flag{name: "words", short: "w", desc: "print the word counts", kind: "bool"})
+	addFlag(c.Flags(), &flag{name: "chars", short: "m", desc: "print the character counts", kind: "bool"})
+	addFlag(c.Flags(), &flag{name: "bytes", short: "c", desc: "print the byte counts", kind: "bool"})
+	addFlag(c.Flags(), &flag{name: "max-line-length", short: "L", desc: "print the maximum display width", kind: "bool"})
+	statsFlags(c)
 	return c
 }
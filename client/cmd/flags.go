@@ -3,6 +3,7 @@ package cmd
 import (
 	"fmt"
 	"os"
+	"time"
 
 	"github.com/spf13/pflag"
 	"github.com/spf13/viper"
@@ -31,6 +32,18 @@ func addFlag(flagset *pflag.FlagSet, f *flag) {
 		} else {
 			flagset.IntP(f.name, f.short, 0, f.desc)
 		}
+	case "duration":
+		if f.defaultValue != nil {
+			flagset.DurationP(f.name, f.short, f.defaultValue.(time.Duration), f.desc)
+		} else {
+			flagset.DurationP(f.name, f.short, 0, f.desc)
+		}
+	case "stringSlice":
+		if f.defaultValue != nil {
+			flagset.StringSliceP(f.name, f.short, f.defaultValue.([]string), f.desc)
+		} else {
+			flagset.StringSliceP(f.name, f.short, nil, f.desc)
+		}
 	default:
 		if f.defaultValue != nil {
 			flagset.StringP(f.name, f.short, f.defaultValue.(string), f.desc)
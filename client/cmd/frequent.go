@@ -1,10 +1,12 @@
 package cmd
 
 import (
-	"os"
+	"context"
+	"fmt"
 
 	"filestore/client/store"
 	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
 )
 
 func init() {
@@ -14,15 +16,44 @@ func init() {
 // RegisterFrequentCommand register frequent subcommand and flags 
 func RegisterFrequentCommand() *cobra.Command {
 	c := &cobra.Command{
-		Use:  "freq-words",
+		Use: "freq-words [FILE...]",
 		Run: func(cmd *cobra.Command, args []string) {
-			c := store.NewClient()
-			if err := c.FreqWords(); err != nil {
-				os.Exit(1)
+			c := newClient()
+			opts, err := statsOptions(c, args)
+			check(err)
+			if viper.GetBool("per-file") {
+				files, err := c.FileWordStats(context.Background(), viper.GetInt("limit"), viper.GetString("order"), opts...)
+				scanErr := scanErrors(err)
+				for i, file := range files {
+					if i > 0 {
+						fmt.Println()
+					}
+					fmt.Printf("==> %s <==\n", file.Name)
+					printWords(file.Words)
+				}
+				exitOnScanErrors(cmd, scanErr)
+				return
 			}
+			words, err := c.FreqWords(context.Background(), viper.GetInt("limit"), viper.GetString("order"), opts...)
+			scanErr := scanErrors(err)
+			printWords(words)
+			exitOnScanErrors(cmd, scanErr)
 		},
 	}
 	addFlag(c.Flags(), &flag{name: "limit", short: "n", desc: "limit for frequent words", defaultValue: 1, kind: "int"})
 	addFlag(c.Flags(), &flag{name: "order", desc: "order for frequent words", defaultValue: "dsc"})
+	addFlag(c.Flags(), &flag{name: "per-file", desc: "show the frequent words of every file", kind: "bool"})
+	statsFlags(c)
 	return c
 }
+
+// printWords prints words and their number of occurrences, stems with their most common form
+func printWords(words []store.WordFreq) {
+	for _, wf := range words {
+		if wf.Form != "" {
+			fmt.Printf("%3d %s (%s)\n", wf.Count, wf.Word, wf.Form)
+			continue
+		}
+		fmt.Printf("%3d %s\n", wf.Count, wf.Word)
+	}
+}
@@ -0,0 +1,31 @@
+package cmd
+
+import (
+	"context"
+	"path/filepath"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+func init() {
+	rootCmd.AddCommand(RegisterGetCommand())
+}
+
+// RegisterGetCommand register get subcommand and flags
+func RegisterGetCommand() *cobra.Command {
+	c := &cobra.Command{
+		Use:  "get",
+		Args: cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			dest := viper.GetString("output")
+			if dest == "" {
+				dest = filepath.Base(args[0])
+			}
+			check(newClient().Download(context.Background(), args[0], dest))
+			newLogger().Infof("Downloaded %s to %s", args[0], dest)
+		},
+	}
+	addFlag(c.Flags(), &flag{name: "output", short: "o", desc: "path to save the file to, defaults to the file name"})
+	return c
+}
@@ -0,0 +1,99 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"filestore/client/store"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+func init() {
+	rootCmd.AddCommand(RegisterGrepCommand())
+}
+
+// RegisterGrepCommand register grep subcommand and flags
+func RegisterGrepCommand() *cobra.Command {
+	c := &cobra.Command{
+		Use:  "grep",
+		Args: cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			color, err := useColor()
+			check(err)
+			pattern := args[0]
+			if viper.GetBool("ignore-case") {
+				pattern = "(?i)" + pattern
+			}
+			before, after := viper.GetInt("before-context"), viper.GetInt("after-context")
+			if n := viper.GetInt("context"); n > 0 {
+				if !cmd.Flags().Changed("before-context") {
+					before = n
+				}
+				if !cmd.Flags().Changed("after-context") {
+					after = n
+				}
+			}
+			opts := []store.GrepOption{store.WithContextLines(before, after)}
+			if glob := viper.GetString("glob"); glob != "" {
+				opts = append(opts, store.WithGlob(glob))
+			}
+
+			p := &grepPrinter{color: color, context: before > 0 || after > 0}
+			check(newClient().Grep(context.Background(), pattern, p.print, opts...))
+			if !p.matched {
+				os.Exit(1)
+			}
+		},
+	}
+	addFlag(c.Flags(), &flag{name: "glob", short: "g", desc: "only search the files matching this glob"})
+	addFlag(c.Flags(), &flag{name: "ignore-case", short: "i", desc: "ignore case distinctions", kind: "bool"})
+	addFlag(c.Flags(), &flag{name: "before-context", short: "B", desc: "print this number of lines before matches", kind: "int"})
+	addFlag(c.Flags(), &flag{name: "after-context", short: "A", desc: "print this number of lines after matches", kind: "int"})
+	addFlag(c.Flags(), &flag{name: "context", short: "C", desc: "print this number of lines before and after matches", kind: "int"})
+	colorFlag(c)
+	return c
+}
+
+// grepPrinter prints lines the way grep -n does
+type grepPrinter struct {
+	color   bool
+	context bool
+	matched bool
+	// file and line are the last line printed
+	file string
+	line int
+}
+
+// print prints a line found by grep, it is the callback of store.Client.Grep
+func (p *grepPrinter) print(line store.GrepLine) error {
+	if line.Error != "" {
+		fmt.Fprintf(os.Stderr, "store grep: %s: %s\n", line.File, line.Error)
+		return nil
+	}
+	// groups of lines not following each other are separated when context is shown
+	if p.context && p.file != "" && (line.File != p.file || line.Line != p.line+1) {
+		fmt.Println(p.paint("--", colorSeparator))
+	}
+	p.file, p.line = line.File, line.Line
+	separator, text := "-", line.Text
+	if line.Match {
+		p.matched = true
+		separator = ":"
+		if p.color {
+			text = highlight(text, line.Ranges)
+		}
+	}
+	fmt.Printf("%s%s%s%s%s\n", p.paint(line.File, colorFile), p.paint(separator, colorSeparator),
+		p.paint(fmt.Sprint(line.Line), colorLine), p.paint(separator, colorSeparator), text)
+	return nil
+}
+
+// paint colors s when colors are on
+func (p *grepPrinter) paint(s, color string) string {
+	if !p.color {
+		return s
+	}
+	return paint(s, color)
+}
@@ -0,0 +1,29 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	rootCmd.AddCommand(RegisterHistoryCommand())
+}
+
+// RegisterHistoryCommand register history subcommand and flags
+func RegisterHistoryCommand() *cobra.Command {
+	c := &cobra.Command{
+		Use:  "history",
+		Args: cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			versions, err := newClient().Versions(context.Background(), args[0])
+			check(err)
+			for _, v := range versions {
+				fmt.Printf("%3d %10d %s\n", v.Version, v.Size, v.Modified.Local().Format(time.RFC3339))
+			}
+		},
+	}
+	return c
+}
@@ -1,10 +1,14 @@
 package cmd
 
 import (
+	"context"
+	"fmt"
 	"os"
+	"text/tabwriter"
 
 	"filestore/client/store"
 	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
 )
 
 func init() {
@@ -15,13 +19,24 @@ func init() {
 func RegisterListCommand() *cobra.Command {
 	c := &cobra.Command{
 		Use:  "ls",
+		Args: cobra.NoArgs,
 		Run: func(cmd *cobra.Command, args []string) {
-			c := store.NewClient()
-			if err := c.List(); err != nil {
-				os.Exit(1)
+			files, err := newClient().List(context.Background())
+			check(err)
+			if !viper.GetBool("long") {
+				for _, fi := range files {
+					fmt.Println(fi.Name)
+				}
+				return
 			}
+			w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+			for _, fi := range files {
+				fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\t%s\n", fi.Size, fi.Modified.Local().Format("Jan _2 15:04"),
+					fi.Uploader, fi.ContentType, store.FormatLabels(fi.Labels), fi.Name)
+			}
+			check(w.Flush())
 		},
 	}
-
+	addFlag(c.Flags(), &flag{name: "long", short: "l", desc: "list files with their metadata", kind: "bool"})
 	return c
 }
@@ -0,0 +1,37 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+func init() {
+	rootCmd.AddCommand(RegisterNgramsCommand())
+}
+
+// RegisterNgramsCommand register freq-ngrams subcommand and flags
+func RegisterNgramsCommand() *cobra.Command {
+	c := &cobra.Command{
+		Use:   "freq-ngrams [FILE...]",
+		Short: "most frequent sequences of words in the store or in the given files",
+		Run: func(cmd *cobra.Command, args []string) {
+			c := newClient()
+			opts, err := statsOptions(c, args)
+			check(err)
+			ngrams, err := c.FreqNgrams(context.Background(), viper.GetInt("n"), viper.GetInt("limit"), viper.GetString("order"), opts...)
+			scanErr := scanErrors(err)
+			for _, nf := range ngrams {
+				fmt.Printf("%3d %s\n", nf.Count, nf.Word)
+			}
+			exitOnScanErrors(cmd, scanErr)
+		},
+	}
+	addFlag(c.Flags(), &flag{name: "n", short: "n", desc: "number of words of the n-grams, 2 to 5", defaultValue: 2, kind: "int"})
+	addFlag(c.Flags(), &flag{name: "limit", short: "l", desc: "limit for frequent n-grams", defaultValue: 10, kind: "int"})
+	addFlag(c.Flags(), &flag{name: "order", desc: "order for frequent n-grams", defaultValue: "dsc"})
+	statsFlags(c)
+	return c
+}
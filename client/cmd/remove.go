@@ -1,10 +1,11 @@
 package cmd
 
 import (
-	"os"
+	"context"
 
 	"filestore/client/store"
 	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
 )
 
 func init() {
@@ -15,13 +16,16 @@ func init() {
 func RegisterRemoveCommand() *cobra.Command {
 	c := &cobra.Command{
 		Use:  "rm",
+		Args: cobra.ExactArgs(1),
 		Run: func(cmd *cobra.Command, args []string) {
-			c := store.NewClient()	
-			if err := c.Remove(args[0]); err != nil {
-				os.Exit(1)
+			var opts []store.WriteOption
+			if etag := viper.GetString("if-match"); etag != "" {
+				opts = append(opts, store.WithIfMatch(etag))
 			}
+			check(newClient().Remove(context.Background(), args[0], opts...))
+			newLogger().Infof("Moved %s to the trash", args[0])
 		},
 	}
-
+	addFlag(c.Flags(), &flag{name: "if-match", desc: "only remove the file if its current ETag is this one, as shown by stat"})
 	return c
 }
@@ -0,0 +1,28 @@
+package cmd
+
+import (
+	"context"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+func init() {
+	rootCmd.AddCommand(RegisterRestoreCommand())
+}
+
+// RegisterRestoreCommand register restore subcommand and flags
+func RegisterRestoreCommand() *cobra.Command {
+	c := &cobra.Command{
+		Use:  "restore",
+		Args: cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			version := viper.GetInt("version")
+			check(newClient().Restore(context.Background(), args[0], version))
+			newLogger().Infof("Restored version %d of %s", version, args[0])
+		},
+	}
+	addFlag(c.Flags(), &flag{name: "version", desc: "version of the file to restore", kind: "int"})
+	c.MarkFlagRequired("version") // nolint: errcheck
+	return c
+}
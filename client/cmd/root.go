@@ -1,18 +1,35 @@
 package cmd
 
 import (
+	"fmt"
 	"os"
+	"os/user"
+	"strings"
+
+	"filestore/client/store"
+	"filestore/helper"
+	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
 )
 
 func init() {
-	addFlag(rootCmd.Flags(), &flag{name: "server-url", short: "s", defaultValue: "http://localhost:9090", desc: "Filestore server url"})
+	addFlag(rootCmd.PersistentFlags(), &flag{name: "server-url", short: "s", defaultValue: "http://localhost:9090", desc: "Filestore server url"})
+	// -l stays a shorthand of the store command only, subcommands use it for their own flags
 	addFlag(rootCmd.Flags(), &flag{name: "log-level", short: "l", desc: "logging verbosity", defaultValue: "info"})
+	addFlag(rootCmd.PersistentFlags(), &flag{name: "log-level", desc: "logging verbosity", defaultValue: "info"})
+	addFlag(rootCmd.PersistentFlags(), &flag{name: "connect-timeout", desc: "maximum time spent connecting to the server", defaultValue: store.DefaultConnectTimeout, kind: "duration"})
+	addFlag(rootCmd.PersistentFlags(), &flag{name: "idle-timeout", desc: "maximum time a transfer goes without progress, 0 for no limit", defaultValue: store.DefaultIdleTimeout, kind: "duration"})
 }
 // rootCmd represents the base command
 var rootCmd = &cobra.Command{
 	Use:   "store",
 	Short: "store is a tool to operate filestore server",
+	// Subcommands may share flag names, bind the flags of the command being run last
+	// so viper reads them rather than the flags of the last registered command
+	PersistentPreRun: func(cmd *cobra.Command, args []string) {
+		check(viper.BindPFlags(cmd.Flags()))
+	},
 	Run: func(cmd *cobra.Command, args []string) {
 		if err := cmd.Usage(); err != nil {
 			os.Exit(1)
@@ -25,4 +42,38 @@ func Execute() {
 	if err := rootCmd.Execute(); err != nil {
 		os.Exit(1)
 	}
-}
\ No newline at end of file
+}
+
+// newClient returns a client of the server given by --server-url writing files as the local user
+func newClient(extra ...store.Option) *store.Client {
+	opts := []store.Option{
+		store.WithConnectTimeout(viper.GetDuration("connect-timeout")),
+		store.WithIdleTimeout(viper.GetDuration("idle-timeout")),
+	}
+	if u, err := user.Current(); err == nil {
+		opts = append(opts, store.WithUser(u.Username))
+	}
+	opts = append(opts, extra...)
+	return store.NewClient(viper.GetString("server-url"), opts...)
+}
+
+// newLogger returns the logger reporting what commands did, at the --log-level verbosity
+func newLogger() *logrus.Logger {
+	return helper.NewLogger("filestore")
+}
+
+// labelsFlag returns the labels given as key=value with --label
+func labelsFlag() (map[string]string, error) {
+	var labels map[string]string
+	for _, label := range viper.GetStringSlice("label") {
+		kv := strings.SplitN(label, "=", 2)
+		if len(kv) != 2 || kv[0] == "" {
+			return nil, fmt.Errorf("invalid label %q, expecting key=value", label)
+		}
+		if labels == nil {
+			labels = make(map[string]string)
+		}
+		labels[kv[0]] = kv[1]
+	}
+	return labels, nil
+}
@@ -0,0 +1,38 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+func init() {
+	rootCmd.AddCommand(RegisterSearchCommand())
+}
+
+// RegisterSearchCommand register search subcommand and flags
+func RegisterSearchCommand() *cobra.Command {
+	c := &cobra.Command{
+		Use:  "search",
+		Args: cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			color, err := useColor()
+			check(err)
+			results, err := newClient().Search(context.Background(), args[0], viper.GetInt("limit"))
+			check(err)
+			for _, hit := range results.Hits {
+				snippet := hit.Snippet
+				if color {
+					snippet = highlight(snippet, hit.Highlights)
+				}
+				fmt.Printf("%s (%.2f)\n    %s\n", hit.Name, hit.Score, snippet)
+			}
+			newLogger().Infof("%d of %d matching files shown", len(results.Hits), results.Total)
+		},
+	}
+	addFlag(c.Flags(), &flag{name: "limit", short: "n", desc: "maximum number of files shown", defaultValue: 10, kind: "int"})
+	colorFlag(c)
+	return c
+}
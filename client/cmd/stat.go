@@ -0,0 +1,40 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"filestore/client/store"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	rootCmd.AddCommand(RegisterStatCommand())
+}
+
+// RegisterStatCommand register stat subcommand and flags
+func RegisterStatCommand() *cobra.Command {
+	c := &cobra.Command{
+		Use:  "stat",
+		Args: cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			fi, err := newClient().Stat(context.Background(), args[0])
+			check(err)
+			w := tabwriter.NewWriter(os.Stdout, 0, 8, 1, ' ', 0)
+			fmt.Fprintf(w, "Name:\t%s\n", fi.Name)
+			fmt.Fprintf(w, "Size:\t%d\n", fi.Size)
+			fmt.Fprintf(w, "SHA256:\t%s\n", fi.SHA256)
+			fmt.Fprintf(w, "ETag:\t%s\n", fi.ETag())
+			fmt.Fprintf(w, "Content-Type:\t%s\n", fi.ContentType)
+			fmt.Fprintf(w, "Created:\t%s\n", fi.Created.Local().Format(time.RFC3339))
+			fmt.Fprintf(w, "Modified:\t%s\n", fi.Modified.Local().Format(time.RFC3339))
+			fmt.Fprintf(w, "Uploader:\t%s\n", fi.Uploader)
+			fmt.Fprintf(w, "Labels:\t%s\n", store.FormatLabels(fi.Labels))
+			check(w.Flush())
+		},
+	}
+	return c
+}
@@ -0,0 +1,92 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"filestore/client/store"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// statsFlags adds the flags of the commands computing word statistics
+func statsFlags(c *cobra.Command) {
+	addFlag(c.Flags(), &flag{name: "analyzer", desc: "how files are split into words: standard (case folded, no punctuation) or raw (split on spaces), defaults to the server setting"})
+	addFlag(c.Flags(), &flag{name: "no-numbers", desc: "leave numbers out of the statistics", kind: "bool"})
+	addFlag(c.Flags(), &flag{name: "glob", short: "g", desc: "only count the files whose name matches a glob"})
+	addFlag(c.Flags(), &flag{name: "selector", desc: "only count the files whose labels match a selector: key=value, key!=value, key or !key, comma separated"})
+	addFlag(c.Flags(), &flag{name: "stem", desc: "reduce words to their stem with the stemmer of a language: en, fr, de or es"})
+	addFlag(c.Flags(), &flag{name: "stopwords", desc: "stopword lists to leave out: en, fr, de, es or custom lists of the server, can be repeated", kind: "stringSlice"})
+	addFlag(c.Flags(), &flag{name: "stopwords-file", desc: "local file of stopwords to leave out, one or more per line"})
+}
+
+// statsOptions returns the options of word statistics as the flags say, restricted to files
+// when some are given. The file of --stopwords-file is uploaded to the server as a custom
+// list named after its content.
+func statsOptions(c *store.Client, files []string) ([]store.StatsOption, error) {
+	var opts []store.StatsOption
+	if len(files) > 0 {
+		opts = append(opts, store.WithFiles(files...))
+	}
+	if glob := viper.GetString("glob"); glob != "" {
+		opts = append(opts, store.WithFilesMatching(glob))
+	}
+	if selector := viper.GetString("selector"); selector != "" {
+		opts = append(opts, store.WithLabelSelector(selector))
+	}
+	if analyzer := viper.GetString("analyzer"); analyzer != "" {
+		opts = append(opts, store.WithAnalyzer(analyzer))
+	}
+	if viper.GetBool("no-numbers") {
+		opts = append(opts, store.WithoutNumbers())
+	}
+	if language := viper.GetString("stem"); language != "" {
+		opts = append(opts, store.WithStemming(language))
+	}
+	lists := viper.GetStringSlice("stopwords")
+	if file := viper.GetString("stopwords-file"); file != "" {
+		b, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		sum := sha256.Sum256(b)
+		name := "file-" + hex.EncodeToString(sum[:8])
+		if _, err := c.PutStopwords(context.Background(), name, bytes.NewReader(b)); err != nil {
+			return nil, err
+		}
+		lists = append(lists, name)
+	}
+	if len(lists) > 0 {
+		opts = append(opts, store.WithStopwords(lists...))
+	}
+	return opts, nil
+}
+
+// scanErrors returns the error of statistics when it reports files the server could not
+// scan, the statistics covering the other files are then printed. Other errors exit.
+func scanErrors(err error) *store.ScanError {
+	var scanErr *store.ScanError
+	if errors.As(err, &scanErr) {
+		return scanErr
+	}
+	check(err)
+	return nil
+}
+
+// exitOnScanErrors prints the files the server could not scan to stderr and exits with
+// code 1 when there are some
+func exitOnScanErrors(cmd *cobra.Command, scanErr *store.ScanError) {
+	if scanErr == nil {
+		return
+	}
+	for _, f := range scanErr.Files {
+		fmt.Fprintf(os.Stderr, "store %s: %s: %s\n", cmd.Name(), f.Name, f.Error)
+	}
+	os.Exit(1)
+}
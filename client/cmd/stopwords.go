@@ -0,0 +1,69 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	rootCmd.AddCommand(RegisterStopwordsCommand())
+}
+
+// RegisterStopwordsCommand register stopwords subcommand and its list, show, put and rm subcommands
+func RegisterStopwordsCommand() *cobra.Command {
+	c := &cobra.Command{
+		Use:   "stopwords",
+		Short: "manage the stopword lists of the server",
+		Run: func(cmd *cobra.Command, args []string) {
+			if err := cmd.Usage(); err != nil {
+				os.Exit(1)
+			}
+		},
+	}
+	c.AddCommand(&cobra.Command{
+		Use:  "list",
+		Args: cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			names, err := newClient().StopwordLists(context.Background())
+			check(err)
+			for _, name := range names {
+				fmt.Println(name)
+			}
+		},
+	})
+	c.AddCommand(&cobra.Command{
+		Use:  "show",
+		Args: cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			words, err := newClient().Stopwords(context.Background(), args[0])
+			check(err)
+			for _, word := range words {
+				fmt.Println(word)
+			}
+		},
+	})
+	c.AddCommand(&cobra.Command{
+		Use:  "put",
+		Args: cobra.ExactArgs(2),
+		Run: func(cmd *cobra.Command, args []string) {
+			file, err := os.Open(args[1])
+			check(err)
+			defer file.Close()
+			words, err := newClient().PutStopwords(context.Background(), args[0], file)
+			check(err)
+			newLogger().Infof("Stopword list %s has %d words", args[0], len(words))
+		},
+	})
+	c.AddCommand(&cobra.Command{
+		Use:  "rm",
+		Args: cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			check(newClient().DeleteStopwords(context.Background(), args[0]))
+			newLogger().Infof("Removed stopword list %s", args[0])
+		},
+	})
+	return c
+}
@@ -0,0 +1,60 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	rootCmd.AddCommand(RegisterTrashCommand())
+}
+
+// RegisterTrashCommand register trash subcommand and its list, restore and purge subcommands
+func RegisterTrashCommand() *cobra.Command {
+	c := &cobra.Command{
+		Use:   "trash",
+		Short: "manage removed files",
+		Run: func(cmd *cobra.Command, args []string) {
+			if err := cmd.Usage(); err != nil {
+				os.Exit(1)
+			}
+		},
+	}
+	c.AddCommand(&cobra.Command{
+		Use:  "list",
+		Args: cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			entries, err := newClient().Trash(context.Background())
+			check(err)
+			for _, entry := range entries {
+				fmt.Printf("%s %10d %s\n", entry.Deleted.Local().Format(time.RFC3339), entry.Size, entry.Name)
+			}
+		},
+	})
+	c.AddCommand(&cobra.Command{
+		Use:  "restore",
+		Args: cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			check(newClient().TrashRestore(context.Background(), args[0]))
+			newLogger().Infof("Restored %s from the trash", args[0])
+		},
+	})
+	c.AddCommand(&cobra.Command{
+		Use:  "purge",
+		Args: cobra.MaximumNArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			file := ""
+			if len(args) > 0 {
+				file = args[0]
+			}
+			purged, err := newClient().TrashPurge(context.Background(), file)
+			check(err)
+			newLogger().Infof("Purged %d trash entries", purged)
+		},
+	})
+	return c
+}
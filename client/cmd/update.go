@@ -1,10 +1,11 @@
 package cmd
 
 import (
-	"os"
+	"context"
 
 	"filestore/client/store"
 	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
 )
 
 func init() {
@@ -15,12 +16,24 @@ func init() {
 func RegisterUpdateCommand() *cobra.Command {
 	c := &cobra.Command{
 		Use:  "update",
+		Args: cobra.ExactArgs(1),
 		Run: func(cmd *cobra.Command, args []string) {
-			c := store.NewClient()
-			if err := c.Update(args[0]); err != nil {
-				os.Exit(1)
+			labels, err := labelsFlag()
+			check(err)
+			opts := writeOptions(labels)
+			if etag := viper.GetString("if-match"); etag != "" {
+				opts = append(opts, store.WithIfMatch(etag))
+			} else if viper.GetBool("safe") {
+				opts = append(opts, store.WithSafeUpdate())
 			}
+			fi, err := uploadClient().Update(context.Background(), args[0], opts...)
+			check(err)
+			newLogger().Infof("Updated %s, %d bytes", fi.Name, fi.Size)
 		},
 	}
+	uploadFlags(c)
+	addFlag(c.Flags(), &flag{name: "if-match", desc: "only update the file if its current ETag is this one, as shown by stat"})
+	addFlag(c.Flags(), &flag{name: "safe", desc: "read the ETag of the file first and refuse to overwrite changes made in the meantime", kind: "bool"})
+	addFlag(c.Flags(), &flag{name: "label", desc: "label to attach to the file as key=value, can be repeated", kind: "stringSlice"})
 	return c
 }
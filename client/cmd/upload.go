@@ -0,0 +1,79 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"filestore/client/store"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// progressWidth is the number of characters of the progress bar
+const progressWidth = 30
+
+// uploadFlags adds the flags of the commands uploading files
+func uploadFlags(c *cobra.Command) {
+	addFlag(c.Flags(), &flag{name: "progress", desc: "show the upload progress of every file on stderr", kind: "bool"})
+	addFlag(c.Flags(), &flag{name: "resumable-threshold", desc: "size in bytes from which files are sent through resumable uploads, 0 to disable them", defaultValue: store.DefaultResumableThreshold, kind: "int"})
+	addFlag(c.Flags(), &flag{name: "chunk-size", desc: "size in bytes of the chunks of resumable uploads", defaultValue: store.DefaultChunkSize, kind: "int"})
+}
+
+// uploadClient returns a client sending large files through resumable uploads as the flags say
+func uploadClient() *store.Client {
+	return newClient(store.WithResumableThreshold(viper.GetInt64("resumable-threshold"), viper.GetInt64("chunk-size")))
+}
+
+// progressBar draws on w the upload progress of one file at a time
+type progressBar struct {
+	w       io.Writer
+	name    string
+	percent int
+}
+
+// writeOptions returns the options of uploads, with a progress bar on stderr when --progress is set
+func writeOptions(labels map[string]string) []store.WriteOption {
+	opts := []store.WriteOption{store.WithLabels(labels)}
+	if viper.GetBool("progress") {
+		opts = append(opts, store.WithProgress((&progressBar{w: os.Stderr}).update))
+	}
+	return opts
+}
+
+// update redraws the bar, it is a store.ProgressFunc
+func (p *progressBar) update(name string, sent, total int64) {
+	percent := -1
+	if total > 0 {
+		percent = int(sent * 100 / total)
+	}
+	if name == p.name && percent == p.percent && sent != total {
+		return
+	}
+	p.name, p.percent = name, percent
+	if percent < 0 {
+		fmt.Fprintf(p.w, "\r%s %s", name, formatBytes(sent))
+		return
+	}
+	done := progressWidth * percent / 100
+	fmt.Fprintf(p.w, "\r%s [%s%s] %3d%% %s/%s", name, strings.Repeat("=", done),
+		strings.Repeat(" ", progressWidth-done), percent, formatBytes(sent), formatBytes(total))
+	if sent == total {
+		fmt.Fprintln(p.w)
+	}
+}
+
+// formatBytes returns n in a human readable unit
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%dB", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f%ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
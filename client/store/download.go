@@ -0,0 +1,150 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// RemoteFile is a file of the store read with ranged requests
+type RemoteFile struct {
+	c    *Client
+	ctx  context.Context
+	name string
+	// Size and ETag describe the content of the file when it was opened
+	Size int64
+	ETag string
+}
+
+// OpenFile describes a file of the store for ranged reads, the reads are sent with ctx
+func (c *Client) OpenFile(ctx context.Context, name string) (*RemoteFile, error) {
+	resp, err := c.do(ctx, "HEAD", c.fileURL(name), nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	size, err := strconv.ParseInt(resp.Header.Get("Content-Length"), 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid size of %s: %v", name, err)
+	}
+	return &RemoteFile{c: c, ctx: ctx, name: name, Size: size, ETag: resp.Header.Get("ETag")}, nil
+}
+
+// ReadAt reads len(p) bytes of the file starting at off, it implements io.ReaderAt. It fails
+// with ErrChanged when the content of the file is not the one it was opened with.
+func (f *RemoteFile) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, fmt.Errorf("negative offset %d", off)
+	}
+	if off >= f.Size || len(p) == 0 {
+		return 0, io.EOF
+	}
+	end := off + int64(len(p)) - 1
+	if end >= f.Size {
+		end = f.Size - 1
+	}
+	header := http.Header{"Range": {fmt.Sprintf("bytes=%d-%d", off, end)}}
+	if f.ETag != "" {
+		header.Set("If-Range", f.ETag)
+	}
+	resp, err := f.c.do(f.ctx, "GET", f.c.fileURL(f.name), nil, header)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusPartialContent {
+		return 0, ErrChanged
+	}
+	n, err := io.ReadFull(resp.Body, p[:end-off+1])
+	if err != nil {
+		return n, err
+	}
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// fileURL returns the url of a file in the v2 API
+func (c *Client) fileURL(name string) string {
+	return c.url("v2/files/"+url.PathEscape(name), nil)
+}
+
+// Download writes the content of a file to the local file dest. Interrupted transfers are
+// resumed from the bytes already written, up to MaxRetries times in a row, and started over
+// when the file changed meanwhile. The content goes to a temporary file renamed to dest once
+// complete, dest is left untouched when the download fails.
+func (c *Client) Download(ctx context.Context, name, dest string) (err error) {
+	var out *os.File
+	defer func() {
+		if out != nil && err != nil {
+			out.Close()
+			os.Remove(out.Name())
+		}
+	}()
+	var written int64
+	var tag string
+	failures := 0
+	for {
+		header := http.Header{}
+		if written > 0 && tag != "" {
+			header.Set("Range", fmt.Sprintf("bytes=%d-", written))
+			header.Set("If-Range", tag)
+		}
+		resp, err := c.do(ctx, "GET", c.fileURL(name), nil, header)
+		if err != nil {
+			if err := c.retry(ctx, err, &failures); err != nil {
+				return err
+			}
+			continue
+		}
+		if out == nil {
+			if out, err = ioutil.TempFile(filepath.Dir(dest), "."+filepath.Base(dest)+".download-"); err != nil {
+				resp.Body.Close()
+				return err
+			}
+		}
+		if resp.StatusCode != http.StatusPartialContent && written > 0 {
+			// the file changed or cant be resumed, start over
+			if _, err := out.Seek(0, io.SeekStart); err != nil {
+				resp.Body.Close()
+				return err
+			}
+			if err := out.Truncate(0); err != nil {
+				resp.Body.Close()
+				return err
+			}
+			written = 0
+		}
+		tag = resp.Header.Get("ETag")
+		n, err := io.Copy(out, resp.Body)
+		resp.Body.Close()
+		written += n
+		if err == nil {
+			return finishDownload(out, dest)
+		}
+		if n > 0 {
+			failures = 0
+		}
+		if err := c.retry(ctx, err, &failures); err != nil {
+			return err
+		}
+	}
+}
+
+// finishDownload closes the temporary file of a complete download and renames it to dest
+func finishDownload(out *os.File, dest string) error {
+	if err := out.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(out.Name(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(out.Name(), dest)
+}
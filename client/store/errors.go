@@ -0,0 +1,111 @@
+package store
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+var (
+	// ErrNotFound is matched by errors about missing files, versions or trash entries
+	ErrNotFound = errors.New("not found")
+	// ErrAlreadyExists is matched by errors about files that already exist
+	ErrAlreadyExists = errors.New("already exists")
+	// ErrPreconditionFailed is matched by errors about writes refused because the file
+	// changed since its ETag was read
+	ErrPreconditionFailed = errors.New("precondition failed")
+	// ErrChanged is returned by ranged reads of a file whose content changed since it was opened
+	ErrChanged = errors.New("file changed since it was opened")
+)
+
+// Error is returned when the server answers with an error status
+type Error struct {
+	StatusCode int
+	// Code is the machine readable error code, it is empty for v1 endpoints
+	// unless the server rejected a file name
+	Code      string
+	Message   string
+	RequestID string
+}
+
+// Error describes the error as reported by the server
+func (e *Error) Error() string {
+	msg := fmt.Sprintf("%d %s", e.StatusCode, http.StatusText(e.StatusCode))
+	if e.Message != "" {
+		msg += ": " + e.Message
+	}
+	if e.RequestID != "" {
+		msg += fmt.Sprintf(" (request %s)", e.RequestID)
+	}
+	return msg
+}
+
+// Is reports whether the error matches ErrNotFound, ErrAlreadyExists or ErrPreconditionFailed
+func (e *Error) Is(target error) bool {
+	switch target {
+	case ErrNotFound:
+		return e.StatusCode == http.StatusNotFound
+	case ErrAlreadyExists:
+		return e.StatusCode == http.StatusConflict
+	case ErrPreconditionFailed:
+		return e.StatusCode == http.StatusPreconditionFailed
+	}
+	return false
+}
+
+// responseError reads the error response of the server, v2 endpoints answer with a
+// JSON object and v1 endpoints with a text message
+func responseError(resp *http.Response) error {
+	e := &Error{StatusCode: resp.StatusCode, RequestID: resp.Header.Get("X-Request-ID")}
+	b, _ := ioutil.ReadAll(resp.Body)
+	var body struct {
+		Code      string `json:"code"`
+		Message   string `json:"message"`
+		RequestID string `json:"request_id"`
+	}
+	if strings.HasPrefix(resp.Header.Get("Content-Type"), "application/json") && json.Unmarshal(b, &body) == nil {
+		e.Code, e.Message = body.Code, body.Message
+		if body.RequestID != "" {
+			e.RequestID = body.RequestID
+		}
+		return e
+	}
+	e.Message = strings.TrimSpace(string(b))
+	// v1 endpoints prefix rejected file names messages with the error code
+	if i := strings.Index(e.Message, ": "); i > 0 && strings.HasPrefix(e.Message, "name_") {
+		e.Code = e.Message[:i]
+	}
+	return e
+}
+
+// FileError reports a file of the store the server could not scan
+type FileError struct {
+	Name  string `json:"name"`
+	Error string `json:"error"`
+}
+
+// ScanError is returned along with statistics computed without the files the server could
+// not scan, the statistics covering the other files
+type ScanError struct {
+	Files []FileError
+}
+
+// Error lists the files that could not be scanned
+func (e *ScanError) Error() string {
+	files := make([]string, 0, len(e.Files))
+	for _, f := range e.Files {
+		files = append(files, f.Name+": "+f.Error)
+	}
+	return fmt.Sprintf("could not scan %d files: %s", len(e.Files), strings.Join(files, "; "))
+}
+
+// scanError returns a ScanError reporting files, nil when there are none
+func scanError(files []FileError) error {
+	if len(files) == 0 {
+		return nil
+	}
+	return &ScanError{Files: files}
+}
@@ -0,0 +1,376 @@
+package store
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// FileInfo is the metadata record the server keeps for a file
+type FileInfo struct {
+	Name        string            `json:"name"`
+	Size        int64             `json:"size"`
+	SHA256      string            `json:"sha256"`
+	ContentType string            `json:"content_type"`
+	Created     time.Time         `json:"created"`
+	Modified    time.Time         `json:"modified"`
+	Uploader    string            `json:"uploader,omitempty"`
+	Labels      map[string]string `json:"labels,omitempty"`
+}
+
+// ETag returns the entity tag of the content of the file, as used by WithIfMatch
+func (fi *FileInfo) ETag() string {
+	return `"` + fi.SHA256 + `"`
+}
+
+// Version is a previous content of a file
+type Version struct {
+	Version  int
+	Size     int64
+	Modified time.Time
+}
+
+// WriteOption configures the write of a file
+type WriteOption func(*writeOptions)
+
+type writeOptions struct {
+	labels      map[string]string
+	progress    ProgressFunc
+	ifMatch     string
+	ifNoneMatch string
+	safe        bool
+}
+
+// ProgressFunc is called as the content of a file is sent with the number of bytes sent
+// so far, total is -1 when the size of the content is unknown
+type ProgressFunc func(name string, sent, total int64)
+
+// WithLabels attaches labels to the written files, they replace the labels of existing files
+func WithLabels(labels map[string]string) WriteOption {
+	return func(o *writeOptions) {
+		o.labels = labels
+	}
+}
+
+// WithProgress reports the progress of the upload of every file to f
+func WithProgress(f ProgressFunc) WriteOption {
+	return func(o *writeOptions) {
+		o.progress = f
+	}
+}
+
+// WithIfMatch makes Put, Update and Remove fail with ErrPreconditionFailed unless the
+// current content of the file has the ETag etag, "*" matching any existing file
+func WithIfMatch(etag string) WriteOption {
+	return func(o *writeOptions) {
+		o.ifMatch = etag
+	}
+}
+
+// WithSafeUpdate makes Put and Update read the ETag of the file before writing it and fail
+// with ErrPreconditionFailed if the file changes, or is created, in the meantime. It has no
+// effect with WithIfMatch.
+func WithSafeUpdate() WriteOption {
+	return func(o *writeOptions) {
+		o.safe = true
+	}
+}
+
+// newWriteOptions applies opts
+func newWriteOptions(opts []WriteOption) *writeOptions {
+	o := &writeOptions{}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// query returns the query parameters of a write
+func (o *writeOptions) query() url.Values {
+	query := url.Values{}
+	keys := make([]string, 0, len(o.labels))
+	for k := range o.labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		query.Add("label", k+"="+o.labels[k])
+	}
+	return query
+}
+
+// header returns the precondition headers of a write
+func (o *writeOptions) header() http.Header {
+	header := http.Header{}
+	if o.ifMatch != "" {
+		header.Set("If-Match", o.ifMatch)
+	}
+	if o.ifNoneMatch != "" {
+		header.Set("If-None-Match", o.ifNoneMatch)
+	}
+	return header
+}
+
+// guard sets the precondition of a safe update of name to the current ETag of the file,
+// or to the absence of the file when it doesnt exist
+func (c *Client) guard(ctx context.Context, name string, o *writeOptions) error {
+	if !o.safe || o.ifMatch != "" {
+		return nil
+	}
+	fi, err := c.Stat(ctx, name)
+	if errors.Is(err, ErrNotFound) {
+		o.ifNoneMatch = "*"
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	o.ifMatch = fi.ETag()
+	return nil
+}
+
+// track wraps r to report its progress when a progress function is set
+func (o *writeOptions) track(name string, r io.Reader, total int64) io.Reader {
+	if o.progress == nil {
+		return r
+	}
+	o.progress(name, 0, total)
+	return &progressReader{r: r, name: name, total: total, progress: o.progress}
+}
+
+// trackFrom wraps r, the part of a content starting at offset, to report its progress
+func (o *writeOptions) trackFrom(name string, r io.Reader, offset, total int64) io.Reader {
+	if o.progress == nil {
+		return r
+	}
+	return &progressReader{r: r, name: name, sent: offset, total: total, progress: o.progress}
+}
+
+// progressReader reports the bytes read through it
+type progressReader struct {
+	r        io.Reader
+	name     string
+	sent     int64
+	total    int64
+	progress ProgressFunc
+}
+
+// Read reads from the wrapped reader and reports the progress
+func (p *progressReader) Read(b []byte) (int, error) {
+	n, err := p.r.Read(b)
+	if n > 0 {
+		p.sent += int64(n)
+		p.progress(p.name, p.sent, p.total)
+	}
+	return n, err
+}
+
+// multipartBody streams files as a multipart body, files are read while the body is sent.
+// Files are checked before anything is sent so missing files fail early.
+func multipartBody(files []string, o *writeOptions) (io.ReadCloser, string, error) {
+	for _, fn := range files {
+		if _, err := os.Stat(fn); err != nil {
+			return nil, "", err
+		}
+	}
+	pr, pw := io.Pipe()
+	bodyWriter := multipart.NewWriter(pw)
+	go func() {
+		for _, fn := range files {
+			if err := writePart(bodyWriter, fn, o); err != nil {
+				pw.CloseWithError(err) // nolint: errcheck
+				return
+			}
+		}
+		pw.CloseWithError(bodyWriter.Close()) // nolint: errcheck
+	}()
+	return pr, bodyWriter.FormDataContentType(), nil
+}
+
+// writePart writes a local file as a part of a multipart body
+func writePart(bodyWriter *multipart.Writer, fn string, o *writeOptions) error {
+	file, err := os.Open(fn)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	fi, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	part, err := bodyWriter.CreateFormFile(fn, fi.Name())
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(part, o.track(fi.Name(), file, fi.Size()))
+	return err
+}
+
+// Add adds local files to the store under their base name, it fails with ErrAlreadyExists
+// when one of them is already in the store. Files of ResumableThreshold bytes or more are
+// sent through resumable uploads, the others together in a multipart request.
+func (c *Client) Add(ctx context.Context, files []string, opts ...WriteOption) error {
+	o := newWriteOptions(opts)
+	var small []string
+	for _, file := range files {
+		fi, err := os.Stat(file)
+		if err != nil {
+			return err
+		}
+		if !c.resumable(fi.Size()) {
+			small = append(small, file)
+			continue
+		}
+		if _, err := c.uploadFile(ctx, file, filepath.Base(file), false, o); err != nil {
+			return err
+		}
+	}
+	if len(small) == 0 {
+		return nil
+	}
+	body, contentType, err := multipartBody(small, o)
+	if err != nil {
+		return err
+	}
+	resp, err := c.do(ctx, "POST", c.url("add", o.query()), body, http.Header{"Content-Type": {contentType}})
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+// Put writes the content of r as name, replacing the current content of the file if any
+func (c *Client) Put(ctx context.Context, name string, r io.Reader, opts ...WriteOption) (*FileInfo, error) {
+	o := newWriteOptions(opts)
+	if err := c.guard(ctx, name, o); err != nil {
+		return nil, err
+	}
+	return c.put(ctx, name, r, -1, o)
+}
+
+// put writes the content of r, of size bytes or -1 when unknown, as name
+func (c *Client) put(ctx context.Context, name string, r io.Reader, size int64, o *writeOptions) (*FileInfo, error) {
+	resp, err := c.do(ctx, "PUT", c.url("v2/files/"+url.PathEscape(name), o.query()), o.track(name, r, size), o.header())
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	fi := &FileInfo{}
+	if err := decodeJSON(resp.Body, fi); err != nil {
+		return nil, err
+	}
+	return fi, nil
+}
+
+// Update writes a local file to the store under its base name, replacing the current content.
+// Files of ResumableThreshold bytes or more are sent through resumable uploads.
+func (c *Client) Update(ctx context.Context, file string, opts ...WriteOption) (*FileInfo, error) {
+	fi, err := os.Stat(file)
+	if err != nil {
+		return nil, err
+	}
+	o := newWriteOptions(opts)
+	if err := c.guard(ctx, filepath.Base(file), o); err != nil {
+		return nil, err
+	}
+	if c.resumable(fi.Size()) {
+		return c.uploadFile(ctx, file, filepath.Base(file), true, o)
+	}
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return c.put(ctx, filepath.Base(file), f, fi.Size(), o)
+}
+
+// Remove moves a file of the store to the trash, WithIfMatch is the only option it honors
+func (c *Client) Remove(ctx context.Context, name string, opts ...WriteOption) error {
+	resp, err := c.do(ctx, "DELETE", c.fileURL(name), nil, newWriteOptions(opts).header())
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+// List returns the metadata records of the files in the store
+func (c *Client) List(ctx context.Context) ([]FileInfo, error) {
+	var files []FileInfo
+	if err := c.getJSON(ctx, c.url("v2/files", nil), &files); err != nil {
+		return nil, err
+	}
+	return files, nil
+}
+
+// Stat returns the metadata record of a file
+func (c *Client) Stat(ctx context.Context, name string) (*FileInfo, error) {
+	fi := &FileInfo{}
+	if err := c.getJSON(ctx, c.url("stat", url.Values{"file": {name}}), fi); err != nil {
+		return nil, err
+	}
+	return fi, nil
+}
+
+// Open returns the content of a file, or of one of its versions when version is not 0.
+// The caller closes it.
+func (c *Client) Open(ctx context.Context, name string, version int) (io.ReadCloser, error) {
+	query := url.Values{}
+	if version > 0 {
+		query.Set("version", strconv.Itoa(version))
+	}
+	resp, err := c.do(ctx, "GET", c.url("v2/files/"+url.PathEscape(name), query), nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// Versions returns the previous versions of a file, oldest first
+func (c *Client) Versions(ctx context.Context, name string) ([]Version, error) {
+	b, err := c.call(ctx, "GET", c.url("versions", url.Values{"file": {name}}))
+	if err != nil {
+		return nil, err
+	}
+	var versions []Version
+	scanner := bufio.NewScanner(bytes.NewReader(b))
+	for scanner.Scan() {
+		var v Version
+		var modified string
+		if _, err := fmt.Sscan(scanner.Text(), &v.Version, &v.Size, &modified); err != nil {
+			return nil, fmt.Errorf("invalid version line %q: %v", scanner.Text(), err)
+		}
+		if v.Modified, err = time.Parse(time.RFC3339, modified); err != nil {
+			return nil, fmt.Errorf("invalid version line %q: %v", scanner.Text(), err)
+		}
+		versions = append(versions, v)
+	}
+	return versions, scanner.Err()
+}
+
+// Restore makes a version the current content of a file
+func (c *Client) Restore(ctx context.Context, name string, version int) error {
+	_, err := c.call(ctx, "POST", c.url("restore", url.Values{"file": {name}, "version": {strconv.Itoa(version)}}))
+	return err
+}
+
+// FormatLabels returns labels as sorted key=value pairs
+func FormatLabels(labels map[string]string) string {
+	pairs := make([]string, 0, len(labels))
+	for k, v := range labels {
+		pairs = append(pairs, k+"="+v)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
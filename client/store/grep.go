@@ -0,0 +1,66 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/url"
+	"strconv"
+)
+
+// GrepLine is a line matching a grep pattern or one of its context lines
+type GrepLine struct {
+	File string `json:"file"`
+	Line int    `json:"line"`
+	Text string `json:"text"`
+	// Match is false for context lines, Ranges are the byte ranges of the matches in Text
+	Match  bool     `json:"match"`
+	Ranges [][2]int `json:"ranges,omitempty"`
+	// Error reports a file the server could not search, other fields but File are empty
+	Error string `json:"error,omitempty"`
+}
+
+// GrepOption configures a grep
+type GrepOption func(url.Values)
+
+// WithGlob restricts a grep to the files matching glob
+func WithGlob(glob string) GrepOption {
+	return func(query url.Values) {
+		query.Set("glob", glob)
+	}
+}
+
+// WithContextLines sends before and after lines around the matching lines
+func WithContextLines(before, after int) GrepOption {
+	return func(query url.Values) {
+		query.Set("before", strconv.Itoa(before))
+		query.Set("after", strconv.Itoa(after))
+	}
+}
+
+// Grep calls fn with the lines of the stored files matching the regular expression
+// pattern as the server finds them. The lines of a file come together and in order,
+// files come in no particular order. Grep stops with the error of fn if it fails.
+func (c *Client) Grep(ctx context.Context, pattern string, fn func(GrepLine) error, opts ...GrepOption) error {
+	query := url.Values{"pattern": {pattern}}
+	for _, opt := range opts {
+		opt(query)
+	}
+	resp, err := c.do(ctx, "GET", c.url("grep", query), nil, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	dec := json.NewDecoder(resp.Body)
+	for {
+		var line GrepLine
+		if err := dec.Decode(&line); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if err := fn(line); err != nil {
+			return err
+		}
+	}
+}
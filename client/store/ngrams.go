@@ -0,0 +1,32 @@
+package store
+
+import (
+	"context"
+	"net/url"
+	"strconv"
+)
+
+// ngramStats is the response of the n-gram statistics endpoint
+type ngramStats struct {
+	N      int         `json:"n"`
+	Count  int         `json:"count"`
+	Ngrams []WordFreq  `json:"ngrams"`
+	Errors []FileError `json:"errors"`
+}
+
+// FreqNgrams returns the limit most frequent sequences of n words of the store, n being 2
+// to 5, when order is dsc, the least frequent when it is asc. The words of an n-gram are
+// separated by a space in Word. Stopwords given with WithStopwords break n-grams. When the
+// server could not scan some files, the n-grams of the other files are returned with a
+// *ScanError.
+func (c *Client) FreqNgrams(ctx context.Context, n, limit int, order string, opts ...StatsOption) ([]WordFreq, error) {
+	query := url.Values{"n": {strconv.Itoa(n)}, "limit": {strconv.Itoa(limit)}, "order": {order}}
+	for _, opt := range opts {
+		opt(query)
+	}
+	stats := &ngramStats{}
+	if err := c.getJSON(ctx, c.url("v2/stats/ngrams", query), stats); err != nil {
+		return nil, err
+	}
+	return stats.Ngrams, scanError(stats.Errors)
+}
@@ -0,0 +1,34 @@
+package store
+
+import (
+	"context"
+	"net/url"
+	"strconv"
+)
+
+// SearchHit is a file matching a search query
+type SearchHit struct {
+	Name  string  `json:"name"`
+	Score float64 `json:"score"`
+	// Snippet is an excerpt of the file around its first match, Highlights are the
+	// byte ranges of the matching words in it
+	Snippet    string   `json:"snippet"`
+	Highlights [][2]int `json:"highlights"`
+}
+
+// SearchResults are the best files matching a query out of Total matching files
+type SearchResults struct {
+	Total int         `json:"total"`
+	Hits  []SearchHit `json:"hits"`
+}
+
+// Search returns the limit files most relevant to query. Words of a query must all be
+// found unless OR is put between them, NOT or a leading - excludes files containing a
+// word, double quotes match phrases and a trailing * matches words by prefix.
+func (c *Client) Search(ctx context.Context, query string, limit int) (*SearchResults, error) {
+	results := &SearchResults{}
+	if err := c.getJSON(ctx, c.url("search", url.Values{"q": {query}, "limit": {strconv.Itoa(limit)}}), results); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
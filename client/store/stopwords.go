@@ -0,0 +1,56 @@
+package store
+
+import (
+	"context"
+	"io"
+	"net/url"
+	"strings"
+)
+
+// WithStopwords leaves the words of stopword lists out of the statistics, lists are named
+// by language code for the built-in ones (en, fr, de, es) or by the name they were put under
+func WithStopwords(lists ...string) StatsOption {
+	return func(query url.Values) {
+		query.Set("stopwords", strings.Join(lists, ","))
+	}
+}
+
+// stopwordsURL returns the url of a stopword list
+func (c *Client) stopwordsURL(name string) string {
+	return c.url("v2/stopwords/"+url.PathEscape(name), nil)
+}
+
+// StopwordLists returns the names of the built-in and custom stopword lists
+func (c *Client) StopwordLists(ctx context.Context) ([]string, error) {
+	var names []string
+	if err := c.getJSON(ctx, c.url("v2/stopwords", nil), &names); err != nil {
+		return nil, err
+	}
+	return names, nil
+}
+
+// Stopwords returns the words of a stopword list
+func (c *Client) Stopwords(ctx context.Context, name string) ([]string, error) {
+	var words []string
+	if err := c.getJSON(ctx, c.stopwordsURL(name), &words); err != nil {
+		return nil, err
+	}
+	return words, nil
+}
+
+// PutStopwords writes the custom stopword list name, replacing it if it exists. The list
+// is read from r as words separated by spaces or newlines, lines starting with # being
+// comments. It returns the words of the list.
+func (c *Client) PutStopwords(ctx context.Context, name string, r io.Reader) ([]string, error) {
+	var words []string
+	if err := c.sendJSON(ctx, "PUT", c.stopwordsURL(name), r, nil, &words); err != nil {
+		return nil, err
+	}
+	return words, nil
+}
+
+// DeleteStopwords removes the custom stopword list name
+func (c *Client) DeleteStopwords(ctx context.Context, name string) error {
+	_, err := c.call(ctx, "DELETE", c.stopwordsURL(name))
+	return err
+}
@@ -1,238 +1,196 @@
 package store
 
 import (
-	"bytes"
+	"context"
+	"encoding/json"
 	"fmt"
 	"io"
 	"io/ioutil"
-	"mime/multipart"
 	"net"
 	"net/http"
+	"net/url"
 	"time"
-	"os"
+)
+
+// UserHeader is the request header naming the user writing files
+const UserHeader = "X-Filestore-User"
 
-	"filestore/helper"
-	"github.com/sirupsen/logrus"
-	"github.com/spf13/viper"
+const (
+	// DefaultConnectTimeout bounds the time spent connecting to the server
+	DefaultConnectTimeout = 5 * time.Second
+	// DefaultIdleTimeout bounds the time a connection goes without sending or receiving anything
+	DefaultIdleTimeout = 30 * time.Second
 )
 
-// Client interface for basic client structure
+// Client talks to a filestore server
 type Client struct {
-	Logger     *logrus.Logger
-	BaseURL string
+	BaseURL    string
 	HTTPClient *http.Client
+	// User is sent as the uploader of the files the client writes
+	User string
+	// ConnectTimeout and IdleTimeout configure the default HTTPClient. There is no limit on
+	// the duration of whole requests so large files can be transferred.
+	ConnectTimeout time.Duration
+	IdleTimeout    time.Duration
+	// Files of ResumableThreshold bytes or more are sent in chunks of ChunkSize bytes
+	// through resumable uploads, 0 disables them
+	ResumableThreshold int64
+	ChunkSize          int64
+	// MaxRetries is the number of errors in a row after which a resumable upload fails,
+	// retries are delayed by RetryDelay doubled after every error
+	MaxRetries int
+	RetryDelay time.Duration
 }
 
-// NewClient creates a new client
-func NewClient() *Client {
-	return &Client{
-		BaseURL: viper.GetString("server-url"),
-		Logger: helper.NewLogger("filestore"),
-		HTTPClient: &http.Client {
-			Transport: &http.Transport{
-				Dial: (&net.Dialer{
-					Timeout: 5 * time.Second,
-				}).Dial,
-			},
-			Timeout: time.Second * 10,
-		},
+// Option configures a Client
+type Option func(*Client)
+
+// WithHTTPClient makes the client send its requests with h, the client timeouts are then ignored
+func WithHTTPClient(h *http.Client) Option {
+	return func(c *Client) {
+		c.HTTPClient = h
 	}
 }
 
-// newStoreRequest builds a request for the store server
-func newStoreRequest(method, url string, body *bytes.Buffer) (*http.Request, error){
-	req, err := http.NewRequest(method, url, body)
-	if err != nil {
-		return req, err
+// WithUser names the uploader of the files the client writes
+func WithUser(user string) Option {
+	return func(c *Client) {
+		c.User = user
 	}
-	return req, nil
 }
 
-// multipartBody adds files to a multipart writer
-func multipartBody(files []string) (*bytes.Buffer, string, error) {
-	bodyBuffer := new(bytes.Buffer)
-	bodyWriter := multipart.NewWriter(bodyBuffer)
-	for _, fn := range files {
-		file, err := os.Open(fn)
-		if err != nil {
-			return nil, "", err
-		}
-		defer file.Close()
-		fi, err := file.Stat()
-		if err != nil {
-			return nil, "", err
-		}
-		part, err := bodyWriter.CreateFormFile(fn, fi.Name())
-		if err != nil {
-			return nil, "", err
-		}
-		_, err = io.Copy(part, file)
-		if err != nil {
-			return nil, "", err
-		}
-	}
-	err := bodyWriter.Close()
-	if err != nil {
-		return nil, "", err
+// WithConnectTimeout bounds the time spent connecting to the server
+func WithConnectTimeout(d time.Duration) Option {
+	return func(c *Client) {
+		c.ConnectTimeout = d
 	}
-	return bodyBuffer, bodyWriter.FormDataContentType(), nil
 }
 
-// Add adds files to the store
-func (c *Client) Add(files []string) error {
-	bodyBuffer, contentType, err := multipartBody(files) 
-	if err != nil {
-		c.Logger.Fatalf("Could not build request %v", err)
-		return err
+// WithIdleTimeout bounds the time a connection goes without sending or receiving anything,
+// 0 disables the timeout
+func WithIdleTimeout(d time.Duration) Option {
+	return func(c *Client) {
+		c.IdleTimeout = d
 	}
+}
 
-	req, err := newStoreRequest("POST", fmt.Sprintf("%s/add", c.BaseURL), bodyBuffer)
-	c.Logger.Debugf("req %v", req)
-	if err != nil {
-		c.Logger.Fatalf("Could not build request %v", err)
-		return err
+// NewClient creates a client of the server at baseURL
+func NewClient(baseURL string, opts ...Option) *Client {
+	c := &Client{
+		BaseURL:            baseURL,
+		ConnectTimeout:     DefaultConnectTimeout,
+		IdleTimeout:        DefaultIdleTimeout,
+		ResumableThreshold: DefaultResumableThreshold,
+		ChunkSize:          DefaultChunkSize,
+		MaxRetries:         DefaultMaxRetries,
+		RetryDelay:         time.Second,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	if c.HTTPClient == nil {
+		c.HTTPClient = &http.Client{
+			Transport: &http.Transport{
+				Proxy:           http.ProxyFromEnvironment,
+				DialContext:     c.dialContext,
+				IdleConnTimeout: 90 * time.Second,
+			},
+		}
 	}
-	req.Header.Add("Content-Type", contentType)
+	return c
+}
 
-	resp, err := c.HTTPClient.Do(req)
-	if err != nil {
-		c.Logger.Fatalf("Could not get response %v", err)
-		return err
-	}
-	
-	defer resp.Body.Close()
-	b, err := ioutil.ReadAll(resp.Body)
-	if !(resp.StatusCode == http.StatusOK || resp.StatusCode == http.StatusCreated) || (err != nil) {
-		c.Logger.Errorf("HTTPStatusCode: '%d'; ResponseMessage: '%s'; ErrorMessage: '%v'", resp.StatusCode, string(b), err)
-		return fmt.Errorf("HTTPStatusCode: '%d'; ResponseMessage: '%s'; ErrorMessage: '%v'", resp.StatusCode, string(b), err)
+// dialContext connects to the server within ConnectTimeout and returns a connection
+// failing when it stays idle for IdleTimeout
+func (c *Client) dialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	conn, err := (&net.Dialer{Timeout: c.ConnectTimeout}).DialContext(ctx, network, addr)
+	if err != nil || c.IdleTimeout <= 0 {
+		return conn, err
 	}
-	c.Logger.Infof("HTTPStatusCode: '%d'; ResponseMessage: '%s'", resp.StatusCode, string(b))
-	return nil
+	return &idleTimeoutConn{Conn: conn, timeout: c.IdleTimeout}, nil
 }
 
-// Remove removes the file from the store
-func (c *Client) Remove(file string) error {
-	req, err := http.NewRequest("POST", fmt.Sprintf("%s/remove?file=%s", c.BaseURL, file), nil)
-	c.Logger.Debugf("req %v", req)
-	if err != nil {
-		c.Logger.Fatalf("Could not build request %v", err)
-		return err
-	}
-	req.Header.Add("Content-Type", "application/x-www-form-urlencoded; param=value")
+// idleTimeoutConn pushes back the deadline of a connection every time data is sent or
+// received. Both directions share the deadline so a long upload keeps alive the read
+// waiting for its response.
+type idleTimeoutConn struct {
+	net.Conn
+	timeout time.Duration
+}
 
-	resp, err := c.HTTPClient.Do(req)
-	if err != nil {
-		c.Logger.Fatalf("Could not get response %v", err)
-		return err
-	}
-	defer resp.Body.Close()
-	b, err := ioutil.ReadAll(resp.Body)
-	if !(resp.StatusCode == http.StatusOK || resp.StatusCode == http.StatusNoContent) || (err != nil) {
-		c.Logger.Errorf("HTTPStatusCode: '%d'; ResponseMessage: '%s'; ErrorMessage: '%v'", resp.StatusCode, string(b), err)
-		return fmt.Errorf("HTTPStatusCode: '%d'; ResponseMessage: '%s'; ErrorMessage: '%v'", resp.StatusCode, string(b), err)
+// Read reads from the connection after pushing back its deadline
+func (c *idleTimeoutConn) Read(b []byte) (int, error) {
+	if err := c.Conn.SetDeadline(time.Now().Add(c.timeout)); err != nil {
+		return 0, err
 	}
-	c.Logger.Infof("HTTPStatusCode: '%d'; ResponseMessage: '%s'", resp.StatusCode, string(b))
-	return nil
+	return c.Conn.Read(b)
 }
 
-// List lists all files in the store
-func (c *Client) List() error {
-	req, err := http.NewRequest("GET", fmt.Sprintf("%s/list", c.BaseURL), nil)
-	c.Logger.Debugf("request %v", req)
-	if err != nil {
-		return err
+// Write writes to the connection after pushing back its deadline
+func (c *idleTimeoutConn) Write(b []byte) (int, error) {
+	if err := c.Conn.SetDeadline(time.Now().Add(c.timeout)); err != nil {
+		return 0, err
 	}
-	resp, err := c.HTTPClient.Do(req)
-	if err != nil {
-		c.Logger.Fatalf("Could not get response %v", err)
-		return err
-	}
-	defer resp.Body.Close()
-	b, err := ioutil.ReadAll(resp.Body)
-	if !(resp.StatusCode == http.StatusOK || resp.StatusCode == http.StatusNoContent) || (err != nil) {
-		c.Logger.Errorf("HTTPStatusCode: '%d'; ResponseMessage: '%s'; ErrorMessage: '%v'", resp.StatusCode, string(b), err)
-		return fmt.Errorf("HTTPStatusCode: '%d'; ResponseMessage: '%s'; ErrorMessage: '%v'", resp.StatusCode, string(b), err)
+	return c.Conn.Write(b)
+}
+
+// url returns the url of a server endpoint with query parameters
+func (c *Client) url(endpoint string, query url.Values) string {
+	if len(query) == 0 {
+		return fmt.Sprintf("%s/%s", c.BaseURL, endpoint)
 	}
-	c.Logger.Debugf("HTTPStatusCode: '%d'", resp.StatusCode)
-	fmt.Fprintln(os.Stdout, string(b))
-	return nil
+	return fmt.Sprintf("%s/%s?%s", c.BaseURL, endpoint, query.Encode())
 }
 
-// Update updates or create a file in the store
-func (c *Client) Update(file string) error {
-	bodyBuffer, contentType, err := multipartBody([]string{file})
+// do sends a request and returns the response when its status is a success, the
+// caller closes the response body. Other statuses are returned as an *Error.
+func (c *Client) do(ctx context.Context, method, u string, body io.Reader, header http.Header) (*http.Response, error) {
+	req, err := http.NewRequest(method, u, body)
 	if err != nil {
-		c.Logger.Fatalf("Could not read body %v", err)
-		return err
+		return nil, err
 	}
-	req, err := newStoreRequest("POST", fmt.Sprintf("%s/update", c.BaseURL), bodyBuffer)
-	c.Logger.Debugf("request %v", req)
-	if err != nil {
-		c.Logger.Fatalf("Could not build request %v", err)
-		return err
+	req = req.WithContext(ctx)
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	if c.User != "" {
+		req.Header.Set(UserHeader, c.User)
 	}
-	req.Header.Add("Content-Type", contentType)
-
 	resp, err := c.HTTPClient.Do(req)
 	if err != nil {
-		c.Logger.Fatalf("Could not get response %v", err)
-		return err
+		return nil, err
 	}
-	defer resp.Body.Close()
-	b, err := ioutil.ReadAll(resp.Body)
-	if !(resp.StatusCode == http.StatusOK || resp.StatusCode == http.StatusNoContent) || (err != nil) {
-		c.Logger.Errorf("HTTPStatusCode: '%d'; ResponseMessage: '%s'; ErrorMessage: '%v'", resp.StatusCode, string(b), err)
-		return fmt.Errorf("HTTPStatusCode: '%d'; ResponseMessage: '%s'; ErrorMessage: '%v'", resp.StatusCode, string(b), err)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		defer resp.Body.Close()
+		return nil, responseError(resp)
 	}
-	c.Logger.Infof("HTTPStatusCode: '%d'; ResponseMessage: '%s'", resp.StatusCode, string(b))
-	return nil
+	return resp, nil
 }
 
-
-// FreqWords prints most 10 frequent words
-func (c *Client) FreqWords() error {
-	limit := viper.GetInt("limit")
-	order := viper.GetString("order")
-	req, err := http.NewRequest("GET", fmt.Sprintf("%s/freqwords?limit=%d&order=%s", c.BaseURL, limit, order), nil)
-	c.Logger.Debugf("request %v", req)
+// call sends a request without body and returns the response body
+func (c *Client) call(ctx context.Context, method, u string) ([]byte, error) {
+	resp, err := c.do(ctx, method, u, nil, nil)
 	if err != nil {
-		return err
-	}
-	resp, err := c.HTTPClient.Do(req)
-	if err != nil {
-		c.Logger.Fatalf("Could not get response %v", err)
-		return err
+		return nil, err
 	}
 	defer resp.Body.Close()
-	b, err := ioutil.ReadAll(resp.Body)
-	if !(resp.StatusCode == http.StatusOK || resp.StatusCode == http.StatusNoContent) || (err != nil) {
-		c.Logger.Errorf("HTTPStatusCode: '%d'; ResponseMessage: '%s'; ErrorMessage: '%v'", resp.StatusCode, string(b), err)
-		return fmt.Errorf("HTTPStatusCode: '%d'; ResponseMessage: '%s'; ErrorMessage: '%v'", resp.StatusCode, string(b), err)
-	}
-	c.Logger.Debugf("HTTPStatusCode: '%d'", resp.StatusCode)
-	fmt.Fprintln(os.Stdout, string(b))
-	return nil
+	return ioutil.ReadAll(resp.Body)
 }
 
-// CountWords prints most 10 frequent words
-func (c *Client) CountWords() error {
-	req, err := http.NewRequest("GET", fmt.Sprintf("%s/countwords", c.BaseURL), nil)
-	c.Logger.Debugf("request %v", req)
-	if err != nil {
-		return err
-	}
-	resp, err := c.HTTPClient.Do(req)
+// getJSON decodes the JSON response of a GET request to u into v
+func (c *Client) getJSON(ctx context.Context, u string, v interface{}) error {
+	resp, err := c.do(ctx, "GET", u, nil, nil)
 	if err != nil {
-		c.Logger.Fatalf("Could not get response %v", err)
 		return err
 	}
 	defer resp.Body.Close()
-	b, err := ioutil.ReadAll(resp.Body)
-	if !(resp.StatusCode == http.StatusOK || resp.StatusCode == http.StatusNoContent) || (err != nil) {
-		c.Logger.Errorf("HTTPStatusCode: '%d'; ResponseMessage: '%s'; ErrorMessage: '%v'", resp.StatusCode, string(b), err)
-		return fmt.Errorf("HTTPStatusCode: '%d'; ResponseMessage: '%s'; ErrorMessage: '%v'", resp.StatusCode, string(b), err)
+	return decodeJSON(resp.Body, v)
+}
+
+// decodeJSON decodes the JSON response body r into v
+func decodeJSON(r io.Reader, v interface{}) error {
+	if err := json.NewDecoder(r).Decode(v); err != nil {
+		return fmt.Errorf("could not decode response: %v", err)
 	}
-	c.Logger.Debugf("HTTPStatusCode: '%d'", resp.StatusCode)
-	fmt.Fprintln(os.Stdout, string(b))
 	return nil
-}
\ No newline at end of file
+}
@@ -0,0 +1,574 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"filestore/server/filestore"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestServer starts a filestore server on a temporary directory, callers close
+// the server and remove the directory when done
+func newTestServer(t *testing.T) (*httptest.Server, string) {
+	dir, err := ioutil.TempDir("", "filestore")
+	require.NoError(t, err)
+	return httptest.NewServer(newTestHandler(t, dir)), dir
+}
+
+// newTestHandler returns the handler of a filestore server storing files in dir
+func newTestHandler(t *testing.T, dir string) http.Handler {
+	config := filestore.NewConfig()
+	config.StoreDir = dir
+	fs, err := filestore.NewFileStore(config)
+	require.NoError(t, err)
+	return fs.Handler()
+}
+
+func TestClient(t *testing.T) {
+	srv, dir := newTestServer(t)
+	defer os.RemoveAll(dir)
+	defer srv.Close()
+	ctx := context.Background()
+	c := NewClient(srv.URL, WithUser("alice"))
+
+	local, err := ioutil.TempDir("", "client")
+	require.NoError(t, err)
+	defer os.RemoveAll(local)
+	file := filepath.Join(local, "a.txt")
+	require.NoError(t, ioutil.WriteFile(file, []byte("foo bar foo"), 0644))
+
+	require.NoError(t, c.Add(ctx, []string{file}, WithLabels(map[string]string{"team": "ops"})))
+	err = c.Add(ctx, []string{file})
+	require.True(t, errors.Is(err, ErrAlreadyExists), "%v", err)
+
+	files, err := c.List(ctx)
+	require.NoError(t, err)
+	require.Len(t, files, 1)
+	require.Equal(t, "a.txt", files[0].Name)
+	require.Equal(t, "alice", files[0].Uploader)
+	require.Equal(t, map[string]string{"team": "ops"}, files[0].Labels)
+
+	fi, err := c.Put(ctx, "a.txt", strings.NewReader("foo baz"))
+	require.NoError(t, err)
+	require.Equal(t, int64(7), fi.Size)
+	versions, err := c.Versions(ctx, "a.txt")
+	require.NoError(t, err)
+	require.Len(t, versions, 1)
+	require.Equal(t, int64(11), versions[0].Size)
+
+	words, err := c.FreqWords(ctx, 1, "dsc")
+	require.NoError(t, err)
+	require.Equal(t, []WordFreq{{Word: "baz", Count: 1}}, words)
+	count, err := c.CountWords(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 2, count)
+	count, err = c.CountWords(ctx, WithAnalyzer("raw"), WithoutNumbers())
+	require.NoError(t, err)
+	require.Equal(t, 2, count)
+	_, err = c.CountWords(ctx, WithAnalyzer("unknown"))
+	require.Error(t, err)
+
+	content, err := c.Open(ctx, "a.txt", 1)
+	require.NoError(t, err)
+	b, err := ioutil.ReadAll(content)
+	content.Close()
+	require.NoError(t, err)
+	require.Equal(t, "foo bar foo", string(b))
+
+	require.NoError(t, c.Remove(ctx, "a.txt"))
+	_, err = c.Stat(ctx, "a.txt")
+	require.True(t, errors.Is(err, ErrNotFound), "%v", err)
+	entries, err := c.Trash(ctx)
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	require.Equal(t, "a.txt", entries[0].Name)
+	require.Equal(t, int64(7), entries[0].Size)
+	purged, err := c.TrashPurge(ctx, "")
+	require.NoError(t, err)
+	require.Equal(t, 1, purged)
+	err = c.TrashRestore(ctx, "a.txt")
+	require.True(t, errors.Is(err, ErrNotFound), "%v", err)
+}
+
+func TestIfMatch(t *testing.T) {
+	srv, dir := newTestServer(t)
+	defer os.RemoveAll(dir)
+	defer srv.Close()
+	ctx := context.Background()
+	c := NewClient(srv.URL)
+	local, err := ioutil.TempDir("", "client")
+	require.NoError(t, err)
+	defer os.RemoveAll(local)
+	file := filepath.Join(local, "a.txt")
+	require.NoError(t, ioutil.WriteFile(file, []byte("mine"), 0644))
+
+	fi, err := c.Update(ctx, file, WithSafeUpdate())
+	require.NoError(t, err)
+	tag := fi.ETag()
+	_, err = c.Put(ctx, "a.txt", strings.NewReader("theirs"))
+	require.NoError(t, err)
+
+	_, err = c.Update(ctx, file, WithIfMatch(tag))
+	require.True(t, errors.Is(err, ErrPreconditionFailed), "%v", err)
+	// resumable uploads check the precondition when they complete
+	resumable := NewClient(srv.URL, WithResumableThreshold(1, 2))
+	_, err = resumable.Update(ctx, file, WithIfMatch(tag))
+	require.True(t, errors.Is(err, ErrPreconditionFailed), "%v", err)
+	err = c.Remove(ctx, "a.txt", WithIfMatch(tag))
+	require.True(t, errors.Is(err, ErrPreconditionFailed), "%v", err)
+
+	current, err := c.Stat(ctx, "a.txt")
+	require.NoError(t, err)
+	require.Equal(t, "theirs", readFile(t, c, "a.txt"))
+	fi, err = resumable.Update(ctx, file, WithIfMatch(current.ETag()))
+	require.NoError(t, err)
+	require.Equal(t, "mine", readFile(t, c, "a.txt"))
+	require.NoError(t, c.Remove(ctx, "a.txt", WithIfMatch(fi.ETag())))
+}
+
+// readFile returns the current content of name
+func readFile(t *testing.T, c *Client, name string) string {
+	content, err := c.Open(context.Background(), name, 0)
+	require.NoError(t, err)
+	defer content.Close()
+	b, err := ioutil.ReadAll(content)
+	require.NoError(t, err)
+	return string(b)
+}
+
+func TestSearch(t *testing.T) {
+	srv, dir := newTestServer(t)
+	defer os.RemoveAll(dir)
+	defer srv.Close()
+	ctx := context.Background()
+	c := NewClient(srv.URL)
+	_, err := c.Put(ctx, "a.txt", strings.NewReader("disk full on node one"))
+	require.NoError(t, err)
+	_, err = c.Put(ctx, "b.txt", strings.NewReader("node two is fine"))
+	require.NoError(t, err)
+
+	results, err := c.Search(ctx, `"disk full" OR fine`, 10)
+	require.NoError(t, err)
+	require.Equal(t, 2, results.Total)
+	results, err = c.Search(ctx, `"disk full"`, 10)
+	require.NoError(t, err)
+	require.Len(t, results.Hits, 1)
+	require.Equal(t, "a.txt", results.Hits[0].Name)
+	require.Equal(t, [][2]int{{0, 4}, {5, 9}}, results.Hits[0].Highlights)
+
+	_, err = c.Search(ctx, "NOT", 10)
+	apiErr := &Error{}
+	require.True(t, errors.As(err, &apiErr), "%v", err)
+	require.Equal(t, http.StatusBadRequest, apiErr.StatusCode)
+}
+
+func TestGrep(t *testing.T) {
+	srv, dir := newTestServer(t)
+	defer os.RemoveAll(dir)
+	defer srv.Close()
+	ctx := context.Background()
+	c := NewClient(srv.URL)
+	_, err := c.Put(ctx, "a.log", strings.NewReader("boot\npanic: oops\nexit\n"))
+	require.NoError(t, err)
+	_, err = c.Put(ctx, "b.txt", strings.NewReader("panic\n"))
+	require.NoError(t, err)
+
+	var lines []GrepLine
+	collect := func(line GrepLine) error {
+		lines = append(lines, line)
+		return nil
+	}
+	require.NoError(t, c.Grep(ctx, "^panic", collect, WithGlob("*.log"), WithContextLines(0, 1)))
+	require.Equal(t, []GrepLine{
+		{File: "a.log", Line: 2, Text: "panic: oops", Match: true, Ranges: [][2]int{{0, 5}}},
+		{File: "a.log", Line: 3, Text: "exit"},
+	}, lines)
+
+	stop := errors.New("stop")
+	require.Equal(t, stop, c.Grep(ctx, "panic", func(GrepLine) error { return stop }))
+	err = c.Grep(ctx, "(", collect)
+	require.Error(t, err)
+}
+
+func TestFileWordStats(t *testing.T) {
+	srv, dir := newTestServer(t)
+	defer os.RemoveAll(dir)
+	defer srv.Close()
+	ctx := context.Background()
+	c := NewClient(srv.URL)
+	_, err := c.Put(ctx, "a.log", strings.NewReader("disk full disk"), WithLabels(map[string]string{"team": "ops"}))
+	require.NoError(t, err)
+	_, err = c.Put(ctx, "b.txt", strings.NewReader("cpu ok ok"))
+	require.NoError(t, err)
+
+	count, err := c.CountWords(ctx, WithFiles("b.txt"))
+	require.NoError(t, err)
+	require.Equal(t, 3, count)
+	words, err := c.FreqWords(ctx, 1, "dsc", WithLabelSelector("team=ops"))
+	require.NoError(t, err)
+	require.Equal(t, []WordFreq{{Word: "disk", Count: 2}}, words)
+	files, err := c.FileWordStats(ctx, 1, "dsc", WithFilesMatching("*"))
+	require.NoError(t, err)
+	require.Equal(t, []FileWordStats{
+		{Name: "a.log", Count: 3, Words: []WordFreq{{Word: "disk", Count: 2}}},
+		{Name: "b.txt", Count: 3, Words: []WordFreq{{Word: "ok", Count: 2}}},
+	}, files)
+	total, counts, err := c.Count(ctx, true, WithFilesMatching("*"))
+	require.NoError(t, err)
+	require.Equal(t, &Counts{Words: 6, Bytes: 23, Chars: 23, MaxLineLength: 14}, total)
+	require.Equal(t, []Counts{
+		{Name: "a.log", Words: 3, Bytes: 14, Chars: 14, MaxLineLength: 14},
+		{Name: "b.txt", Words: 3, Bytes: 9, Chars: 9, MaxLineLength: 9},
+	}, counts)
+	_, err = c.CountWords(ctx, WithFiles("missing.txt"))
+	require.True(t, errors.Is(err, ErrNotFound), "%v", err)
+}
+
+func TestFreqNgrams(t *testing.T) {
+	srv, dir := newTestServer(t)
+	defer os.RemoveAll(dir)
+	defer srv.Close()
+	ctx := context.Background()
+	c := NewClient(srv.URL)
+	_, err := c.Put(ctx, "a.txt", strings.NewReader("the build failed. The build failed again"))
+	require.NoError(t, err)
+	_, err = c.Put(ctx, "b.txt", strings.NewReader("build failed on the main branch"))
+	require.NoError(t, err)
+
+	ngrams, err := c.FreqNgrams(ctx, 2, 2, "dsc")
+	require.NoError(t, err)
+	require.Equal(t, []WordFreq{{Word: "build failed", Count: 3}, {Word: "the build", Count: 2}}, ngrams)
+	ngrams, err = c.FreqNgrams(ctx, 3, 1, "dsc", WithFiles("b.txt"), WithStopwords("en"))
+	require.NoError(t, err)
+	require.Empty(t, ngrams)
+	ngrams, err = c.FreqNgrams(ctx, 2, 1, "dsc", WithFiles("b.txt"), WithStopwords("en"))
+	require.NoError(t, err)
+	require.Equal(t, []WordFreq{{Word: "build failed", Count: 1}}, ngrams)
+	_, err = c.FreqNgrams(ctx, 6, 1, "dsc")
+	require.Error(t, err)
+}
+
+func TestStatsScanErrors(t *testing.T) {
+	dir, err := ioutil.TempDir("", "filestore")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "a.txt"), []byte("build failed"), 0644))
+	// a dangling link cannot be read by the server
+	require.NoError(t, os.Symlink(filepath.Join(dir, "missing"), filepath.Join(dir, "b.txt")))
+	srv := httptest.NewServer(newTestHandler(t, dir))
+	defer srv.Close()
+	ctx := context.Background()
+	c := NewClient(srv.URL)
+
+	// partial statistics come with the files that could not be scanned
+	count, err := c.CountWords(ctx)
+	require.Equal(t, 2, count)
+	var scanErr *ScanError
+	require.True(t, errors.As(err, &scanErr), "%v", err)
+	require.Len(t, scanErr.Files, 1)
+	require.Equal(t, "b.txt", scanErr.Files[0].Name)
+	words, err := c.FreqWords(ctx, 1, "dsc")
+	require.Len(t, words, 1)
+	require.True(t, errors.As(err, &scanErr), "%v", err)
+	files, err := c.FileWordStats(ctx, 1, "dsc", WithFiles("a.txt"))
+	require.NoError(t, err)
+	require.Len(t, files, 1)
+	ngrams, err := c.FreqNgrams(ctx, 2, 1, "dsc")
+	require.Equal(t, []WordFreq{{Word: "build failed", Count: 1}}, ngrams)
+	require.True(t, errors.As(err, &scanErr), "%v", err)
+}
+
+func TestStopwords(t *testing.T) {
+	srv, dir := newTestServer(t)
+	defer os.RemoveAll(dir)
+	defer srv.Close()
+	ctx := context.Background()
+	c := NewClient(srv.URL)
+	_, err := c.Put(ctx, "a.txt", strings.NewReader("the disk of the node is full"))
+	require.NoError(t, err)
+
+	words, err := c.PutStopwords(ctx, "infra", strings.NewReader("# infra words\nnode disk\n"))
+	require.NoError(t, err)
+	require.Equal(t, []string{"node", "disk"}, words)
+	lists, err := c.StopwordLists(ctx)
+	require.NoError(t, err)
+	require.Contains(t, lists, "infra")
+
+	freq, err := c.FreqWords(ctx, 10, "dsc", WithStopwords("en", "infra"))
+	require.NoError(t, err)
+	require.Equal(t, []WordFreq{{Word: "full", Count: 1}}, freq)
+
+	_, err = c.Put(ctx, "b.txt", strings.NewReader("disks filled, disk full"))
+	require.NoError(t, err)
+	freq, err = c.FreqWords(ctx, 1, "dsc", WithStemming("en"))
+	require.NoError(t, err)
+	require.Equal(t, []WordFreq{{Word: "disk", Count: 3, Form: "disk"}}, freq)
+
+	require.NoError(t, c.DeleteStopwords(ctx, "infra"))
+	_, err = c.Stopwords(ctx, "infra")
+	require.True(t, errors.Is(err, ErrNotFound), "%v", err)
+}
+
+func TestClientErrors(t *testing.T) {
+	srv, dir := newTestServer(t)
+	defer os.RemoveAll(dir)
+	defer srv.Close()
+	c := NewClient(srv.URL)
+
+	_, err := c.Open(context.Background(), "con.txt", 0)
+	apiErr := &Error{}
+	require.True(t, errors.As(err, &apiErr), "%v", err)
+	require.Equal(t, 400, apiErr.StatusCode)
+	require.Equal(t, filestore.ErrCodeNameReserved, apiErr.Code)
+	require.NotEmpty(t, apiErr.RequestID)
+
+	_, err = c.Stat(context.Background(), "../a.txt")
+	require.True(t, errors.As(err, &apiErr), "%v", err)
+	require.Equal(t, filestore.ErrCodeNameTraversal, apiErr.Code)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = c.List(ctx)
+	require.True(t, errors.Is(err, context.Canceled), "%v", err)
+}
+
+func TestAddStreamsWithProgress(t *testing.T) {
+	srv, dir := newTestServer(t)
+	defer os.RemoveAll(dir)
+	defer srv.Close()
+	c := NewClient(srv.URL)
+
+	local, err := ioutil.TempDir("", "client")
+	require.NoError(t, err)
+	defer os.RemoveAll(local)
+	var files []string
+	for i := 0; i < 2; i++ {
+		file := filepath.Join(local, fmt.Sprintf("%d.txt", i))
+		require.NoError(t, ioutil.WriteFile(file, []byte(strings.Repeat("word ", 100000)), 0644))
+		files = append(files, file)
+	}
+	sent := make(map[string]int64)
+	progress := func(name string, n, total int64) {
+		require.Equal(t, int64(500000), total)
+		require.True(t, n >= sent[name])
+		sent[name] = n
+	}
+	require.NoError(t, c.Add(context.Background(), files, WithProgress(progress)))
+	require.Equal(t, map[string]int64{"0.txt": 500000, "1.txt": 500000}, sent)
+	count, err := c.CountWords(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, 200000, count)
+
+	err = c.Add(context.Background(), []string{filepath.Join(local, "missing.txt")})
+	require.True(t, os.IsNotExist(err), "%v", err)
+}
+
+func TestIdleTimeout(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("stall") != "" {
+			time.Sleep(300 * time.Millisecond)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte("[")) // nolint: errcheck
+		for i := 0; i < 6; i++ {
+			w.Write([]byte(" ")) // nolint: errcheck
+			w.(http.Flusher).Flush()
+			time.Sleep(50 * time.Millisecond)
+		}
+		w.Write([]byte("]")) // nolint: errcheck
+	}))
+	defer srv.Close()
+	c := NewClient(srv.URL, WithIdleTimeout(150*time.Millisecond))
+
+	// a transfer longer than the idle timeout succeeds as long as it makes progress
+	var files []FileInfo
+	require.NoError(t, c.getJSON(context.Background(), c.url("v2/files", nil), &files))
+	err := c.getJSON(context.Background(), c.url("v2/files?stall=1", nil), &files)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "timeout")
+}
+
+// flakyHandler drops the connection of every other chunk request after reading part of its body
+type flakyHandler struct {
+	handler http.Handler
+	mu      sync.Mutex
+	chunks  int
+	dropped int
+}
+
+func (h *flakyHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method == "PUT" && strings.HasPrefix(r.URL.Path, "/v2/uploads/") {
+		h.mu.Lock()
+		h.chunks++
+		drop := h.chunks%2 == 0
+		if drop {
+			h.dropped++
+		}
+		h.mu.Unlock()
+		if drop {
+			io.CopyN(ioutil.Discard, r.Body, 100) // nolint: errcheck
+			conn, _, err := w.(http.Hijacker).Hijack()
+			if err == nil {
+				conn.Close()
+			}
+			return
+		}
+	}
+	h.handler.ServeHTTP(w, r)
+}
+
+func TestResumableUploadRecovers(t *testing.T) {
+	dir, err := ioutil.TempDir("", "filestore")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	flaky := &flakyHandler{handler: newTestHandler(t, dir)}
+	srv := httptest.NewServer(flaky)
+	defer srv.Close()
+	c := NewClient(srv.URL, WithResumableThreshold(1000, 1000))
+	c.RetryDelay = time.Millisecond
+
+	local, err := ioutil.TempDir("", "client")
+	require.NoError(t, err)
+	defer os.RemoveAll(local)
+	big := filepath.Join(local, "big.txt")
+	content := strings.Repeat("word ", 1000)
+	require.NoError(t, ioutil.WriteFile(big, []byte(content), 0644))
+	small := filepath.Join(local, "small.txt")
+	require.NoError(t, ioutil.WriteFile(small, []byte("foo"), 0644))
+
+	var last int64
+	progress := func(name string, sent, total int64) {
+		if name == "big.txt" {
+			last = sent
+		}
+	}
+	require.NoError(t, c.Add(context.Background(), []string{big, small}, WithProgress(progress)))
+	require.True(t, flaky.dropped > 0)
+	require.Equal(t, int64(len(content)), last)
+	files, err := c.List(context.Background())
+	require.NoError(t, err)
+	require.Len(t, files, 2)
+	rc, err := c.Open(context.Background(), "big.txt", 0)
+	require.NoError(t, err)
+	b, err := ioutil.ReadAll(rc)
+	rc.Close()
+	require.NoError(t, err)
+	require.Equal(t, content, string(b))
+
+	err = c.Add(context.Background(), []string{big})
+	require.True(t, errors.Is(err, ErrAlreadyExists), "%v", err)
+	fi, err := c.Update(context.Background(), big)
+	require.NoError(t, err)
+	require.Equal(t, int64(len(content)), fi.Size)
+}
+
+// cutWriter aborts the response once limit bytes of body were written
+type cutWriter struct {
+	http.ResponseWriter
+	limit int
+}
+
+func (w *cutWriter) Write(b []byte) (int, error) {
+	if len(b) > w.limit {
+		w.ResponseWriter.Write(b[:w.limit]) // nolint: errcheck
+		w.ResponseWriter.(http.Flusher).Flush()
+		panic(http.ErrAbortHandler)
+	}
+	w.limit -= len(b)
+	return w.ResponseWriter.Write(b)
+}
+
+func TestRangedReads(t *testing.T) {
+	srv, dir := newTestServer(t)
+	defer os.RemoveAll(dir)
+	defer srv.Close()
+	ctx := context.Background()
+	c := NewClient(srv.URL)
+	_, err := c.Put(ctx, "a.txt", strings.NewReader("0123456789"))
+	require.NoError(t, err)
+
+	f, err := c.OpenFile(ctx, "a.txt")
+	require.NoError(t, err)
+	require.Equal(t, int64(10), f.Size)
+	require.NotEmpty(t, f.ETag)
+	p := make([]byte, 4)
+	n, err := f.ReadAt(p, 3)
+	require.NoError(t, err)
+	require.Equal(t, "3456", string(p[:n]))
+	n, err = f.ReadAt(p, 8)
+	require.Equal(t, io.EOF, err)
+	require.Equal(t, "89", string(p[:n]))
+	b, err := ioutil.ReadAll(io.NewSectionReader(f, 0, f.Size))
+	require.NoError(t, err)
+	require.Equal(t, "0123456789", string(b))
+
+	_, err = c.Put(ctx, "a.txt", strings.NewReader("changed content"))
+	require.NoError(t, err)
+	_, err = f.ReadAt(p, 0)
+	require.Equal(t, ErrChanged, err)
+}
+
+func TestDownloadResumes(t *testing.T) {
+	dir, err := ioutil.TempDir("", "filestore")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	handler := newTestHandler(t, dir)
+	var ranges []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "GET" && strings.HasPrefix(r.URL.Path, "/v2/files/") {
+			ranges = append(ranges, r.Header.Get("Range"))
+			// every response is cut after 3000 bytes
+			w = &cutWriter{ResponseWriter: w, limit: 3000}
+		}
+		handler.ServeHTTP(w, r)
+	}))
+	defer srv.Close()
+	c := NewClient(srv.URL)
+	c.RetryDelay = time.Millisecond
+	content := strings.Repeat("0123456789", 1000)
+	_, err = c.Put(context.Background(), "a.txt", strings.NewReader(content))
+	require.NoError(t, err)
+
+	local, err := ioutil.TempDir("", "client")
+	require.NoError(t, err)
+	defer os.RemoveAll(local)
+	dest := filepath.Join(local, "a.txt")
+	require.NoError(t, c.Download(context.Background(), "a.txt", dest))
+	b, err := ioutil.ReadFile(dest)
+	require.NoError(t, err)
+	require.Equal(t, content, string(b))
+	require.Equal(t, []string{"", "bytes=3000-", "bytes=6000-", "bytes=9000-"}, ranges)
+}
+
+func TestDownloadFailureKeepsDest(t *testing.T) {
+	srv, dir := newTestServer(t)
+	defer os.RemoveAll(dir)
+	defer srv.Close()
+	c := NewClient(srv.URL)
+	local, err := ioutil.TempDir("", "client")
+	require.NoError(t, err)
+	defer os.RemoveAll(local)
+	dest := filepath.Join(local, "a.txt")
+	require.NoError(t, ioutil.WriteFile(dest, []byte("local content"), 0644))
+
+	err = c.Download(context.Background(), "missing.txt", dest)
+	require.True(t, errors.Is(err, ErrNotFound), "%v", err)
+	b, err := ioutil.ReadFile(dest)
+	require.NoError(t, err)
+	require.Equal(t, "local content", string(b))
+	files, err := ioutil.ReadDir(local)
+	require.NoError(t, err)
+	require.Len(t, files, 1)
+}
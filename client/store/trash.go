@@ -0,0 +1,69 @@
+package store
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// TrashEntry is a removed file waiting in the trash
+type TrashEntry struct {
+	Name    string
+	Size    int64
+	Deleted time.Time
+}
+
+// Trash returns the removed files waiting in the trash, oldest first
+func (c *Client) Trash(ctx context.Context) ([]TrashEntry, error) {
+	b, err := c.call(ctx, "GET", c.url("trash", nil))
+	if err != nil {
+		return nil, err
+	}
+	var entries []TrashEntry
+	scanner := bufio.NewScanner(bytes.NewReader(b))
+	for scanner.Scan() {
+		// lines are "<deleted> <size> <name>", names may contain spaces
+		line := scanner.Text()
+		fields := strings.Fields(line)
+		if len(fields) < 3 {
+			return nil, fmt.Errorf("invalid trash line %q", line)
+		}
+		var entry TrashEntry
+		if entry.Deleted, err = time.Parse(time.RFC3339, fields[0]); err != nil {
+			return nil, fmt.Errorf("invalid trash line %q: %v", line, err)
+		}
+		if entry.Size, err = strconv.ParseInt(fields[1], 10, 64); err != nil {
+			return nil, fmt.Errorf("invalid trash line %q: %v", line, err)
+		}
+		rest := strings.TrimLeft(line[len(fields[0]):], " ")
+		entry.Name = rest[len(fields[1])+1:]
+		entries = append(entries, entry)
+	}
+	return entries, scanner.Err()
+}
+
+// TrashRestore moves the most recently removed file of a name back to the store, it fails
+// with ErrAlreadyExists when a file of that name is in the store
+func (c *Client) TrashRestore(ctx context.Context, name string) error {
+	_, err := c.call(ctx, "POST", c.url("trash/restore", url.Values{"file": {name}}))
+	return err
+}
+
+// TrashPurge deletes for good the files in the trash, or only the entries of name when it
+// is not empty, and returns the number of purged entries
+func (c *Client) TrashPurge(ctx context.Context, name string) (int, error) {
+	query := url.Values{}
+	if name != "" {
+		query.Set("file", name)
+	}
+	b, err := c.call(ctx, "POST", c.url("trash/purge", query))
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(strings.TrimSpace(string(b)))
+}
@@ -0,0 +1,176 @@
+package store
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"time"
+)
+
+const (
+	// DefaultResumableThreshold is the size from which files are sent through resumable uploads
+	DefaultResumableThreshold = 32 << 20
+	// DefaultChunkSize is the size of the chunks of resumable uploads
+	DefaultChunkSize = 8 << 20
+	// DefaultMaxRetries is the number of times in a row a resumable upload is resumed after an error
+	DefaultMaxRetries = 5
+)
+
+// uploadOffsetHeader carries the number of bytes an upload session has received
+const uploadOffsetHeader = "Upload-Offset"
+
+// upload is a resumable upload session
+type upload struct {
+	ID     string `json:"id"`
+	Offset int64  `json:"offset"`
+}
+
+// WithResumableThreshold sends the files of size bytes or more through resumable uploads,
+// they are sent in chunks of chunkSize bytes and resumed after network errors
+func WithResumableThreshold(size, chunkSize int64) Option {
+	return func(c *Client) {
+		c.ResumableThreshold, c.ChunkSize = size, chunkSize
+	}
+}
+
+// resumable reports whether a file of size bytes is sent through a resumable upload
+func (c *Client) resumable(size int64) bool {
+	return c.ResumableThreshold > 0 && size >= c.ResumableThreshold
+}
+
+// retryable reports whether a failed request may succeed when sent again
+func retryable(err error) bool {
+	var e *Error
+	if errors.As(err, &e) {
+		return e.StatusCode >= 500
+	}
+	return !errors.Is(err, context.Canceled) && !errors.Is(err, context.DeadlineExceeded)
+}
+
+// retry counts a failure and waits before the next attempt, it returns err when the failure
+// cant be retried or when MaxRetries failures happened in a row
+func (c *Client) retry(ctx context.Context, err error, failures *int) error {
+	*failures++
+	if !retryable(err) || *failures > c.MaxRetries {
+		return err
+	}
+	return c.wait(ctx, *failures)
+}
+
+// wait sleeps before the retry following failures errors in a row, it returns early with
+// an error when ctx is done
+func (c *Client) wait(ctx context.Context, failures int) error {
+	delay := c.RetryDelay << uint(failures-1)
+	if delay > 30*time.Second || delay <= 0 {
+		delay = 30 * time.Second
+	}
+	t := time.NewTimer(delay)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
+
+// uploadFile sends a local file as name through a resumable upload session. Network and
+// server errors are retried up to MaxRetries times in a row, resuming from the offset the
+// server received.
+func (c *Client) uploadFile(ctx context.Context, file, name string, replace bool, o *writeOptions) (*FileInfo, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return nil, err
+	}
+	sum := hex.EncodeToString(h.Sum(nil))
+
+	query := o.query()
+	query.Set("name", name)
+	query.Set("size", strconv.FormatInt(fi.Size(), 10))
+	query.Set("replace", strconv.FormatBool(replace))
+	var u upload
+	if err := c.sendJSON(ctx, "POST", c.url("v2/uploads", query), nil, nil, &u); err != nil {
+		return nil, err
+	}
+	sessionURL := c.url("v2/uploads/"+u.ID, nil)
+
+	failures := 0
+	retry := func(err error) error {
+		return c.retry(ctx, err, &failures)
+	}
+	offset := u.Offset
+	for offset < fi.Size() {
+		n := c.ChunkSize
+		if n <= 0 || n > fi.Size()-offset {
+			n = fi.Size() - offset
+		}
+		chunk := o.trackFrom(name, io.NewSectionReader(f, offset, n), offset, fi.Size())
+		err := c.sendJSON(ctx, "PUT", c.url("v2/uploads/"+u.ID, url.Values{"offset": {strconv.FormatInt(offset, 10)}}), chunk, nil, &u)
+		if err == nil {
+			offset, failures = u.Offset, 0
+			continue
+		}
+		var e *Error
+		if !errors.As(err, &e) || e.Code != "offset_mismatch" {
+			if err := retry(err); err != nil {
+				return nil, err
+			}
+		}
+		// the server may have stored part or all of the chunk, resume where it stands
+		if err := c.sendJSON(ctx, "GET", sessionURL, nil, nil, &u); err != nil {
+			if err := retry(err); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		offset = u.Offset
+	}
+
+	completeURL := c.url("v2/uploads/"+u.ID+"/complete", url.Values{"sha256": {sum}})
+	for completed := false; ; {
+		info := &FileInfo{}
+		err := c.sendJSON(ctx, "POST", completeURL, nil, o.header(), info)
+		if err == nil {
+			return info, nil
+		}
+		if completed && errors.Is(err, ErrNotFound) {
+			// the session was completed but the response got lost
+			if info, err := c.Stat(ctx, name); err == nil && info.SHA256 == sum {
+				return info, nil
+			}
+		}
+		if err := retry(err); err != nil {
+			return nil, err
+		}
+		completed = true
+	}
+}
+
+// sendJSON sends a request and decodes its JSON response into v
+func (c *Client) sendJSON(ctx context.Context, method, u string, body io.Reader, header http.Header, v interface{}) error {
+	resp, err := c.do(ctx, method, u, body, header)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if err := decodeJSON(resp.Body, v); err != nil {
+		return fmt.Errorf("%s %s: %v", method, u, err)
+	}
+	return nil
+}
@@ -0,0 +1,161 @@
+package store
+
+import (
+	"context"
+	"net/url"
+	"strconv"
+)
+
+// WordFreq is a word and its number of occurrences in the store. When words are stemmed,
+// Word is a stem and Form its most common surface form.
+type WordFreq struct {
+	Word  string `json:"word"`
+	Count int    `json:"count"`
+	Form  string `json:"form,omitempty"`
+}
+
+// FileWordStats are the word statistics of a single file of the store
+type FileWordStats struct {
+	Name  string     `json:"name"`
+	Count int        `json:"count"`
+	Words []WordFreq `json:"words"`
+}
+
+// Counts are the wc style counts of the store, of some files or of a single file, Words
+// being counted by the analyzer
+type Counts struct {
+	Name  string `json:"name,omitempty"`
+	Lines int    `json:"lines"`
+	Words int    `json:"count"`
+	// Chars counts the valid UTF-8 characters
+	Chars int   `json:"chars"`
+	Bytes int64 `json:"bytes"`
+	// MaxLineLength is the display width of the longest line
+	MaxLineLength int `json:"max_line_length"`
+}
+
+// wordStats is the response of the word statistics endpoint
+type wordStats struct {
+	Counts
+	Top    []WordFreq  `json:"words"`
+	Files  []fileStats `json:"files"`
+	Errors []FileError `json:"errors"`
+}
+
+// fileStats are the statistics of a file in the response of the word statistics endpoint
+type fileStats struct {
+	Counts
+	Top []WordFreq `json:"words"`
+}
+
+// StatsOption configures how word statistics are computed
+type StatsOption func(url.Values)
+
+// WithAnalyzer selects the analyzer splitting the content of files into words, standard
+// or raw, the server default being used otherwise
+func WithAnalyzer(name string) StatsOption {
+	return func(query url.Values) {
+		query.Set("analyzer", name)
+	}
+}
+
+// WithoutNumbers leaves numbers out of the statistics
+func WithoutNumbers() StatsOption {
+	return func(query url.Values) {
+		query.Set("numbers", "drop")
+	}
+}
+
+// WithStemming reduces words to their stem with the stemmer of language: en, fr, de or es
+func WithStemming(language string) StatsOption {
+	return func(query url.Values) {
+		query.Set("stem", language)
+	}
+}
+
+// WithFiles restricts statistics to the given files of the store
+func WithFiles(names ...string) StatsOption {
+	return func(query url.Values) {
+		query["file"] = append(query["file"], names...)
+	}
+}
+
+// WithFilesMatching restricts statistics to the files of the store matching glob
+func WithFilesMatching(glob string) StatsOption {
+	return func(query url.Values) {
+		query.Set("glob", glob)
+	}
+}
+
+// WithLabelSelector restricts statistics to the files whose labels match selector, a comma
+// separated list of key=value, key!=value, key and !key terms
+func WithLabelSelector(selector string) StatsOption {
+	return func(query url.Values) {
+		query.Set("selector", selector)
+	}
+}
+
+// wordStats returns the word statistics of the store, along with a ScanError when the server
+// could not scan some files. The statistics are nil on other errors.
+func (c *Client) wordStats(ctx context.Context, query url.Values, opts []StatsOption) (*wordStats, error) {
+	for _, opt := range opts {
+		opt(query)
+	}
+	stats := &wordStats{}
+	if err := c.getJSON(ctx, c.url("v2/stats/words", query), stats); err != nil {
+		return nil, err
+	}
+	return stats, scanError(stats.Errors)
+}
+
+// FreqWords returns the limit most frequent words of the store when order is dsc, the
+// least frequent when it is asc. When the server could not scan some files, the words of
+// the other files are returned with a *ScanError.
+func (c *Client) FreqWords(ctx context.Context, limit int, order string, opts ...StatsOption) ([]WordFreq, error) {
+	stats, err := c.wordStats(ctx, url.Values{"limit": {strconv.Itoa(limit)}, "order": {order}}, opts)
+	if stats == nil {
+		return nil, err
+	}
+	return stats.Top, err
+}
+
+// CountWords returns the number of words in the store, along with a *ScanError when the
+// server could not scan some files
+func (c *Client) CountWords(ctx context.Context, opts ...StatsOption) (int, error) {
+	stats, err := c.wordStats(ctx, url.Values{"limit": {"0"}}, opts)
+	if stats == nil {
+		return 0, err
+	}
+	return stats.Words, err
+}
+
+// Count returns the wc style counts of the store, or of the files selected by the options,
+// along with the counts of every file when perFile is set. When the server could not scan
+// some files, the counts of the other files are returned with a *ScanError.
+func (c *Client) Count(ctx context.Context, perFile bool, opts ...StatsOption) (*Counts, []Counts, error) {
+	stats, err := c.wordStats(ctx, url.Values{"limit": {"0"}, "per_file": {strconv.FormatBool(perFile)}}, opts)
+	if stats == nil {
+		return nil, nil, err
+	}
+	var files []Counts
+	for _, file := range stats.Files {
+		files = append(files, file.Counts)
+	}
+	return &stats.Counts, files, err
+}
+
+// FileWordStats returns the number of words of every file of the store, or of the files
+// selected by the options, with their limit most frequent words when order is dsc, the
+// least frequent when it is asc. Files the server could not scan are reported by a
+// *ScanError returned with the statistics of the other files.
+func (c *Client) FileWordStats(ctx context.Context, limit int, order string, opts ...StatsOption) ([]FileWordStats, error) {
+	stats, err := c.wordStats(ctx, url.Values{"limit": {strconv.Itoa(limit)}, "order": {order}, "per_file": {"true"}}, opts)
+	if stats == nil {
+		return nil, err
+	}
+	files := make([]FileWordStats, 0, len(stats.Files))
+	for _, file := range stats.Files {
+		files = append(files, FileWordStats{Name: file.Name, Count: file.Words, Words: file.Top})
+	}
+	return files, err
+}
@@ -0,0 +1,279 @@
+package filestore
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"unicode"
+)
+
+const (
+	// AnalyzerStandard splits words on Unicode word boundaries, drops punctuation and folds case
+	AnalyzerStandard = "standard"
+	// AnalyzerRaw keeps words as they are separated by spaces in the files
+	AnalyzerRaw = "raw"
+)
+
+// analyzer turns the words of the files, as separated by spaces, into the words counted by
+// the statistics
+type analyzer struct {
+	name string
+	// segment splits words on Unicode word boundaries and drops punctuation
+	segment bool
+	// fold folds the case of words
+	fold bool
+	// dropNumbers drops words made of digits and number separators only
+	dropNumbers bool
+	// language is the language of the stemmer, stem reduces folded words to their stem
+	language string
+	stem     func(string) string
+	// stopwords are left out of the statistics, they are surface forms removed once the
+	// words are analyzed and before they are stemmed
+	stopwords map[string]bool
+}
+
+// newAnalyzer returns the analyzer called name
+func newAnalyzer(name string) (*analyzer, error) {
+	switch name {
+	case AnalyzerStandard:
+		return &analyzer{name: name, segment: true, fold: true}, nil
+	case AnalyzerRaw:
+		return &analyzer{name: name}, nil
+	}
+	return nil, fmt.Errorf("unknown analyzer %q, expecting %s or %s", name, AnalyzerStandard, AnalyzerRaw)
+}
+
+// analyzerParam returns the analyzer of a statistics request: the analyzer parameter
+// names it, the store default being used when it is missing, numbers=drop leaves
+// numbers out, stem names the language of the stemmer and stopwords names the stopword
+// lists to leave out
+func (fs *FileStore) analyzerParam(r *http.Request) (*analyzer, error) {
+	name := r.FormValue("analyzer")
+	if name == "" {
+		name = fs.Analyzer
+	}
+	a, err := newAnalyzer(name)
+	if err != nil {
+		return nil, err
+	}
+	switch numbers := r.FormValue("numbers"); numbers {
+	case "", "keep":
+	case "drop":
+		a.dropNumbers = true
+	default:
+		return nil, fmt.Errorf("invalid numbers %q, expecting keep or drop", numbers)
+	}
+	if language := r.FormValue("stem"); language != "" {
+		if a.stem, err = stemmer(language); err != nil {
+			return nil, err
+		}
+		a.language = language
+	}
+	if a.stopwords, err = fs.stopwordsParam(r, a); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// key identifies the words produced by the analyzer before stopwords are removed
+func (a *analyzer) key() string {
+	return fmt.Sprintf("%s/%t/%s", a.name, a.dropNumbers, a.language)
+}
+
+// raw reports whether the analyzer leaves words untouched
+func (a *analyzer) raw() bool {
+	return !a.segment && !a.fold && !a.dropNumbers && a.stem == nil
+}
+
+// stemmed returns the stem of a surface form when a stems, the form otherwise
+func (a *analyzer) stemmed(form string) string {
+	if a.stem == nil {
+		return form
+	}
+	return a.stem(form)
+}
+
+// surfaceForms returns the words making up word before they are stemmed
+func (a *analyzer) surfaceForms(word string) []string {
+	tokens := []string{word}
+	if a.segment {
+		tokens = segmentWords(word)
+	}
+	kept := tokens[:0]
+	for _, token := range tokens {
+		if a.dropNumbers && isNumber(token) {
+			continue
+		}
+		if a.fold {
+			token = foldCase(token)
+		}
+		kept = append(kept, token)
+	}
+	return kept
+}
+
+// analyzeWords returns the occurrences of the words produced by a from words, the
+// occurrences of the words of the files, and their total. The words are not stemmed yet.
+func analyzeWords(words map[string]int, a *analyzer) (map[string]int, int) {
+	analyzed := make(map[string]int, len(words))
+	count := 0
+	for word, n := range words {
+		for _, form := range a.surfaceForms(word) {
+			analyzed[form] += n
+			count += n
+		}
+	}
+	return analyzed, count
+}
+
+// stemWords returns the occurrences of the stems of surface, the occurrences of the words
+// produced by a before stemming, along with the most common surface form of every stem.
+// Ties go to the first form in lexical order.
+func stemWords(surface map[string]int, a *analyzer) (map[string]int, map[string]string) {
+	stems := make(map[string]int, len(surface))
+	forms := make(map[string]string, len(surface))
+	for form, n := range surface {
+		stem := a.stem(form)
+		stems[stem] += n
+		best, ok := forms[stem]
+		if !ok || n > surface[best] || (n == surface[best] && form < best) {
+			forms[stem] = form
+		}
+	}
+	return stems, forms
+}
+
+// foldCase maps every letter of s to a single case so words differing in case only are equal
+func foldCase(s string) string {
+	return strings.Map(func(r rune) rune {
+		return unicode.ToLower(unicode.ToUpper(r))
+	}, s)
+}
+
+// isNumber reports whether s is made of digits and number separators only
+func isNumber(s string) bool {
+	for _, r := range s {
+		switch wordClass(r) {
+		case classNumeric, classMidNum, classMidNumLet:
+		default:
+			return false
+		}
+	}
+	return s != ""
+}
+
+// wordBreakClass is the class of a character for the Unicode word boundary rules
+type wordBreakClass int
+
+const (
+	classOther wordBreakClass = iota
+	classALetter
+	classNumeric
+	classKatakana
+	classIdeographic
+	classMidLetter
+	classMidNum
+	classMidNumLet
+	classExtendNumLet
+	classExtend
+)
+
+// wordClass returns the word boundary class of r, following UAX #29 closely enough
+// for word counting
+func wordClass(r rune) wordBreakClass {
+	switch {
+	case strings.ContainsRune("'.\u2018\u2019\u2024\uFE52\uFF07\uFF0E", r):
+		return classMidNumLet
+	case strings.ContainsRune(":\u00B7\u0387\u05F4\u2027\uFE13\uFE55\uFF1A", r):
+		return classMidLetter
+	case strings.ContainsRune(",;\u037E\u0589\u060C\u060D\u066C\u07F8\u2044\uFE10\uFE14\uFE50\uFE54\uFF0C\uFF1B", r):
+		return classMidNum
+	case unicode.Is(unicode.Pc, r):
+		return classExtendNumLet
+	case unicode.In(r, unicode.Mn, unicode.Me, unicode.Mc, unicode.Cf):
+		return classExtend
+	case unicode.Is(unicode.Katakana, r):
+		return classKatakana
+	case unicode.In(r, unicode.Han, unicode.Hiragana) || unicode.Is(unicode.Ideographic, r):
+		return classIdeographic
+	case unicode.IsLetter(r):
+		return classALetter
+	case unicode.IsDigit(r):
+		return classNumeric
+	}
+	return classOther
+}
+
+// segmentWords splits s on the Unicode word boundaries of UAX #29 and returns the segments
+// holding letters or digits, dropping punctuation
+func segmentWords(s string) []string {
+	runes := []rune(s)
+	classes := make([]wordBreakClass, len(runes))
+	for i, r := range runes {
+		classes[i] = wordClass(r)
+	}
+	// next returns the class of the first character after i, skipping extending characters
+	next := func(i int) wordBreakClass {
+		for i++; i < len(runes) && classes[i] == classExtend; i++ {
+		}
+		if i < len(runes) {
+			return classes[i]
+		}
+		return classOther
+	}
+
+	var words []string
+	start := 0
+	prev, prevPrev := classOther, classOther
+	wordLike := false
+	for i, class := range classes {
+		if class == classExtend && i > 0 {
+			// extending characters belong to the character they follow
+			continue
+		}
+		if i > start && breaksBetween(prevPrev, prev, class, next(i)) {
+			if wordLike {
+				words = append(words, string(runes[start:i]))
+			}
+			start, wordLike = i, false
+		}
+		switch class {
+		case classALetter, classNumeric, classKatakana, classIdeographic:
+			wordLike = true
+		}
+		prevPrev, prev = prev, class
+	}
+	if wordLike {
+		words = append(words, string(runes[start:]))
+	}
+	return words
+}
+
+// breaksBetween reports whether words break between a character of class before and one of
+// class after, given the class of the character preceding before and of the one following after
+func breaksBetween(beforePrev, before, after, afterNext wordBreakClass) bool {
+	letter := func(c wordBreakClass) bool { return c == classALetter }
+	midLetter := func(c wordBreakClass) bool { return c == classMidLetter || c == classMidNumLet }
+	midNum := func(c wordBreakClass) bool { return c == classMidNum || c == classMidNumLet }
+	switch {
+	case letter(before) && letter(after): // WB5
+		return false
+	case letter(before) && midLetter(after) && letter(afterNext): // WB6
+		return false
+	case letter(beforePrev) && midLetter(before) && letter(after): // WB7
+		return false
+	case (before == classNumeric || letter(before)) && (after == classNumeric || letter(after)): // WB8, WB9, WB10
+		return false
+	case beforePrev == classNumeric && midNum(before) && after == classNumeric: // WB11
+		return false
+	case before == classNumeric && midNum(after) && afterNext == classNumeric: // WB12
+		return false
+	case before == classKatakana && after == classKatakana: // WB13
+		return false
+	case after == classExtendNumLet && (letter(before) || before == classNumeric || before == classKatakana || before == classExtendNumLet): // WB13a
+		return false
+	case before == classExtendNumLet && (letter(after) || after == classNumeric || after == classKatakana): // WB13b
+		return false
+	}
+	return true
+}
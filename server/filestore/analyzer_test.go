@@ -0,0 +1,79 @@
+package filestore
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSegmentWords(t *testing.T) {
+	tests := map[string][]string{
+		"the,":            {"the"},
+		`"quoted"`:        {"quoted"},
+		"'single'":        {"single"},
+		"don't":           {"don't"},
+		"e-mail":          {"e", "mail"},
+		"U.S.A.":          {"U.S.A"},
+		"3.14":            {"3.14"},
+		"1,000,000.":      {"1,000,000"},
+		"v2":              {"v2"},
+		"snake_case":      {"snake_case"},
+		"foo...bar":       {"foo", "bar"},
+		"(see:":           {"see"},
+		"---":             nil,
+		"日本語":             {"日", "本", "語"},
+		"カタカナ":            {"カタカナ"},
+		"naïve":           {"naïve"},
+		"café!":          {"café"},
+		"l’été":           {"l’été"},
+		"path/to/file.go": {"path", "to", "file.go"},
+	}
+	for word, want := range tests {
+		require.Equal(t, want, segmentWords(word), word)
+	}
+}
+
+func TestAnalyzer(t *testing.T) {
+	a, err := newAnalyzer(AnalyzerStandard)
+	require.NoError(t, err)
+	words, count := analyzeWords(map[string]int{"The": 2, "the,": 1, "THE": 1, "ΣΊΣΥΦΟΣ": 1, "σίσυφος": 1, "42": 3}, a)
+	require.Equal(t, map[string]int{"the": 4, "σίσυφοσ": 2, "42": 3}, words)
+	require.Equal(t, 9, count)
+
+	a.dropNumbers = true
+	words, count = analyzeWords(map[string]int{"42": 1, "4.2": 1, "x42": 1}, a)
+	require.Equal(t, map[string]int{"x42": 1}, words)
+	require.Equal(t, 1, count)
+
+	_, err = newAnalyzer("unknown")
+	require.Error(t, err)
+}
+
+func TestStatsAnalyzer(t *testing.T) {
+	fs := newTestStore(t)
+	defer os.RemoveAll(fs.StoreDir)
+	w := serve(fs.Add, multipartRequest(t, "POST", "/add", map[string]string{"a.txt": "The cat, the dog and THE bird 42"}))
+	require.Equal(t, http.StatusOK, w.Code)
+
+	w = serve(fs.FreqWords, httptest.NewRequest("GET", "/freqwords?limit=1&order=dsc", nil))
+	require.Equal(t, "  3 the\n", w.Body.String())
+	w = serve(fs.FreqWords, httptest.NewRequest("GET", "/freqwords?limit=2&order=dsc&analyzer=raw", nil))
+	require.Equal(t, "  1 42\n  1 THE\n", w.Body.String())
+	w = serve(fs.CountWords, httptest.NewRequest("GET", "/countwords?numbers=drop", nil))
+	require.Equal(t, "  7\n", w.Body.String())
+	w = serve(fs.CountWords, httptest.NewRequest("GET", "/countwords?analyzer=stem", nil))
+	require.Equal(t, http.StatusBadRequest, w.Code)
+
+	// the analyzed words follow the writes
+	w = serve(fs.Update, multipartRequest(t, "POST", "/update", map[string]string{"a.txt": "Dog dog"}))
+	require.Equal(t, http.StatusOK, w.Code)
+	w = serve(fs.FreqWords, httptest.NewRequest("GET", "/freqwords?limit=1&order=dsc", nil))
+	require.Equal(t, "  2 dog\n", w.Body.String())
+
+	fs.Analyzer = AnalyzerRaw
+	w = serve(fs.FreqWords, httptest.NewRequest("GET", "/freqwords?limit=2&order=dsc", nil))
+	require.Equal(t, "  1 Dog\n  1 dog\n", w.Body.String())
+}
@@ -0,0 +1,244 @@
+package filestore
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+)
+
+const (
+	// BackendLocal is the name of the backend storing files in a local directory
+	BackendLocal = "local"
+	// BackendMemory is the name of the backend keeping files in memory
+	BackendMemory = "memory"
+
+	// tmpDir is the directory inside metaDir receiving writes before they are renamed into place
+	tmpDir = "tmp"
+	// tmpMaxAge is the age after which a temporary file is considered left by a crash
+	tmpMaxAge = 24 * time.Hour
+)
+
+// Backend stores the content of the filestore. Names are slash separated paths
+// relative to the store root, filestore internal data lives under metaDir.
+type Backend interface {
+	// Put writes the content of r to name. Any previous content is replaced only once r
+	// is fully read, a failed write leaves it untouched.
+	Put(name string, r io.Reader) error
+	// Get opens name for reading
+	Get(name string) (Object, error)
+	// Stat describes name
+	Stat(name string) (os.FileInfo, error)
+	// List returns the files directly under dir, "" being the store root
+	List(dir string) ([]os.FileInfo, error)
+	// Delete removes name
+	Delete(name string) error
+	// Rename moves oldName to newName, replacing newName if it exists
+	Rename(oldName, newName string) error
+}
+
+// Object is the content of a stored file
+type Object interface {
+	io.ReadSeeker
+	io.Closer
+}
+
+// newBackend returns the backend selected by the config
+func newBackend(c *Config) (Backend, error) {
+	var backend Backend
+	var err error
+	switch c.Backend {
+	case BackendLocal, "":
+		backend, err = NewLocalBackend(c.StoreDir)
+	case BackendMemory:
+		backend = NewMemoryBackend()
+	default:
+		err = fmt.Errorf("unknown backend %q", c.Backend)
+	}
+	if err != nil || !c.Dedup {
+		return backend, err
+	}
+	return NewDedupBackend(backend)
+}
+
+// LocalBackend stores files in a directory of the local disk
+type LocalBackend struct {
+	Dir string
+}
+
+// NewLocalBackend returns a backend storing files in dir, creating it if it doesnt exist
+func NewLocalBackend(dir string) (*LocalBackend, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	b := &LocalBackend{Dir: dir}
+	if err := b.cleanTmp(tmpMaxAge); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// path returns the local path of name
+func (b *LocalBackend) path(name string) string {
+	return filepath.Join(b.Dir, filepath.FromSlash(name))
+}
+
+// Put writes the content of r to name. The content goes to a temporary file of the store
+// which is synced then renamed over name, so readers never see a partial content and a
+// failed write leaves the previous content untouched.
+func (b *LocalBackend) Put(name string, r io.Reader) error {
+	dir := filepath.Dir(b.path(name))
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(b.path(metaPath(tmpDir)), 0755); err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(b.path(metaPath(tmpDir)), "put-")
+	if err != nil {
+		return err
+	}
+	if err := writeSync(tmp, r); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Rename(tmp.Name(), b.path(name)); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return syncDir(dir)
+}
+
+// writeSync copies r to file, flushes it to disk and closes it
+func writeSync(file *os.File, r io.Reader) error {
+	_, err := io.Copy(file, r)
+	if err == nil {
+		err = file.Sync()
+	}
+	if cerr := file.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// syncDir flushes dir entries to disk so a rename survives a crash
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
+// cleanTmp removes the temporary files left by writes interrupted by a crash. Other
+// servers may share the store, only files older than maxAge are removed.
+func (b *LocalBackend) cleanTmp(maxAge time.Duration) error {
+	infos, err := ioutil.ReadDir(b.path(metaPath(tmpDir)))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	for _, fi := range infos {
+		if time.Since(fi.ModTime()) > maxAge {
+			os.Remove(filepath.Join(b.path(metaPath(tmpDir)), fi.Name()))
+		}
+	}
+	return nil
+}
+
+// Get opens name for reading
+func (b *LocalBackend) Get(name string) (Object, error) {
+	file, err := os.Open(b.path(name))
+	if err != nil {
+		return nil, err
+	}
+	fi, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	if fi.IsDir() {
+		file.Close()
+		return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrNotExist}
+	}
+	return file, nil
+}
+
+// Stat describes name
+func (b *LocalBackend) Stat(name string) (os.FileInfo, error) {
+	fi, err := os.Stat(b.path(name))
+	if err != nil {
+		return nil, err
+	}
+	if fi.IsDir() {
+		return nil, &os.PathError{Op: "stat", Path: name, Err: os.ErrNotExist}
+	}
+	return fi, nil
+}
+
+// List returns the files directly under dir
+func (b *LocalBackend) List(dir string) ([]os.FileInfo, error) {
+	infos, err := ioutil.ReadDir(b.path(dir))
+	if os.IsNotExist(err) && dir != "" {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	files := infos[:0]
+	for _, fi := range infos {
+		if fi.IsDir() {
+			continue
+		}
+		files = append(files, fi)
+	}
+	return files, nil
+}
+
+// Delete removes name
+func (b *LocalBackend) Delete(name string) error {
+	if _, err := b.Stat(name); err != nil {
+		return err
+	}
+	return os.Remove(b.path(name))
+}
+
+// Rename moves oldName to newName
+func (b *LocalBackend) Rename(oldName, newName string) error {
+	if _, err := b.Stat(oldName); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(b.path(newName)), 0755); err != nil {
+		return err
+	}
+	return os.Rename(b.path(oldName), b.path(newName))
+}
+
+// fileInfo describes a stored file for backends not backed by a filesystem
+type fileInfo struct {
+	name    string
+	size    int64
+	modTime time.Time
+}
+
+func (fi fileInfo) Name() string       { return fi.name }
+func (fi fileInfo) Size() int64        { return fi.size }
+func (fi fileInfo) Mode() os.FileMode  { return 0644 }
+func (fi fileInfo) ModTime() time.Time { return fi.modTime }
+func (fi fileInfo) IsDir() bool        { return false }
+func (fi fileInfo) Sys() interface{}   { return nil }
+
+// metaPath returns the backend name of filestore internal data
+func metaPath(elem ...string) string {
+	return path.Join(append([]string{metaDir}, elem...)...)
+}
@@ -0,0 +1,420 @@
+package filestore
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DedupBackend stores every distinct content once in a wrapped backend. Contents are
+// blobs named after their SHA-256 and file names are references to blobs, a blob is
+// deleted once no name references it anymore. Filestore internal data under metaDir is
+// stored in the wrapped backend as is.
+type DedupBackend struct {
+	inner Backend
+
+	mu   sync.Mutex
+	refs map[string]blobRef
+	// counts holds the number of names referencing each blob
+	counts map[string]int
+}
+
+// blobRef is the blob a file name references
+type blobRef struct {
+	Hash    string    `json:"hash"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mod_time"`
+}
+
+// dedupDir is the directory inside metaDir holding the blobs and the reference table
+const dedupDir = "dedup"
+
+var (
+	blobsDir    = metaPath(dedupDir, "blobs")
+	blobsTmpDir = metaPath(dedupDir, "tmp")
+	refsFile    = metaPath(dedupDir, "refs.json")
+)
+
+// NewDedupBackend returns a deduplicating backend storing blobs and references in inner.
+// Files inner holds already are migrated to blobs.
+func NewDedupBackend(inner Backend) (*DedupBackend, error) {
+	b := &DedupBackend{
+		inner:  inner,
+		refs:   make(map[string]blobRef),
+		counts: make(map[string]int),
+	}
+	if err := b.loadRefs(); err != nil {
+		return nil, err
+	}
+	if err := b.migrate(); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// loadRefs reads the persisted reference table, there is none on a new store
+func (b *DedupBackend) loadRefs() error {
+	obj, err := b.inner.Get(refsFile)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer obj.Close()
+	if err := json.NewDecoder(obj).Decode(&b.refs); err != nil {
+		return err
+	}
+	for _, ref := range b.refs {
+		b.counts[ref.Hash]++
+	}
+	return nil
+}
+
+// internal reports whether name is filestore internal data, kept in the inner backend as is
+func internal(name string) bool {
+	name = path.Clean(name)
+	return name == metaDir || strings.HasPrefix(name, metaDir+"/")
+}
+
+// migrate brings the content of inner written without deduplication under it: the files
+// of the store become blobs and internal data referencing blobs is copied back to inner.
+// Every content is copied and the reference table saved before the original is deleted,
+// so a migration interrupted by a crash resumes on the next start.
+func (b *DedupBackend) migrate() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	files, err := b.inner.List("")
+	if err != nil {
+		return err
+	}
+	changed := false
+	for _, fi := range files {
+		obj, err := b.inner.Get(fi.Name())
+		if err != nil {
+			return err
+		}
+		tmp, hash, err := b.writeTmp(obj)
+		obj.Close()
+		if err != nil {
+			return err
+		}
+		if _, err := b.storeBlob(tmp, hash); err != nil {
+			return err
+		}
+		if old, ok := b.refs[fi.Name()]; ok {
+			b.counts[old.Hash]--
+		}
+		// the modification time is kept so the word index stays valid
+		b.refs[fi.Name()] = blobRef{Hash: hash, Size: fi.Size(), ModTime: fi.ModTime()}
+		b.counts[hash]++
+		changed = true
+	}
+	for name, ref := range b.refs {
+		if !internal(name) {
+			continue
+		}
+		obj, err := b.inner.Get(blobPath(ref.Hash))
+		if err != nil {
+			return err
+		}
+		err = b.inner.Put(name, obj)
+		obj.Close()
+		if err != nil {
+			return err
+		}
+		delete(b.refs, name)
+		b.counts[ref.Hash]--
+		changed = true
+	}
+	if !changed {
+		return nil
+	}
+	// blobs left unreferenced are deleted by the next GC
+	for hash, count := range b.counts {
+		if count <= 0 {
+			delete(b.counts, hash)
+		}
+	}
+	if err := b.saveRefs(); err != nil {
+		return err
+	}
+	for _, fi := range files {
+		if err := b.inner.Delete(fi.Name()); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// blobPath returns the inner name of a blob
+func blobPath(hash string) string {
+	return path.Join(blobsDir, hash)
+}
+
+// saveRefs persists the reference table, the caller holds the lock
+func (b *DedupBackend) saveRefs() error {
+	data, err := json.Marshal(b.refs)
+	if err != nil {
+		return err
+	}
+	return b.inner.Put(refsFile, bytes.NewReader(data))
+}
+
+// release drops a reference to a blob and deletes the blob when it was the last one,
+// the caller holds the lock and has saved the reference table
+func (b *DedupBackend) release(hash string) error {
+	b.counts[hash]--
+	if b.counts[hash] > 0 {
+		return nil
+	}
+	delete(b.counts, hash)
+	if err := b.inner.Delete(blobPath(hash)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// writeTmp writes the content of r as a temporary blob, it returns its name and its hash
+func (b *DedupBackend) writeTmp(r io.Reader) (string, string, error) {
+	suffix := make([]byte, 8)
+	if _, err := rand.Read(suffix); err != nil {
+		return "", "", err
+	}
+	tmp := path.Join(blobsTmpDir, hex.EncodeToString(suffix))
+	h := sha256.New()
+	if err := b.inner.Put(tmp, io.TeeReader(r, h)); err != nil {
+		return "", "", err
+	}
+	return tmp, hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// storeBlob makes the temporary blob tmp the blob of hash unless an identical blob exists,
+// the caller holds the lock
+func (b *DedupBackend) storeBlob(tmp, hash string) (os.FileInfo, error) {
+	fi, err := b.inner.Stat(blobPath(hash))
+	switch {
+	case err == nil:
+		if err := b.inner.Delete(tmp); err != nil {
+			return nil, err
+		}
+	case os.IsNotExist(err):
+		if err := b.inner.Rename(tmp, blobPath(hash)); err != nil {
+			return nil, err
+		}
+		if fi, err = b.inner.Stat(blobPath(hash)); err != nil {
+			return nil, err
+		}
+	default:
+		b.inner.Delete(tmp) // nolint: errcheck
+		return nil, err
+	}
+	return fi, nil
+}
+
+// Put stores the content of r as a blob unless an identical blob exists and makes name reference it
+func (b *DedupBackend) Put(name string, r io.Reader) error {
+	if internal(name) {
+		return b.inner.Put(name, r)
+	}
+	name = path.Clean(name)
+	tmp, hash, err := b.writeTmp(r)
+	if err != nil {
+		return err
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	fi, err := b.storeBlob(tmp, hash)
+	if err != nil {
+		return err
+	}
+
+	old, replaced := b.refs[name]
+	b.refs[name] = blobRef{Hash: hash, Size: fi.Size(), ModTime: time.Now()}
+	b.counts[hash]++
+	if err := b.saveRefs(); err != nil {
+		b.counts[hash]--
+		if replaced {
+			b.refs[name] = old
+		} else {
+			delete(b.refs, name)
+		}
+		return err
+	}
+	if replaced {
+		return b.release(old.Hash)
+	}
+	return nil
+}
+
+// Get opens the blob name references
+func (b *DedupBackend) Get(name string) (Object, error) {
+	if internal(name) {
+		return b.inner.Get(name)
+	}
+	b.mu.Lock()
+	ref, ok := b.refs[path.Clean(name)]
+	b.mu.Unlock()
+	if !ok {
+		return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrNotExist}
+	}
+	return b.inner.Get(blobPath(ref.Hash))
+}
+
+// Stat describes name
+func (b *DedupBackend) Stat(name string) (os.FileInfo, error) {
+	if internal(name) {
+		return b.inner.Stat(name)
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	ref, ok := b.refs[path.Clean(name)]
+	if !ok {
+		return nil, &os.PathError{Op: "stat", Path: name, Err: os.ErrNotExist}
+	}
+	return fileInfo{name: path.Base(name), size: ref.Size, modTime: ref.ModTime}, nil
+}
+
+// List returns the files directly under dir
+func (b *DedupBackend) List(dir string) ([]os.FileInfo, error) {
+	if internal(dir) {
+		return b.inner.List(dir)
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	prefix := ""
+	if dir != "" && dir != "." {
+		prefix = path.Clean(dir) + "/"
+	}
+	var files []os.FileInfo
+	for name, ref := range b.refs {
+		if !strings.HasPrefix(name, prefix) || strings.Contains(name[len(prefix):], "/") {
+			continue
+		}
+		files = append(files, fileInfo{name: name[len(prefix):], size: ref.Size, modTime: ref.ModTime})
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].Name() < files[j].Name()
+	})
+	return files, nil
+}
+
+// Delete removes name and the blob it references when no other name does
+func (b *DedupBackend) Delete(name string) error {
+	if internal(name) {
+		return b.inner.Delete(name)
+	}
+	name = path.Clean(name)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	ref, ok := b.refs[name]
+	if !ok {
+		return &os.PathError{Op: "remove", Path: name, Err: os.ErrNotExist}
+	}
+	delete(b.refs, name)
+	if err := b.saveRefs(); err != nil {
+		b.refs[name] = ref
+		return err
+	}
+	return b.release(ref.Hash)
+}
+
+// Rename moves the reference of oldName to newName. Contents moved to or from internal
+// data, such as removed files going to the trash, are copied.
+func (b *DedupBackend) Rename(oldName, newName string) error {
+	switch {
+	case internal(oldName) && internal(newName):
+		return b.inner.Rename(oldName, newName)
+	case internal(oldName):
+		return b.copyRename(b.inner, b, oldName, newName)
+	case internal(newName):
+		return b.copyRename(b, b.inner, oldName, newName)
+	}
+	oldName, newName = path.Clean(oldName), path.Clean(newName)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	ref, ok := b.refs[oldName]
+	if !ok {
+		return &os.PathError{Op: "rename", Path: oldName, Err: os.ErrNotExist}
+	}
+	if oldName == newName {
+		return nil
+	}
+	replaced, exists := b.refs[newName]
+	b.refs[newName] = ref
+	delete(b.refs, oldName)
+	if err := b.saveRefs(); err != nil {
+		b.refs[oldName] = ref
+		if exists {
+			b.refs[newName] = replaced
+		} else {
+			delete(b.refs, newName)
+		}
+		return err
+	}
+	if exists {
+		return b.release(replaced.Hash)
+	}
+	return nil
+}
+
+// GC deletes the blobs no name references. They are left behind when the server stops
+// between writing a blob and recording its reference, or between dropping the last
+// reference and deleting the blob. Temporary blobs of writes still in progress are
+// kept until they are older than tmpMaxAge.
+func (b *DedupBackend) GC() (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	collected := 0
+	blobs, err := b.inner.List(blobsDir)
+	if err != nil {
+		return collected, err
+	}
+	for _, fi := range blobs {
+		if b.counts[fi.Name()] > 0 {
+			continue
+		}
+		if err := b.inner.Delete(blobPath(fi.Name())); err != nil && !os.IsNotExist(err) {
+			return collected, err
+		}
+		collected++
+	}
+	tmps, err := b.inner.List(blobsTmpDir)
+	if err != nil {
+		return collected, err
+	}
+	for _, fi := range tmps {
+		if time.Since(fi.ModTime()) < tmpMaxAge {
+			continue
+		}
+		if err := b.inner.Delete(path.Join(blobsTmpDir, fi.Name())); err != nil && !os.IsNotExist(err) {
+			return collected, err
+		}
+		collected++
+	}
+	return collected, nil
+}
+
+// copyRename moves oldName of from to newName of to by copying its content
+func (b *DedupBackend) copyRename(from, to Backend, oldName, newName string) error {
+	obj, err := from.Get(oldName)
+	if err != nil {
+		return err
+	}
+	err = to.Put(newName, obj)
+	obj.Close()
+	if err != nil {
+		return err
+	}
+	return from.Delete(oldName)
+}
@@ -0,0 +1,135 @@
+package filestore
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDedupBackend(t *testing.T) {
+	b, err := NewDedupBackend(NewMemoryBackend())
+	require.NoError(t, err)
+	testBackend(t, b)
+}
+
+func TestDedupBackendSharesBlobs(t *testing.T) {
+	inner := NewMemoryBackend()
+	b, err := NewDedupBackend(inner)
+	require.NoError(t, err)
+
+	require.NoError(t, b.Put("a.txt", strings.NewReader("same content")))
+	require.NoError(t, b.Put("b.txt", strings.NewReader("same content")))
+	require.NoError(t, b.Put("c.txt", strings.NewReader("other content")))
+	blobs, err := inner.List(blobsDir)
+	require.NoError(t, err)
+	require.Len(t, blobs, 2)
+
+	// the blob stays while a name references it
+	require.NoError(t, b.Delete("a.txt"))
+	obj, err := b.Get("b.txt")
+	require.NoError(t, err)
+	content, err := ioutil.ReadAll(obj)
+	require.NoError(t, err)
+	require.Equal(t, "same content", string(content))
+	require.NoError(t, b.Delete("b.txt"))
+	blobs, err = inner.List(blobsDir)
+	require.NoError(t, err)
+	require.Len(t, blobs, 1)
+
+	// replacing the content of a name releases its previous blob
+	require.NoError(t, b.Put("c.txt", strings.NewReader("new content")))
+	blobs, err = inner.List(blobsDir)
+	require.NoError(t, err)
+	require.Len(t, blobs, 1)
+
+	// references survive a restart
+	b, err = NewDedupBackend(inner)
+	require.NoError(t, err)
+	fi, err := b.Stat("c.txt")
+	require.NoError(t, err)
+	require.Equal(t, int64(len("new content")), fi.Size())
+}
+
+func TestDedupBackendGC(t *testing.T) {
+	inner := NewMemoryBackend()
+	b, err := NewDedupBackend(inner)
+	require.NoError(t, err)
+	require.NoError(t, b.Put("a.txt", strings.NewReader("kept")))
+	// a blob written before a crash prevented recording its reference
+	require.NoError(t, inner.Put(blobPath("0123abcd"), strings.NewReader("orphan")))
+
+	collected, err := b.GC()
+	require.NoError(t, err)
+	require.Equal(t, 1, collected)
+	blobs, err := inner.List(blobsDir)
+	require.NoError(t, err)
+	require.Len(t, blobs, 1)
+	_, err = b.Stat("a.txt")
+	require.NoError(t, err)
+}
+
+func TestDedupBackendMigration(t *testing.T) {
+	inner := NewMemoryBackend()
+	require.NoError(t, inner.Put("a.txt", strings.NewReader("same content")))
+	require.NoError(t, inner.Put("b.txt", strings.NewReader("same content")))
+	require.NoError(t, inner.Put(metaPath(indexFile), strings.NewReader("{}")))
+	before, err := inner.Stat("a.txt")
+	require.NoError(t, err)
+
+	// files stored before deduplication was enabled stay visible
+	b, err := NewDedupBackend(inner)
+	require.NoError(t, err)
+	files, err := b.List("")
+	require.NoError(t, err)
+	require.Len(t, files, 2)
+	fi, err := b.Stat("a.txt")
+	require.NoError(t, err)
+	require.Equal(t, before.ModTime(), fi.ModTime())
+	blobs, err := inner.List(blobsDir)
+	require.NoError(t, err)
+	require.Len(t, blobs, 1)
+	_, err = inner.Stat("a.txt")
+	require.True(t, os.IsNotExist(err))
+
+	// internal data is not deduplicated
+	_, err = inner.Stat(metaPath(indexFile))
+	require.NoError(t, err)
+	require.NoError(t, b.Put(metaPath("meta", "a.txt"), strings.NewReader("{}")))
+	_, err = inner.Stat(metaPath("meta", "a.txt"))
+	require.NoError(t, err)
+
+	// files moved to internal data are copied out of their blob
+	require.NoError(t, b.Rename("a.txt", metaPath("trash", "a.txt")))
+	obj, err := inner.Get(metaPath("trash", "a.txt"))
+	require.NoError(t, err)
+	content, err := ioutil.ReadAll(obj)
+	require.NoError(t, err)
+	require.Equal(t, "same content", string(content))
+	require.NoError(t, b.Rename(metaPath("trash", "a.txt"), "c.txt"))
+	_, err = b.Stat("c.txt")
+	require.NoError(t, err)
+
+	// a restart has nothing left to migrate
+	b, err = NewDedupBackend(inner)
+	require.NoError(t, err)
+	files, err = b.List("")
+	require.NoError(t, err)
+	require.Len(t, files, 2)
+}
+
+func TestFileStoreDedup(t *testing.T) {
+	config := NewConfig()
+	config.Backend = BackendMemory
+	config.Dedup = true
+	fs, err := NewFileStore(config)
+	require.NoError(t, err)
+	require.IsType(t, &DedupBackend{}, fs.backend)
+
+	w := serve(fs.Add, multipartRequest(t, "POST", "/add", map[string]string{"a.txt": "foo bar", "b.txt": "foo bar"}))
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, 4, fs.index.Count())
+}
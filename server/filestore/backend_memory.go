@@ -0,0 +1,119 @@
+package filestore
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MemoryBackend keeps files in memory, it is meant for tests and ephemeral stores
+type MemoryBackend struct {
+	mu    sync.RWMutex
+	files map[string]*memoryFile
+}
+
+// memoryFile is the content of a file kept by MemoryBackend
+type memoryFile struct {
+	data    []byte
+	modTime time.Time
+}
+
+// NewMemoryBackend returns an empty in-memory backend
+func NewMemoryBackend() *MemoryBackend {
+	return &MemoryBackend{files: make(map[string]*memoryFile)}
+}
+
+// Put writes the content of r to name
+func (b *MemoryBackend) Put(name string, r io.Reader) error {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.files[path.Clean(name)] = &memoryFile{data: data, modTime: time.Now()}
+	return nil
+}
+
+// Get opens name for reading
+func (b *MemoryBackend) Get(name string) (Object, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	file, ok := b.files[path.Clean(name)]
+	if !ok {
+		return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrNotExist}
+	}
+	return memoryObject{bytes.NewReader(file.data)}, nil
+}
+
+// Stat describes name
+func (b *MemoryBackend) Stat(name string) (os.FileInfo, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	file, ok := b.files[path.Clean(name)]
+	if !ok {
+		return nil, &os.PathError{Op: "stat", Path: name, Err: os.ErrNotExist}
+	}
+	return fileInfo{name: path.Base(name), size: int64(len(file.data)), modTime: file.modTime}, nil
+}
+
+// List returns the files directly under dir
+func (b *MemoryBackend) List(dir string) ([]os.FileInfo, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	prefix := ""
+	if dir != "" && dir != "." {
+		prefix = path.Clean(dir) + "/"
+	}
+	var files []os.FileInfo
+	for name, file := range b.files {
+		if !strings.HasPrefix(name, prefix) || strings.Contains(name[len(prefix):], "/") {
+			continue
+		}
+		files = append(files, fileInfo{name: name[len(prefix):], size: int64(len(file.data)), modTime: file.modTime})
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].Name() < files[j].Name()
+	})
+	return files, nil
+}
+
+// Delete removes name
+func (b *MemoryBackend) Delete(name string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.files[path.Clean(name)]; !ok {
+		return &os.PathError{Op: "remove", Path: name, Err: os.ErrNotExist}
+	}
+	delete(b.files, path.Clean(name))
+	return nil
+}
+
+// Rename moves oldName to newName
+func (b *MemoryBackend) Rename(oldName, newName string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	file, ok := b.files[path.Clean(oldName)]
+	if !ok {
+		return &os.PathError{Op: "rename", Path: oldName, Err: os.ErrNotExist}
+	}
+	delete(b.files, path.Clean(oldName))
+	b.files[path.Clean(newName)] = file
+	return nil
+}
+
+// memoryObject adapts a bytes.Reader to Object
+type memoryObject struct {
+	*bytes.Reader
+}
+
+// Close does nothing, the content stays in memory
+func (memoryObject) Close() error {
+	return nil
+}
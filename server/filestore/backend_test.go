@@ -0,0 +1,115 @@
+package filestore
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// testBackend checks the behavior every Backend implementation shares
+func testBackend(t *testing.T, b Backend) {
+	require.NoError(t, b.Put("a.txt", strings.NewReader("first content")))
+	require.NoError(t, b.Put("a.txt", strings.NewReader("second")))
+	require.NoError(t, b.Put(metaPath("data"), strings.NewReader("internal")))
+
+	obj, err := b.Get("a.txt")
+	require.NoError(t, err)
+	content, err := ioutil.ReadAll(obj)
+	require.NoError(t, err)
+	require.NoError(t, obj.Close())
+	require.Equal(t, "second", string(content))
+
+	// a write failing half way keeps the previous content
+	err = b.Put("a.txt", io.MultiReader(strings.NewReader("partial"), failingReader{}))
+	require.Error(t, err)
+	obj, err = b.Get("a.txt")
+	require.NoError(t, err)
+	content, err = ioutil.ReadAll(obj)
+	require.NoError(t, err)
+	require.NoError(t, obj.Close())
+	require.Equal(t, "second", string(content))
+
+	fi, err := b.Stat("a.txt")
+	require.NoError(t, err)
+	require.Equal(t, "a.txt", fi.Name())
+	require.Equal(t, int64(6), fi.Size())
+
+	files, err := b.List("")
+	require.NoError(t, err)
+	require.Len(t, files, 1)
+	require.Equal(t, "a.txt", files[0].Name())
+	files, err = b.List(metaDir)
+	require.NoError(t, err)
+	require.Len(t, files, 1)
+	require.Equal(t, "data", files[0].Name())
+
+	require.NoError(t, b.Rename("a.txt", "b.txt"))
+	_, err = b.Stat("a.txt")
+	require.True(t, os.IsNotExist(err))
+	_, err = b.Get("a.txt")
+	require.True(t, os.IsNotExist(err))
+	require.NoError(t, b.Delete("b.txt"))
+	require.True(t, os.IsNotExist(b.Delete("b.txt")))
+	require.True(t, os.IsNotExist(b.Rename("b.txt", "c.txt")))
+	_, err = b.Stat(metaDir)
+	require.True(t, os.IsNotExist(err))
+}
+
+// failingReader fails every read, it stands for an aborted upload
+type failingReader struct{}
+
+func (failingReader) Read([]byte) (int, error) {
+	return 0, errors.New("connection reset")
+}
+
+func TestLocalBackend(t *testing.T) {
+	dir, err := ioutil.TempDir("", "filestore")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	b, err := NewLocalBackend(dir)
+	require.NoError(t, err)
+	testBackend(t, b)
+
+	tmp, err := ioutil.ReadDir(filepath.Join(dir, metaDir, tmpDir))
+	require.NoError(t, err)
+	require.Empty(t, tmp)
+}
+
+func TestMemoryBackend(t *testing.T) {
+	testBackend(t, NewMemoryBackend())
+}
+
+func TestFileStoreOnMemoryBackend(t *testing.T) {
+	config := NewConfig()
+	config.Backend = BackendMemory
+	fs, err := NewFileStore(config)
+	require.NoError(t, err)
+
+	w := serve(fs.Add, multipartRequest(t, "POST", "/add", map[string]string{"a.txt": "foo bar foo"}))
+	require.Equal(t, http.StatusOK, w.Code)
+	w = serve(fs.List, httptest.NewRequest("GET", "/list", nil))
+	require.Equal(t, "a.txt\n", w.Body.String())
+	w = serve(fs.CountWords, httptest.NewRequest("GET", "/countwords", nil))
+	require.Equal(t, "  3\n", w.Body.String())
+}
+
+func TestUpdateTruncates(t *testing.T) {
+	fs := newTestStore(t)
+	defer os.RemoveAll(fs.StoreDir)
+
+	w := serve(fs.Update, multipartRequest(t, "POST", "/update", map[string]string{"a.txt": "a long first content"}))
+	require.Equal(t, http.StatusOK, w.Code)
+	w = serve(fs.Update, multipartRequest(t, "POST", "/update", map[string]string{"a.txt": "short"}))
+	require.Equal(t, http.StatusOK, w.Code)
+	content, err := ioutil.ReadFile(filepath.Join(fs.StoreDir, "a.txt"))
+	require.NoError(t, err)
+	require.Equal(t, "short", string(content))
+}
@@ -0,0 +1,75 @@
+package filestore
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// ErrCodePreconditionFailed is reported when the If-Match or If-None-Match header of a write does not hold
+const ErrCodePreconditionFailed = "precondition_failed"
+
+// PreconditionError reports a write refused because of its If-Match or If-None-Match header
+type PreconditionError struct {
+	Name   string
+	Header string
+}
+
+// Error describes the failed precondition
+func (e *PreconditionError) Error() string {
+	return fmt.Sprintf("%s: %s does not hold for file %s", ErrCodePreconditionFailed, e.Header, e.Name)
+}
+
+// checkPreconditions checks the If-Match and If-None-Match headers of a write to name against
+// the ETag of its current content. A missing file matches no tag, not even *. The caller
+// holds the lock of the file so the content cant change before it is written.
+func (fs *FileStore) checkPreconditions(r *http.Request, name string) error {
+	ifMatch, ifNoneMatch := r.Header.Get("If-Match"), r.Header.Get("If-None-Match")
+	if ifMatch == "" && ifNoneMatch == "" {
+		return nil
+	}
+	current := ""
+	meta, err := fs.metadata(name)
+	if err == nil {
+		current = etag(meta.SHA256)
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+	if ifMatch != "" && !etagMatches(ifMatch, current) {
+		return &PreconditionError{Name: name, Header: "If-Match"}
+	}
+	if ifNoneMatch != "" && etagMatches(ifNoneMatch, current) {
+		return &PreconditionError{Name: name, Header: "If-None-Match"}
+	}
+	return nil
+}
+
+// etagMatches reports whether header, * or a comma separated list of entity tags, matches
+// current, the tag of a missing file being empty. Weak tags never match.
+func etagMatches(header, current string) bool {
+	if current == "" {
+		return false
+	}
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" || tag == current {
+			return true
+		}
+	}
+	return false
+}
+
+// errorStatus returns the HTTP status reporting an error of a v1 handler
+func errorStatus(err error) int {
+	switch err.(type) {
+	case *NameError:
+		return http.StatusBadRequest
+	case *PreconditionError:
+		return http.StatusPreconditionFailed
+	}
+	if os.IsNotExist(err) {
+		return http.StatusNotFound
+	}
+	return http.StatusInternalServerError
+}
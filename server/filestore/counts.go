@@ -0,0 +1,131 @@
+package filestore
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// textCounts are the wc style counts of a file or of a set of files, words aside as they
+// depend on the analyzer
+type textCounts struct {
+	Lines int   `json:"lines"`
+	Bytes int64 `json:"bytes"`
+	// Chars counts the valid UTF-8 characters
+	Chars int `json:"chars"`
+	// MaxLineLength is the display width of the longest line, tabs stopping every 8 columns
+	MaxLineLength int `json:"max_line_length"`
+}
+
+// add accounts other in the counts, the longest line of both being kept
+func (c *textCounts) add(other textCounts) {
+	c.Lines += other.Lines
+	c.Bytes += other.Bytes
+	c.Chars += other.Chars
+	if other.MaxLineLength > c.MaxLineLength {
+		c.MaxLineLength = other.MaxLineLength
+	}
+}
+
+// textCounter computes the text counts of the content written to it, it is fed the bytes
+// read by the word scanner so files are read once
+type textCounter struct {
+	textCounts
+	// line is the display width of the current line
+	line int
+	// partial holds the bytes of a character split between writes
+	partial []byte
+}
+
+// Write accounts p in the counts
+func (c *textCounter) Write(p []byte) (int, error) {
+	c.Bytes += int64(len(p))
+	b := p
+	if len(c.partial) > 0 {
+		b = append(c.partial, p...)
+		c.partial = nil
+	}
+	for len(b) > 0 {
+		if !utf8.FullRune(b) {
+			c.partial = append([]byte(nil), b...)
+			break
+		}
+		r, size := utf8.DecodeRune(b)
+		b = b[size:]
+		if r == utf8.RuneError && size == 1 {
+			continue
+		}
+		c.Chars++
+		switch {
+		case r == '\n':
+			c.Lines++
+			c.endLine()
+		case r == '\r' || r == '\f':
+			c.endLine()
+		case r == '\t':
+			c.line += 8 - c.line%8
+		case unicode.IsPrint(r):
+			c.line++
+		}
+	}
+	return len(p), nil
+}
+
+// endLine accounts the current line in the longest one and starts a new one
+func (c *textCounter) endLine() {
+	if c.line > c.MaxLineLength {
+		c.MaxLineLength = c.line
+	}
+	c.line = 0
+}
+
+// counts returns the counts of all the content written
+func (c *textCounter) counts() textCounts {
+	c.endLine()
+	return c.textCounts
+}
+
+// countFields are the counts reported by /countwords, in the order they are written
+var countFields = []string{"lines", "words", "chars", "bytes", "max_line_length"}
+
+// parseCountFields parses the comma separated counts asked for, words only when s is empty
+func parseCountFields(s string) (map[string]bool, error) {
+	if s == "" {
+		return map[string]bool{"words": true}, nil
+	}
+	fields := make(map[string]bool)
+	for _, field := range strings.Split(s, ",") {
+		known := false
+		for _, f := range countFields {
+			known = known || f == field
+		}
+		if !known {
+			return nil, fmt.Errorf("invalid count %q, expecting %s", field, strings.Join(countFields, ", "))
+		}
+		fields[field] = true
+	}
+	return fields, nil
+}
+
+// formatCounts returns the counts selected by fields in the order of countFields, followed
+// by name unless it is empty
+func formatCounts(fields map[string]bool, words int, counts textCounts, name string) string {
+	values := map[string]int64{
+		"lines":           int64(counts.Lines),
+		"words":           int64(words),
+		"chars":           int64(counts.Chars),
+		"bytes":           counts.Bytes,
+		"max_line_length": int64(counts.MaxLineLength),
+	}
+	var columns []string
+	for _, field := range countFields {
+		if fields[field] {
+			columns = append(columns, fmt.Sprintf("%3d", values[field]))
+		}
+	}
+	if name != "" {
+		columns = append(columns, name)
+	}
+	return strings.Join(columns, " ") + "\n"
+}
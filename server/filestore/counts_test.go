@@ -0,0 +1,54 @@
+package filestore
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTextCounter(t *testing.T) {
+	for content, want := range map[string]textCounts{
+		"":                    {},
+		"one line\n":          {Lines: 1, Bytes: 9, Chars: 9, MaxLineLength: 8},
+		"no newline":          {Bytes: 10, Chars: 10, MaxLineLength: 10},
+		"a\tb\nlonger line\n": {Lines: 2, Bytes: 16, Chars: 16, MaxLineLength: 11},
+		"été ☃\n":             {Lines: 1, Bytes: 10, Chars: 6, MaxLineLength: 5},
+		"dos\r\nline\r\n":     {Lines: 2, Bytes: 11, Chars: 11, MaxLineLength: 4},
+		"bad \xff byte":       {Bytes: 10, Chars: 9, MaxLineLength: 9},
+		"\t\t|\n":             {Lines: 1, Bytes: 4, Chars: 4, MaxLineLength: 17},
+	} {
+		// characters split between writes are counted once
+		for split := 0; split <= len(content); split++ {
+			c := &textCounter{}
+			_, _ = c.Write([]byte(content[:split]))
+			_, _ = c.Write([]byte(content[split:]))
+			require.Equal(t, want, c.counts(), "%q split at %d", content, split)
+		}
+	}
+}
+
+func TestCountFields(t *testing.T) {
+	fs := newTestStore(t)
+	defer os.RemoveAll(fs.StoreDir)
+	w := serve(fs.Add, multipartRequest(t, "POST", "/add", map[string]string{
+		"a.txt": "the café\nis open\n",
+		"b.txt": "a much longer line",
+	}))
+	require.Equal(t, http.StatusOK, w.Code)
+
+	for target, want := range map[string]string{
+		"/countwords":                                        "  8\n",
+		"/countwords?fields=lines,words,bytes":               "  2   8  36\n",
+		"/countwords?fields=max_line_length,chars":           " 35  18\n",
+		"/countwords?fields=lines,chars&file=a.txt":          "  2  17\n",
+		"/countwords?fields=lines,words,bytes&per_file=true": "  2   4  18 a.txt\n  0   4  18 b.txt\n  2   8  36 total\n",
+	} {
+		w = serve(fs.CountWords, httptest.NewRequest("GET", target, nil))
+		require.Equal(t, want, w.Body.String(), target)
+	}
+	w = serve(fs.CountWords, httptest.NewRequest("GET", "/countwords?fields=lines,pages", nil))
+	require.Equal(t, http.StatusBadRequest, w.Code)
+}
@@ -0,0 +1,287 @@
+package filestore
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path"
+	"regexp"
+	"strconv"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	// grepMaxLine is the length of the longest line grep reads, longer lines are cut
+	grepMaxLine = 1 << 20
+	// grepBufferedLines is the number of lines of a file found ahead of those sent
+	grepBufferedLines = 256
+	// grepHeartbeat is the interval at which grep sends a blank line while no match is
+	// found, so clients dont take the request for stalled
+	grepHeartbeat = 10 * time.Second
+	// binaryCheckSize is the size of the start of a file checked for NUL bytes, files
+	// containing one are binary and skipped by grep
+	binaryCheckSize = 512
+)
+
+// GrepLine is a matching line found by grep or one of its context lines, the response of
+// grep is a stream of them, one JSON object per line
+type GrepLine struct {
+	File string `json:"file"`
+	Line int    `json:"line"`
+	Text string `json:"text"`
+	// Match is false for context lines, Ranges are the byte ranges of the matches in Text
+	Match  bool     `json:"match"`
+	Ranges [][2]int `json:"ranges,omitempty"`
+	// Error reports a file that could not be searched, other fields but File are empty
+	Error string `json:"error,omitempty"`
+}
+
+// Grep streams the lines of the files matching the regular expression pattern, in the
+// files matching glob when one is given. before and after are the number of context lines
+// sent around matches, context sets both. Lines are sent as they are found and the lines of
+// a file are sent together, files are searched concurrently and the search stops when the
+// client goes away.
+func (fs *FileStore) Grep(w http.ResponseWriter, r *http.Request) {
+	pattern := r.FormValue("pattern")
+	if pattern == "" {
+		http.Error(w, "missing pattern", http.StatusBadRequest)
+		return
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("invalid pattern: %v", err), http.StatusBadRequest)
+		return
+	}
+	glob := r.FormValue("glob")
+	if _, err := path.Match(glob, ""); err != nil {
+		http.Error(w, fmt.Sprintf("invalid glob %q", glob), http.StatusBadRequest)
+		return
+	}
+	lines := make(map[string]int, 3)
+	for _, name := range []string{"context", "before", "after"} {
+		s := r.FormValue(name)
+		if s == "" {
+			lines[name] = lines["context"]
+			continue
+		}
+		if lines[name], err = strconv.Atoi(s); err != nil || lines[name] < 0 {
+			http.Error(w, fmt.Sprintf("invalid %s %q", name, s), http.StatusBadRequest)
+			return
+		}
+	}
+	files, err := fs.backend.List("")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	var names []string
+	for _, fi := range files {
+		if ok, _ := path.Match(glob, fi.Name()); ok || glob == "" {
+			names = append(names, fi.Name())
+		}
+	}
+	fs.Logger.Infof("Searching %q in %d files", pattern, len(names))
+
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	results := fs.grepFiles(ctx, names, re, lines["before"], lines["after"])
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+	flusher, _ := w.(http.Flusher)
+	flush := func() {
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+	flush()
+	enc := json.NewEncoder(w)
+	heartbeat := time.NewTicker(grepHeartbeat)
+	defer heartbeat.Stop()
+	// next is nil while the lines of a file are sent, found is nil in between
+	next := results
+	var found <-chan GrepLine
+	for {
+		select {
+		case file, ok := <-next:
+			if !ok {
+				return
+			}
+			next, found = nil, file
+			continue
+		case line, ok := <-found:
+			if !ok {
+				next, found = results, nil
+				continue
+			}
+			if err := enc.Encode(line); err != nil {
+				fs.Logger.Warnf("Stopping search of %q: %v", pattern, err)
+				return
+			}
+			// lines already found are written before flushing
+			if len(found) > 0 {
+				continue
+			}
+		case <-heartbeat.C:
+			if _, err := w.Write([]byte("\n")); err != nil {
+				fs.Logger.Warnf("Stopping search of %q: %v", pattern, err)
+				return
+			}
+		}
+		flush()
+	}
+}
+
+// grepFiles searches names on a pool of workers. The returned channel receives a channel per
+// file in which lines are found, which is closed once the file is searched, and is closed
+// once all files are searched or ctx is done.
+func (fs *FileStore) grepFiles(ctx context.Context, names []string, re *regexp.Regexp, before, after int) <-chan (<-chan GrepLine) {
+	// names are fed as workers take them, so none is handed out once ctx is done
+	queue := make(chan string)
+	go func() {
+		defer close(queue)
+		for _, name := range names {
+			select {
+			case queue <- name:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	wg := &sync.WaitGroup{}
+	results := make(chan (<-chan GrepLine))
+	for i := 0; i < poolSize(fs.ScanWorkers, len(names)); i++ {
+		wg.Add(1)
+		go fs.grepInFile(ctx, queue, re, before, after, results, wg)
+	}
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+	return results
+}
+
+// grepInFile searches the files taken from queue, files that cannot be read are reported
+// by a line holding their error after the lines found before the error
+func (fs *FileStore) grepInFile(ctx context.Context, queue <-chan string, re *regexp.Regexp, before, after int, results chan<- (<-chan GrepLine), wg *sync.WaitGroup) {
+	defer wg.Done()
+	for name := range queue {
+		var lines chan GrepLine
+		// send hands line to the client, announcing the file with its first line
+		send := func(line GrepLine) bool {
+			if lines == nil {
+				lines = make(chan GrepLine, grepBufferedLines)
+				select {
+				case results <- lines:
+				case <-ctx.Done():
+					return false
+				}
+			}
+			select {
+			case lines <- line:
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		}
+		err := grepFile(ctx, fs.backend, name, re, before, after, send)
+		if err != nil && ctx.Err() == nil {
+			fs.Logger.Warnf("Could not search %s: %v", name, err)
+			send(GrepLine{File: name, Error: err.Error()})
+		}
+		if lines != nil {
+			close(lines)
+		}
+		if ctx.Err() != nil {
+			return
+		}
+	}
+}
+
+// grepFile sends the lines of a file matching re with before and after context lines as
+// they are found, it stops when send returns false. Binary files are skipped.
+func grepFile(ctx context.Context, backend Backend, name string, re *regexp.Regexp, before, after int, send func(GrepLine) bool) error {
+	file, err := backend.Get(name)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	reader := bufio.NewReader(file)
+	if head, _ := reader.Peek(binaryCheckSize); bytes.IndexByte(head, 0) >= 0 {
+		return nil
+	}
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 0, 64*1024), grepMaxLine)
+	scanner.Split(grepLines())
+
+	// previous holds the last lines before the current one, sent as context of a match
+	var previous []GrepLine
+	afterLeft := 0
+	for n := 1; scanner.Scan(); n++ {
+		if n%1024 == 0 && ctx.Err() != nil {
+			return ctx.Err()
+		}
+		line := GrepLine{File: name, Line: n, Text: scanner.Text()}
+		locs := re.FindAllStringIndex(line.Text, -1)
+		if locs == nil {
+			if afterLeft > 0 {
+				if !send(line) {
+					return ctx.Err()
+				}
+				afterLeft--
+			} else if before > 0 {
+				if len(previous) == before {
+					previous = previous[1:]
+				}
+				previous = append(previous, line)
+			}
+			continue
+		}
+		for _, prev := range previous {
+			if !send(prev) {
+				return ctx.Err()
+			}
+		}
+		previous = nil
+		line.Match = true
+		for _, loc := range locs {
+			line.Ranges = append(line.Ranges, [2]int{loc[0], loc[1]})
+		}
+		if !send(line) {
+			return ctx.Err()
+		}
+		afterLeft = after
+	}
+	return scanner.Err()
+}
+
+// grepLines returns a split function splitting lines like bufio.ScanLines, lines longer
+// than grepMaxLine are cut at a rune boundary and the rest of them is skipped
+func grepLines() bufio.SplitFunc {
+	skipping := false
+	return func(data []byte, atEOF bool) (int, []byte, error) {
+		if skipping {
+			i := bytes.IndexByte(data, '\n')
+			if i < 0 {
+				return len(data), nil, nil
+			}
+			skipping = false
+			return i + 1, nil, nil
+		}
+		advance, token, err := bufio.ScanLines(data, atEOF)
+		if advance > 0 || token != nil || err != nil || len(data) < grepMaxLine {
+			return advance, token, err
+		}
+		// data starts with a line not ending within grepMaxLine bytes
+		n := grepMaxLine - utf8.UTFMax
+		for n > 0 && !utf8.RuneStart(data[n]) {
+			n--
+		}
+		skipping = true
+		return n, data[:n], nil
+	}
+}
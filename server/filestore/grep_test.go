@@ -0,0 +1,124 @@
+package filestore
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestGrep(t *testing.T) {
+	fs := newTestStore(t)
+	defer os.RemoveAll(fs.StoreDir)
+	w := serve(fs.Add, multipartRequest(t, "POST", "/add", map[string]string{
+		"a.log": "start\nok\nerror: disk full\nok\nok\nok\nerror again, error\n",
+		"b.log": "fine\n",
+		"c.txt": "error in c\n",
+		"d.bin": "error\x00\n",
+	}))
+	require.Equal(t, http.StatusOK, w.Code)
+
+	grep := func(target string) []GrepLine {
+		w := serve(fs.Grep, httptest.NewRequest("GET", target, nil))
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		require.Equal(t, "application/x-ndjson", w.Header().Get("Content-Type"))
+		var lines []GrepLine
+		scanner := bufio.NewScanner(w.Body)
+		for scanner.Scan() {
+			line := GrepLine{}
+			require.NoError(t, json.Unmarshal(scanner.Bytes(), &line))
+			lines = append(lines, line)
+		}
+		sort.SliceStable(lines, func(i, j int) bool { return lines[i].File < lines[j].File })
+		return lines
+	}
+
+	require.Equal(t, []GrepLine{
+		{File: "a.log", Line: 3, Text: "error: disk full", Match: true, Ranges: [][2]int{{0, 5}}},
+		{File: "a.log", Line: 7, Text: "error again, error", Match: true, Ranges: [][2]int{{0, 5}, {13, 18}}},
+		{File: "c.txt", Line: 1, Text: "error in c", Match: true, Ranges: [][2]int{{0, 5}}},
+	}, grep("/grep?pattern=error"))
+
+	require.Equal(t, []GrepLine{
+		{File: "a.log", Line: 2, Text: "ok"},
+		{File: "a.log", Line: 3, Text: "error: disk full", Match: true, Ranges: [][2]int{{0, 5}}},
+		{File: "a.log", Line: 4, Text: "ok"},
+		{File: "a.log", Line: 6, Text: "ok"},
+		{File: "a.log", Line: 7, Text: "error again, error", Match: true, Ranges: [][2]int{{0, 5}, {13, 18}}},
+	}, grep("/grep?pattern=error&glob=*.log&context=1"))
+
+	lines := grep("/grep?pattern=^err&before=2&after=0&glob=a.*")
+	require.Len(t, lines, 6)
+	require.Equal(t, 1, lines[0].Line)
+
+	for _, target := range []string{"/grep", "/grep?pattern=(", "/grep?pattern=a&glob=[", "/grep?pattern=a&after=-1"} {
+		w := serve(fs.Grep, httptest.NewRequest("GET", target, nil))
+		require.Equal(t, http.StatusBadRequest, w.Code, target)
+	}
+
+	// files are searched on the scan pool, unreadable ones are reported
+	backend := &poolBackend{Backend: fs.backend, broken: map[string]bool{"b.log": true}}
+	fs.backend, fs.ScanWorkers = backend, 2
+	require.Equal(t, []GrepLine{
+		{File: "a.log", Line: 1, Text: "start", Match: true, Ranges: [][2]int{{0, 5}}},
+		{File: "b.log", Error: "unreadable"},
+	}, grep("/grep?pattern=start&glob=*.log"))
+	require.True(t, backend.maxActive <= 2, "%d files read at once", backend.maxActive)
+
+	// a client gone away stops the search
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	w = serve(fs.Grep, httptest.NewRequest("GET", "/grep?pattern=error", nil).WithContext(ctx))
+	require.Empty(t, strings.TrimSpace(w.Body.String()))
+}
+
+func TestGrepLongLines(t *testing.T) {
+	fs := newTestStore(t)
+	defer os.RemoveAll(fs.StoreDir)
+	long := strings.Repeat("é", grepMaxLine) + " error"
+	require.NoError(t, fs.backend.Put("a.log", strings.NewReader("error first\n"+long+"\nerror last\n")))
+
+	// lines longer than grepMaxLine are cut, the lines after them are still searched
+	var lines []GrepLine
+	send := func(line GrepLine) bool {
+		lines = append(lines, line)
+		return true
+	}
+	re := regexp.MustCompile("error|é")
+	require.NoError(t, grepFile(context.Background(), fs.backend, "a.log", re, 0, 0, send))
+	require.Len(t, lines, 3)
+	require.Equal(t, 1, lines[0].Line)
+	require.Equal(t, 2, lines[1].Line)
+	require.True(t, len(lines[1].Text) <= grepMaxLine && utf8.ValidString(lines[1].Text))
+	require.Equal(t, GrepLine{File: "a.log", Line: 3, Text: "error last", Match: true, Ranges: [][2]int{{0, 5}}}, lines[2])
+}
+
+func TestGrepStreams(t *testing.T) {
+	fs := newTestStore(t)
+	defer os.RemoveAll(fs.StoreDir)
+	require.NoError(t, fs.backend.Put("endless.txt", strings.NewReader("")))
+	fs.backend = endlessBackend{fs.backend}
+	server := httptest.NewServer(http.HandlerFunc(fs.Grep))
+	defer server.Close()
+
+	// lines are received before the file is searched to its end
+	client := &http.Client{Timeout: 5 * time.Second}
+	resp, err := client.Get(server.URL + "/grep?pattern=x")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	scanner := bufio.NewScanner(resp.Body)
+	require.True(t, scanner.Scan(), "%v", scanner.Err())
+	line := GrepLine{}
+	require.NoError(t, json.Unmarshal(scanner.Bytes(), &line))
+	require.Equal(t, GrepLine{File: "endless.txt", Line: 1, Text: "x", Match: true, Ranges: [][2]int{{0, 1}}}, line)
+}
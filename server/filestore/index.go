@@ -0,0 +1,498 @@
+package filestore
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// metaDir is the directory inside the store holding filestore internal data
+	metaDir = ".filestore"
+	// indexDir is the directory inside metaDir holding the index entry of every file
+	indexDir = "index"
+	// indexFile is the name of the index persisted in a single file by previous releases
+	indexFile = "index.json"
+)
+
+// indexEntryPath returns the backend name of the persisted index entry of a file
+func indexEntryPath(name string) string {
+	return metaPath(indexDir, name)
+}
+
+// fileEntry holds the indexed words of a single file of the store
+type fileEntry struct {
+	Size    int64          `json:"size"`
+	ModTime time.Time      `json:"mod_time"`
+	Count   int            `json:"count"`
+	Words   map[string]int `json:"words"`
+	// Positions lists the positions of every word in the file, counted in words
+	Positions map[string][]int `json:"positions"`
+	textCounts
+}
+
+// outdated reports whether the entry was made before positions and text counts were
+// recorded. Sync scans the files of outdated entries again, so the first start after an
+// upgrade from such a release scans the whole store once.
+func (e *fileEntry) outdated() bool {
+	return (e.Positions == nil && e.Count > 0) || (e.Size > 0 && e.Chars == 0 && e.Lines == 0)
+}
+
+// wordIndex keeps per-file word counts and the store-wide totals derived from them
+type wordIndex struct {
+	mu      sync.RWMutex
+	saveMu  sync.Mutex
+	backend Backend
+	files   map[string]*fileEntry
+	totals  map[string]int
+	count   int
+	// docs maps every word to the files containing it
+	docs map[string]map[string]struct{}
+	// dirty holds the files whose entry changed since the index was saved
+	dirty map[string]bool
+	// legacy is set when the index was read from indexFile, which goes once saved per file
+	legacy bool
+	// failed holds the errors of the stored files that could not be scanned, they have no entry
+	failed map[string]string
+	// folded maps the lower case names of the indexed files and of those that could not be
+	// scanned to their names, for names to be matched ignoring case
+	folded map[string]string
+	// analyzed caches the words of the store as produced by analyzers until the index changes
+	analyzedMu sync.Mutex
+	analyzed   map[string]*analyzedWords
+	// terms maps the words searched by queries, as produced by the standard analyzer, to the
+	// indexed words they come from until the index changes, fileTerms caches the searched
+	// words of the files until they change
+	terms     map[string][]string
+	fileTerms map[string]*fileTerms
+}
+
+// analyzedWords are the words of the store as produced by an analyzer
+type analyzedWords struct {
+	words map[string]int
+	count int
+	// forms maps stems to their most common surface form when the analyzer stems
+	forms map[string]string
+	// surface holds the words before they are stemmed when the analyzer stems, stopwords
+	// are removed from them
+	surface map[string]int
+}
+
+// without returns the words without the stopwords of a, their number and the most common
+// surface form of every stem when a stems. Stopwords are removed before stemming, so words
+// sharing their stem with a stopword are kept.
+func (analyzed *analyzedWords) without(a *analyzer) (map[string]int, int, map[string]string) {
+	if analyzed.surface == nil {
+		words, count := withoutStopwords(analyzed.words, analyzed.count, a.stopwords)
+		return words, count, nil
+	}
+	if len(a.stopwords) == 0 {
+		return analyzed.words, analyzed.count, analyzed.forms
+	}
+	surface, count := withoutStopwords(analyzed.surface, analyzed.count, a.stopwords)
+	words, forms := stemWords(surface, a)
+	return words, count, forms
+}
+
+// newWordIndex returns an empty index persisted in backend
+func newWordIndex(backend Backend) *wordIndex {
+	return &wordIndex{
+		backend: backend,
+		files:   make(map[string]*fileEntry),
+		totals:  make(map[string]int),
+		docs:    make(map[string]map[string]struct{}),
+		dirty:   make(map[string]bool),
+		failed:  make(map[string]string),
+		folded:  make(map[string]string),
+	}
+}
+
+// loadWordIndex reads the index persisted in backend, a missing index is returned empty.
+// Entries that cannot be decoded are left out, Sync scans their files again.
+func loadWordIndex(backend Backend) (*wordIndex, error) {
+	ix := newWordIndex(backend)
+	if err := ix.loadLegacy(); err != nil {
+		return nil, err
+	}
+	files, err := backend.List(metaPath(indexDir))
+	if err != nil {
+		return nil, err
+	}
+	for _, fi := range files {
+		obj, err := backend.Get(indexEntryPath(fi.Name()))
+		if err != nil {
+			return nil, err
+		}
+		entry := &fileEntry{}
+		err = json.NewDecoder(obj).Decode(entry)
+		obj.Close()
+		if err != nil {
+			continue
+		}
+		ix.drop(fi.Name())
+		ix.add(fi.Name(), entry)
+		delete(ix.dirty, fi.Name())
+	}
+	return ix, nil
+}
+
+// loadLegacy reads the index persisted in indexFile, its entries are saved per file by the
+// next Save
+func (ix *wordIndex) loadLegacy() error {
+	obj, err := ix.backend.Get(metaPath(indexFile))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer obj.Close()
+	var files map[string]*fileEntry
+	if err := json.NewDecoder(obj).Decode(&files); err != nil {
+		return err
+	}
+	for name, entry := range files {
+		ix.add(name, entry)
+	}
+	ix.legacy = true
+	return nil
+}
+
+// add accounts entry in the totals, the caller holds the lock
+func (ix *wordIndex) add(name string, entry *fileEntry) {
+	ix.invalidate(name)
+	ix.dirty[name] = true
+	ix.files[name] = entry
+	delete(ix.failed, name)
+	ix.folded[strings.ToLower(name)] = name
+	for k, v := range entry.Words {
+		ix.totals[k] += v
+		if ix.docs[k] == nil {
+			ix.docs[k] = make(map[string]struct{})
+		}
+		ix.docs[k][name] = struct{}{}
+	}
+	ix.count += entry.Count
+}
+
+// drop removes name from the totals, the caller holds the lock
+func (ix *wordIndex) drop(name string) {
+	entry, ok := ix.files[name]
+	if !ok {
+		return
+	}
+	ix.invalidate(name)
+	for k, v := range entry.Words {
+		ix.totals[k] -= v
+		if ix.totals[k] <= 0 {
+			delete(ix.totals, k)
+		}
+		delete(ix.docs[k], name)
+		if len(ix.docs[k]) == 0 {
+			delete(ix.docs, k)
+		}
+	}
+	ix.count -= entry.Count
+	delete(ix.files, name)
+	ix.dirty[name] = true
+}
+
+// Set replaces the entry of a file
+func (ix *wordIndex) Set(name string, entry *fileEntry) {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	ix.drop(name)
+	ix.add(name, entry)
+}
+
+// forget removes every trace of a file, the caller holds the lock
+func (ix *wordIndex) forget(name string) {
+	ix.drop(name)
+	delete(ix.failed, name)
+	if key := strings.ToLower(name); ix.folded[key] == name {
+		delete(ix.folded, key)
+	}
+}
+
+// Remove forgets a file
+func (ix *wordIndex) Remove(name string) {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	ix.forget(name)
+}
+
+// FoldedName returns the name of the file whose name only differs from name by case
+func (ix *wordIndex) FoldedName(name string) (string, bool) {
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+	stored, ok := ix.folded[strings.ToLower(name)]
+	return stored, ok
+}
+
+// Fail drops the entry of a file that could not be scanned and records the error, word
+// statistics report it until the file is indexed or removed
+func (ix *wordIndex) Fail(name string, err error) {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	ix.drop(name)
+	ix.failed[name] = err.Error()
+	ix.folded[strings.ToLower(name)] = name
+}
+
+// ScanErrors returns the errors of the given files that could not be scanned, of all the
+// files of the store when names is nil, and reports whether none of the files was scanned
+func (ix *wordIndex) ScanErrors(names []string) ([]FileError, bool) {
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+	var errs []FileError
+	if names == nil {
+		for name, msg := range ix.failed {
+			errs = append(errs, FileError{Name: name, Error: msg})
+		}
+		sortFileErrors(errs)
+		return errs, len(errs) > 0 && len(ix.files) == 0
+	}
+	for _, name := range names {
+		if msg, ok := ix.failed[name]; ok {
+			errs = append(errs, FileError{Name: name, Error: msg})
+		}
+	}
+	return errs, len(errs) > 0 && len(errs) == len(names)
+}
+
+// Words returns a copy of the store-wide word occurrences
+func (ix *wordIndex) Words() map[string]int {
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+	words := make(map[string]int, len(ix.totals))
+	for k, v := range ix.totals {
+		words[k] = v
+	}
+	return words
+}
+
+// Count returns the number of words in the store
+func (ix *wordIndex) Count() int {
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+	return ix.count
+}
+
+// Analyze returns the occurrences of the words of the store as produced by a and their
+// number, without the stopwords of a, along with the most common surface form of every
+// stem when a stems. The words and forms are shared with other callers and must not be
+// modified.
+func (ix *wordIndex) Analyze(a *analyzer) (map[string]int, int, map[string]string) {
+	return ix.analyze(a).without(a)
+}
+
+// analyze returns the words of the store as produced by a, stopwords included
+func (ix *wordIndex) analyze(a *analyzer) *analyzedWords {
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+	if a.raw() {
+		return &analyzedWords{words: ix.totals, count: ix.count}
+	}
+	ix.analyzedMu.Lock()
+	defer ix.analyzedMu.Unlock()
+	if cached, ok := ix.analyzed[a.key()]; ok {
+		return cached
+	}
+	analyzed := newAnalyzedWords(ix.totals, a)
+	if ix.analyzed == nil {
+		ix.analyzed = make(map[string]*analyzedWords)
+	}
+	ix.analyzed[a.key()] = analyzed
+	return analyzed
+}
+
+// AnalyzeFiles is Analyze restricted to the given files of the store, their words are not cached
+func (ix *wordIndex) AnalyzeFiles(a *analyzer, names []string) (map[string]int, int, map[string]string) {
+	ix.mu.RLock()
+	totals := make(map[string]int)
+	count := 0
+	for _, name := range names {
+		if entry, ok := ix.files[name]; ok {
+			for word, n := range entry.Words {
+				totals[word] += n
+			}
+			count += entry.Count
+		}
+	}
+	ix.mu.RUnlock()
+	analyzed := &analyzedWords{words: totals, count: count}
+	if !a.raw() {
+		analyzed = newAnalyzedWords(totals, a)
+	}
+	return analyzed.without(a)
+}
+
+// TextCounts returns the text counts of the given files, of all the files of the store when
+// names is nil
+func (ix *wordIndex) TextCounts(names []string) textCounts {
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+	var counts textCounts
+	if names == nil {
+		for _, entry := range ix.files {
+			counts.add(entry.textCounts)
+		}
+		return counts
+	}
+	for _, name := range names {
+		if entry, ok := ix.files[name]; ok {
+			counts.add(entry.textCounts)
+		}
+	}
+	return counts
+}
+
+// newAnalyzedWords returns the words produced by a from words, the occurrences of the
+// words of the files
+func newAnalyzedWords(words map[string]int, a *analyzer) *analyzedWords {
+	analyzed := &analyzedWords{}
+	analyzed.words, analyzed.count = analyzeWords(words, a)
+	if a.stem != nil {
+		analyzed.surface = analyzed.words
+		analyzed.words, analyzed.forms = stemWords(analyzed.surface, a)
+	}
+	return analyzed
+}
+
+// invalidate drops the analyzed words and the searched words of name, the caller holds the
+// write lock
+func (ix *wordIndex) invalidate(name string) {
+	ix.analyzedMu.Lock()
+	ix.analyzed = nil
+	ix.terms = nil
+	delete(ix.fileTerms, name)
+	ix.analyzedMu.Unlock()
+}
+
+// Outdated returns the number of entries made by a previous release, which Sync upgrades
+func (ix *wordIndex) Outdated() int {
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+	outdated := 0
+	for _, entry := range ix.files {
+		if entry.outdated() {
+			outdated++
+		}
+	}
+	return outdated
+}
+
+// Save persists the entries of the files which changed since the index was last saved,
+// the entries of other files are not written again
+func (ix *wordIndex) Save() error {
+	ix.saveMu.Lock()
+	defer ix.saveMu.Unlock()
+	ix.mu.Lock()
+	dirty := ix.dirty
+	ix.dirty = make(map[string]bool)
+	entries := make(map[string]*fileEntry, len(dirty))
+	for name := range dirty {
+		entries[name] = ix.files[name]
+	}
+	legacy := ix.legacy
+	ix.mu.Unlock()
+
+	for name, entry := range entries {
+		if err := ix.saveEntry(name, entry); err != nil {
+			// entries not saved yet are saved by the next Save
+			ix.mu.Lock()
+			for name := range entries {
+				ix.dirty[name] = true
+			}
+			ix.mu.Unlock()
+			return err
+		}
+		delete(entries, name)
+	}
+	if !legacy {
+		return nil
+	}
+	if err := ix.backend.Delete(metaPath(indexFile)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	ix.mu.Lock()
+	ix.legacy = false
+	ix.mu.Unlock()
+	return nil
+}
+
+// saveEntry writes the entry of a file, a nil entry deletes it
+func (ix *wordIndex) saveEntry(name string, entry *fileEntry) error {
+	if entry == nil {
+		if err := ix.backend.Delete(indexEntryPath(name)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	b, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	return ix.backend.Put(indexEntryPath(name), bytes.NewReader(b))
+}
+
+// Sync brings the index up to date with the content of the store: new or modified files,
+// and files indexed before word positions were recorded, are scanned again and entries of
+// files no longer stored are dropped. Files are scanned by workers goroutines, the files that
+// could not be read are left out, recorded for ScanErrors and reported by a ScanError. It
+// reports whether the index changed.
+func (ix *wordIndex) Sync(workers int) (bool, error) {
+	files, err := ix.backend.List("")
+	if err != nil {
+		return false, err
+	}
+	present := make(map[string]bool, len(files))
+	var stale []string
+	ix.mu.RLock()
+	for _, fi := range files {
+		present[fi.Name()] = true
+		entry, ok := ix.files[fi.Name()]
+		if !ok || entry.Size != fi.Size() || !entry.ModTime.Equal(fi.ModTime()) || entry.outdated() {
+			stale = append(stale, fi.Name())
+		}
+	}
+	var removed []string
+	for name := range ix.files {
+		if !present[name] {
+			removed = append(removed, name)
+		}
+	}
+	for name := range ix.failed {
+		if !present[name] {
+			removed = append(removed, name)
+		}
+	}
+	ix.mu.RUnlock()
+	if len(stale) == 0 && len(removed) == 0 {
+		return false, nil
+	}
+
+	entries, errs := searchInDir(ix.backend, stale, workers)
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	for _, name := range removed {
+		ix.forget(name)
+	}
+	for name, entry := range entries {
+		ix.drop(name)
+		ix.add(name, entry)
+	}
+	for _, f := range errs {
+		ix.drop(f.Name)
+		ix.failed[f.Name] = f.Error
+		ix.folded[strings.ToLower(f.Name)] = f.Name
+	}
+	changed := len(removed) > 0 || len(entries) > 0
+	if len(errs) > 0 {
+		return changed, &ScanError{Files: errs}
+	}
+	return changed, nil
+}
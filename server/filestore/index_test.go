@@ -0,0 +1,132 @@
+package filestore
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestIndexFollowsWrites(t *testing.T) {
+	fs := newTestStore(t)
+	defer os.RemoveAll(fs.StoreDir)
+
+	w := serve(fs.Add, multipartRequest(t, "POST", "/add", map[string]string{
+		"a.txt": "foo bar foo",
+		"b.txt": "foo baz",
+	}))
+	require.Equal(t, http.StatusOK, w.Code)
+	w = serve(fs.CountWords, httptest.NewRequest("GET", "/countwords", nil))
+	require.Equal(t, "  5\n", w.Body.String())
+	w = serve(fs.FreqWords, httptest.NewRequest("GET", "/freqwords?limit=1&order=dsc", nil))
+	require.Equal(t, "  3 foo\n", w.Body.String())
+
+	w = serve(fs.Update, multipartRequest(t, "POST", "/update", map[string]string{"b.txt": "baz baz baz"}))
+	require.Equal(t, http.StatusOK, w.Code)
+	w = serve(fs.FreqWords, httptest.NewRequest("GET", "/freqwords?limit=1&order=dsc", nil))
+	require.Equal(t, "  3 baz\n", w.Body.String())
+
+	w = serve(fs.Remove, httptest.NewRequest("POST", "/remove?file=b.txt", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	w = serve(fs.CountWords, httptest.NewRequest("GET", "/countwords", nil))
+	require.Equal(t, "  3\n", w.Body.String())
+}
+
+func TestIndexRebuiltOnStartup(t *testing.T) {
+	fs := newTestStore(t)
+	defer os.RemoveAll(fs.StoreDir)
+	require.NoError(t, ioutil.WriteFile(filepath.Join(fs.StoreDir, "a.txt"), []byte("one two"), 0644))
+
+	config := NewConfig()
+	config.StoreDir = fs.StoreDir
+	fs, err := NewFileStore(config)
+	require.NoError(t, err)
+	require.Equal(t, 2, fs.index.Count())
+	_, err = os.Stat(filepath.Join(fs.StoreDir, metaDir, indexDir, "a.txt"))
+	require.NoError(t, err)
+
+	// a file changed behind the server back is scanned again, a removed one is dropped
+	require.NoError(t, ioutil.WriteFile(filepath.Join(fs.StoreDir, "a.txt"), []byte("one two three"), 0644))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(fs.StoreDir, "b.txt"), []byte("four"), 0644))
+	fs, err = NewFileStore(config)
+	require.NoError(t, err)
+	require.Equal(t, 4, fs.index.Count())
+	require.NoError(t, os.Remove(filepath.Join(fs.StoreDir, "b.txt")))
+	fs, err = NewFileStore(config)
+	require.NoError(t, err)
+	require.Equal(t, 3, fs.index.Count())
+	require.Equal(t, map[string]int{"one": 1, "two": 1, "three": 1}, fs.index.Words())
+}
+
+// countingBackend counts the writes of the index entries
+type countingBackend struct {
+	Backend
+	puts map[string]int
+}
+
+func (b *countingBackend) Put(name string, r io.Reader) error {
+	b.puts[name]++
+	return b.Backend.Put(name, r)
+}
+
+func TestIndexSavedPerFile(t *testing.T) {
+	fs := newTestStore(t)
+	defer os.RemoveAll(fs.StoreDir)
+	w := serve(fs.Add, multipartRequest(t, "POST", "/add", map[string]string{"a.txt": "one two", "b.txt": "three"}))
+	require.Equal(t, http.StatusOK, w.Code)
+
+	// a write saves the entry of its file only
+	backend := &countingBackend{Backend: fs.backend, puts: make(map[string]int)}
+	fs.backend, fs.index.backend = backend, backend
+	w = serve(fs.Update, multipartRequest(t, "POST", "/update", map[string]string{"b.txt": "four five"}))
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, 1, backend.puts[indexEntryPath("b.txt")])
+	require.Zero(t, backend.puts[indexEntryPath("a.txt")])
+	w = serve(fs.Remove, httptest.NewRequest("POST", "/remove?file=b.txt", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	_, err := os.Stat(filepath.Join(fs.StoreDir, metaDir, indexDir, "b.txt"))
+	require.True(t, os.IsNotExist(err))
+
+	// an index saved in a single file is split on start
+	legacy := `{"a.txt":{"size":7,"count":2,"words":{"one":1,"two":1}}}`
+	require.NoError(t, os.RemoveAll(filepath.Join(fs.StoreDir, metaDir, indexDir)))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(fs.StoreDir, metaDir, indexFile), []byte(legacy), 0644))
+	config := NewConfig()
+	config.StoreDir = fs.StoreDir
+	fs, err = NewFileStore(config)
+	require.NoError(t, err)
+	require.Equal(t, 2, fs.index.Count())
+	_, err = os.Stat(filepath.Join(fs.StoreDir, metaDir, indexFile))
+	require.True(t, os.IsNotExist(err))
+	_, err = os.Stat(filepath.Join(fs.StoreDir, metaDir, indexDir, "a.txt"))
+	require.NoError(t, err)
+}
+
+func TestIndexLongWords(t *testing.T) {
+	fs := newTestStore(t)
+	defer os.RemoveAll(fs.StoreDir)
+
+	// longer than the default token limit of bufio.Scanner
+	long := strings.Repeat("x", 100*1024)
+	w := serve(fs.Add, multipartRequest(t, "POST", "/add", map[string]string{"a.txt": long + " end"}))
+	require.Equal(t, http.StatusOK, w.Code)
+	w = serve(fs.CountWords, httptest.NewRequest("GET", "/countwords", nil))
+	require.Equal(t, "  2\n", w.Body.String())
+
+	// words longer than maxWordLength are cut
+	scanner := newWordScanner(strings.NewReader(strings.Repeat("é", maxWordLength) + " end"))
+	var lengths []int
+	for scanner.Scan() {
+		lengths = append(lengths, len(scanner.Bytes()))
+	}
+	require.NoError(t, scanner.Err())
+	require.Len(t, lengths, 4)
+	require.Equal(t, 2*maxWordLength, lengths[0]+lengths[1]+lengths[2])
+	require.Equal(t, 3, lengths[3])
+}
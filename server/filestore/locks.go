@@ -0,0 +1,41 @@
+package filestore
+
+import "sync"
+
+// keyedLocks hands out a mutex per key, mutexes are dropped once nobody holds or waits for them
+type keyedLocks struct {
+	mu    sync.Mutex
+	locks map[string]*keyedLock
+}
+
+// keyedLock is the mutex of a key and the number of holders and waiters
+type keyedLock struct {
+	sync.Mutex
+	refs int
+}
+
+// lock locks key and returns the unlock function
+func (k *keyedLocks) lock(key string) func() {
+	k.mu.Lock()
+	if k.locks == nil {
+		k.locks = make(map[string]*keyedLock)
+	}
+	l, ok := k.locks[key]
+	if !ok {
+		l = &keyedLock{}
+		k.locks[key] = l
+	}
+	l.refs++
+	k.mu.Unlock()
+
+	l.Lock()
+	return func() {
+		l.Unlock()
+		k.mu.Lock()
+		l.refs--
+		if l.refs == 0 {
+			delete(k.locks, key)
+		}
+		k.mu.Unlock()
+	}
+}
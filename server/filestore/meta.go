@@ -0,0 +1,259 @@
+package filestore
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// UserHeader is the request header naming the user writing files when no basic auth is used
+const UserHeader = "X-Filestore-User"
+
+// Metadata is the record kept for every file of the store
+type Metadata struct {
+	Name        string            `json:"name"`
+	Size        int64             `json:"size"`
+	SHA256      string            `json:"sha256"`
+	ContentType string            `json:"content_type"`
+	Created     time.Time         `json:"created"`
+	Modified    time.Time         `json:"modified"`
+	Uploader    string            `json:"uploader,omitempty"`
+	Labels      map[string]string `json:"labels,omitempty"`
+}
+
+// metadataPath returns the backend name of the metadata record of a file
+func metadataPath(name string) string {
+	return metaPath("meta", name)
+}
+
+// upload describes who writes a file and the labels to attach to it
+type upload struct {
+	Uploader string
+	Labels   map[string]string
+}
+
+// uploadFromRequest reads the uploader and the label query parameters, given as key=value, of r
+func uploadFromRequest(r *http.Request) (upload, error) {
+	u := upload{Uploader: r.Header.Get(UserHeader)}
+	if user, _, ok := r.BasicAuth(); ok {
+		u.Uploader = user
+	}
+	for _, label := range r.URL.Query()["label"] {
+		kv := strings.SplitN(label, "=", 2)
+		if len(kv) != 2 || kv[0] == "" {
+			return u, fmt.Errorf("invalid label %q, expecting key=value", label)
+		}
+		if u.Labels == nil {
+			u.Labels = make(map[string]string)
+		}
+		u.Labels[kv[0]] = kv[1]
+	}
+	return u, nil
+}
+
+// digest observes a content while it is written to compute its metadata
+type digest struct {
+	hash hash.Hash
+	size int64
+	head []byte
+}
+
+func newDigest() *digest {
+	return &digest{hash: sha256.New()}
+}
+
+// Write accounts p in the digest
+func (d *digest) Write(p []byte) (int, error) {
+	if missing := 512 - len(d.head); missing > 0 {
+		if missing > len(p) {
+			missing = len(p)
+		}
+		d.head = append(d.head, p[:missing]...)
+	}
+	d.size += int64(len(p))
+	return d.hash.Write(p)
+}
+
+// contentType returns the content type of the content named name
+func (d *digest) contentType(name string) string {
+	if contentType := mime.TypeByExtension(filepath.Ext(name)); contentType != "" {
+		return contentType
+	}
+	return http.DetectContentType(d.head)
+}
+
+// loadMetadata reads the metadata record of a file
+func (fs *FileStore) loadMetadata(name string) (*Metadata, error) {
+	obj, err := fs.backend.Get(metadataPath(name))
+	if err != nil {
+		return nil, err
+	}
+	defer obj.Close()
+	meta := &Metadata{}
+	if err := json.NewDecoder(obj).Decode(meta); err != nil {
+		return nil, err
+	}
+	return meta, nil
+}
+
+// saveMetadata writes the metadata record of a file
+func (fs *FileStore) saveMetadata(meta *Metadata) error {
+	b, err := json.Marshal(meta)
+	if err != nil {
+		return err
+	}
+	return fs.backend.Put(metadataPath(meta.Name), bytes.NewReader(b))
+}
+
+// metadata returns the metadata record of a file. Files written without going through
+// the server, or before records were kept, get a record built from their content.
+func (fs *FileStore) metadata(name string) (*Metadata, error) {
+	fi, err := fs.backend.Stat(name)
+	if err != nil {
+		return nil, err
+	}
+	meta, err := fs.loadMetadata(name)
+	if err == nil && meta.Size == fi.Size() && !meta.Modified.Before(fi.ModTime()) {
+		return meta, nil
+	}
+	if err != nil && !os.IsNotExist(err) {
+		fs.Logger.Warnf("Could not read metadata of %s, rebuilding it: %v", name, err)
+	}
+	obj, err := fs.backend.Get(name)
+	if err != nil {
+		return nil, err
+	}
+	defer obj.Close()
+	d := newDigest()
+	if _, err := io.Copy(d, obj); err != nil {
+		return nil, err
+	}
+	rebuilt := &Metadata{
+		Name:        name,
+		Size:        d.size,
+		SHA256:      hex.EncodeToString(d.hash.Sum(nil)),
+		ContentType: d.contentType(name),
+		Created:     fi.ModTime(),
+		Modified:    fi.ModTime(),
+	}
+	if meta != nil {
+		rebuilt.Created, rebuilt.Uploader, rebuilt.Labels = meta.Created, meta.Uploader, meta.Labels
+	}
+	if err := fs.saveMetadata(rebuilt); err != nil {
+		return nil, err
+	}
+	return rebuilt, nil
+}
+
+// listMetadata returns the metadata records of files, files removed meanwhile are skipped
+func (fs *FileStore) listMetadata(files []os.FileInfo) ([]*Metadata, error) {
+	records := make([]*Metadata, 0, len(files))
+	for _, file := range files {
+		meta, err := fs.metadata(file.Name())
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, meta)
+	}
+	return records, nil
+}
+
+// write stores the content of r as name, records its metadata and indexes its words.
+// A file that cannot be indexed is still stored.
+// Labels of an existing file are kept unless the upload sets some.
+func (fs *FileStore) write(name string, r io.Reader, u upload) error {
+	d := newDigest()
+	if err := fs.backend.Put(name, io.TeeReader(r, d)); err != nil {
+		return err
+	}
+	return fs.record(name, d, u)
+}
+
+// record saves the metadata of name, whose content d observed while it was stored, and
+// indexes its words
+func (fs *FileStore) record(name string, d *digest, u upload) error {
+	now := time.Now()
+	meta := &Metadata{
+		Name:        name,
+		Size:        d.size,
+		SHA256:      hex.EncodeToString(d.hash.Sum(nil)),
+		ContentType: d.contentType(name),
+		Created:     now,
+		Modified:    now,
+		Uploader:    u.Uploader,
+		Labels:      u.Labels,
+	}
+	if previous, err := fs.loadMetadata(name); err == nil {
+		meta.Created = previous.Created
+		if meta.Labels == nil {
+			meta.Labels = previous.Labels
+		}
+	}
+	if err := fs.saveMetadata(meta); err != nil {
+		return err
+	}
+	// the content is stored whatever happens to the index, failing the write would
+	// have clients retry a file that already exists
+	if err := fs.reindex(name); err != nil {
+		fs.Logger.Errorf("Could not index %s: %v", name, err)
+	}
+	return fs.index.Save()
+}
+
+// setMetadataHeaders describes a file in the response headers
+func setMetadataHeaders(w http.ResponseWriter, meta *Metadata) {
+	w.Header().Set("ETag", etag(meta.SHA256))
+	w.Header().Set("X-Filestore-Name", meta.Name)
+	w.Header().Set("X-Filestore-Size", strconv.FormatInt(meta.Size, 10))
+	w.Header().Set("X-Filestore-Sha256", meta.SHA256)
+	w.Header().Set("X-Filestore-Content-Type", meta.ContentType)
+	w.Header().Set("X-Filestore-Created", meta.Created.UTC().Format(time.RFC3339))
+	w.Header().Set("X-Filestore-Modified", meta.Modified.UTC().Format(time.RFC3339))
+	if meta.Uploader != "" {
+		w.Header().Set("X-Filestore-Uploader", meta.Uploader)
+	}
+	for k, v := range meta.Labels {
+		w.Header().Add("X-Filestore-Label", k+"="+v)
+	}
+}
+
+// Stat returns the metadata record of a file as JSON, HEAD requests get it in headers only
+func (fs *FileStore) Stat(w http.ResponseWriter, r *http.Request) {
+	fileName, err := fs.checkName(r.FormValue("file"))
+	if err != nil {
+		http.Error(w, err.Error(), nameStatus(err))
+		return
+	}
+	fs.Logger.Infof("Describing file %s", fileName)
+	meta, err := fs.metadata(fileName)
+	if os.IsNotExist(err) {
+		http.Error(w, "File does not exist", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	setMetadataHeaders(w, meta)
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method == http.MethodHead {
+		return
+	}
+	if err := json.NewEncoder(w).Encode(meta); err != nil {
+		fs.Logger.Errorf("Could not write metadata of %s: %v", fileName, err)
+	}
+}
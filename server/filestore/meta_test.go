@@ -0,0 +1,67 @@
+package filestore
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMetadata(t *testing.T) {
+	fs := newTestStore(t)
+	defer os.RemoveAll(fs.StoreDir)
+
+	req := multipartRequest(t, "POST", "/add?label=team=ops&label=env=prod", map[string]string{"a.txt": "hello"})
+	req.Header.Set(UserHeader, "alice")
+	w := serve(fs.Add, req)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	w = serve(fs.Stat, httptest.NewRequest("GET", "/stat?file=a.txt", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	var meta Metadata
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &meta))
+	require.Equal(t, "a.txt", meta.Name)
+	require.Equal(t, int64(5), meta.Size)
+	require.Equal(t, "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824", meta.SHA256)
+	require.Equal(t, "text/plain; charset=utf-8", meta.ContentType)
+	require.Equal(t, "alice", meta.Uploader)
+	require.Equal(t, map[string]string{"team": "ops", "env": "prod"}, meta.Labels)
+
+	// an update keeps the creation time and the labels
+	req = multipartRequest(t, "POST", "/update", map[string]string{"a.txt": "hello world"})
+	req.SetBasicAuth("bob", "")
+	w = serve(fs.Update, req)
+	require.Equal(t, http.StatusOK, w.Code)
+	w = serve(fs.Stat, httptest.NewRequest("HEAD", "/stat?file=a.txt", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Empty(t, w.Body.String())
+	require.Equal(t, "11", w.Header().Get("X-Filestore-Size"))
+	require.Equal(t, "bob", w.Header().Get("X-Filestore-Uploader"))
+	require.Equal(t, meta.Created.UTC().Format("2006-01-02T15:04:05Z07:00"), w.Header().Get("X-Filestore-Created"))
+	require.ElementsMatch(t, []string{"team=ops", "env=prod"}, w.Header()["X-Filestore-Label"])
+
+	w = serve(fs.Add, multipartRequest(t, "POST", "/add?label=broken", map[string]string{"b.txt": "x"}))
+	require.Equal(t, http.StatusBadRequest, w.Code)
+	w = serve(fs.Stat, httptest.NewRequest("GET", "/stat?file=b.txt", nil))
+	require.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestMetadataOfUntrackedFiles(t *testing.T) {
+	fs := newTestStore(t)
+	defer os.RemoveAll(fs.StoreDir)
+	require.NoError(t, ioutil.WriteFile(filepath.Join(fs.StoreDir, "a.json"), []byte(`{"a": 1}`), 0644))
+
+	w := serve(fs.List, httptest.NewRequest("GET", "/list?long=true", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	var records []Metadata
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &records))
+	require.Len(t, records, 1)
+	require.Equal(t, "a.json", records[0].Name)
+	require.Equal(t, "application/json", records[0].ContentType)
+	require.Equal(t, int64(8), records[0].Size)
+}
@@ -0,0 +1,185 @@
+package filestore
+
+import (
+	"fmt"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Error codes reported when a file name is rejected
+const (
+	ErrCodeNameEmpty       = "name_empty"
+	ErrCodeNameAbsolute    = "name_absolute"
+	ErrCodeNameTraversal   = "name_traversal"
+	ErrCodeNameSeparator   = "name_separator"
+	ErrCodeNameControlChar = "name_control_character"
+	ErrCodeNameReserved    = "name_reserved"
+	ErrCodeNameTooLong     = "name_too_long"
+	ErrCodeNameExtension   = "name_extension_not_allowed"
+	ErrCodeNameEncoding    = "name_invalid_utf8"
+)
+
+const (
+	// CaseSensitive names are distinct when they differ by case only
+	CaseSensitive = "sensitive"
+	// CaseInsensitive names are matched ignoring case, the case used when a file is added is kept
+	CaseInsensitive = "insensitive"
+
+	// DefaultMaxNameLength is the default maximum length in bytes of a file name
+	DefaultMaxNameLength = 255
+)
+
+// reservedNames cant be used as file names, they are compared ignoring case
+var reservedNames = map[string]bool{
+	metaDir: true, "con": true, "prn": true, "aux": true, "nul": true,
+	"com1": true, "com2": true, "com3": true, "com4": true, "com5": true,
+	"com6": true, "com7": true, "com8": true, "com9": true,
+	"lpt1": true, "lpt2": true, "lpt3": true, "lpt4": true, "lpt5": true,
+	"lpt6": true, "lpt7": true, "lpt8": true, "lpt9": true,
+}
+
+// NamePolicy holds the rules file names must follow
+type NamePolicy struct {
+	MaxLength         int
+	AllowedExtensions []string
+	Case              string
+}
+
+// NameError reports a file name rejected by the name policy
+type NameError struct {
+	Name   string
+	Code   string
+	Reason string
+}
+
+// Error returns the error code followed by a description of the error
+func (e *NameError) Error() string {
+	return fmt.Sprintf("%s: invalid file name %q: %s", e.Code, e.Name, e.Reason)
+}
+
+// Validate checks name against the policy
+func (p NamePolicy) Validate(name string) error {
+	fail := func(code, reason string) error {
+		return &NameError{Name: name, Code: code, Reason: reason}
+	}
+	if name == "" {
+		return fail(ErrCodeNameEmpty, "name is empty")
+	}
+	if !utf8.ValidString(name) {
+		return fail(ErrCodeNameEncoding, "name is not valid UTF-8")
+	}
+	for _, r := range name {
+		if unicode.IsControl(r) {
+			return fail(ErrCodeNameControlChar, "name contains control characters")
+		}
+	}
+	if strings.HasPrefix(name, "/") || strings.HasPrefix(name, `\`) || filepath.VolumeName(name) != "" ||
+		(len(name) > 1 && name[1] == ':') {
+		return fail(ErrCodeNameAbsolute, "absolute paths are not allowed")
+	}
+	for _, elem := range strings.FieldsFunc(name, isSeparator) {
+		if elem == ".." {
+			return fail(ErrCodeNameTraversal, "parent directory references are not allowed")
+		}
+	}
+	if strings.IndexFunc(name, isSeparator) >= 0 {
+		return fail(ErrCodeNameSeparator, "directories are not allowed")
+	}
+	base := strings.ToLower(strings.TrimSuffix(name, filepath.Ext(name)))
+	if name == "." || name == ".." || reservedNames[strings.ToLower(name)] || reservedNames[base] {
+		return fail(ErrCodeNameReserved, "name is reserved")
+	}
+	if p.MaxLength > 0 && len(name) > p.MaxLength {
+		return fail(ErrCodeNameTooLong, fmt.Sprintf("name is longer than %d bytes", p.MaxLength))
+	}
+	if len(p.AllowedExtensions) > 0 && !p.allowedExtension(filepath.Ext(name)) {
+		return fail(ErrCodeNameExtension, fmt.Sprintf("extension must be one of %s", strings.Join(p.AllowedExtensions, ", ")))
+	}
+	return nil
+}
+
+// allowedExtension reports whether ext is one of the allowed extensions
+func (p NamePolicy) allowedExtension(ext string) bool {
+	if ext == "" {
+		return false
+	}
+	for _, allowed := range p.AllowedExtensions {
+		if strings.EqualFold(strings.TrimPrefix(ext, "."), strings.TrimPrefix(allowed, ".")) {
+			return true
+		}
+	}
+	return false
+}
+
+// isSeparator reports whether r separates path elements on any platform
+func isSeparator(r rune) bool {
+	return r == '/' || r == '\\'
+}
+
+// checkName validates name against the store policy and returns the name it is stored
+// under. When names are case insensitive an existing file differing by case only wins, files
+// are matched through the index so files added behind the server back are only matched by
+// their exact name until the next start.
+func (fs *FileStore) checkName(name string) (string, error) {
+	if err := fs.NamePolicy.Validate(name); err != nil {
+		return "", err
+	}
+	if fs.NamePolicy.Case != CaseInsensitive {
+		return name, nil
+	}
+	if _, err := fs.backend.Stat(name); err == nil {
+		return name, nil
+	}
+	if stored, ok := fs.index.FoldedName(name); ok {
+		return stored, nil
+	}
+	return name, nil
+}
+
+// lockName locks the writes of a file, names differing by case only share their lock when
+// names are case insensitive. It returns the name the file is stored under, as found once
+// the lock is held, and the unlock function.
+func (fs *FileStore) lockName(name string) (string, func()) {
+	if fs.NamePolicy.Case != CaseInsensitive {
+		return name, fs.fileLocks.lock(name)
+	}
+	unlock := fs.fileLocks.lock(strings.ToLower(name))
+	if _, err := fs.backend.Stat(name); err != nil {
+		if stored, ok := fs.index.FoldedName(name); ok {
+			name = stored
+		}
+	}
+	return name, unlock
+}
+
+// sameName reports whether two file names designate the same file under the case policy
+func (fs *FileStore) sameName(a, b string) bool {
+	if fs.NamePolicy.Case == CaseInsensitive {
+		return strings.EqualFold(a, b)
+	}
+	return a == b
+}
+
+// partFileName returns the file name of a multipart part as sent by the client.
+// multipart.Part.FileName strips directories, which would hide traversal attempts
+// instead of rejecting them.
+func partFileName(part *multipart.Part) string {
+	_, params, err := mime.ParseMediaType(part.Header.Get("Content-Disposition"))
+	if err != nil {
+		return part.FileName()
+	}
+	return params["filename"]
+}
+
+// nameStatus returns the HTTP status reporting an error returned by checkName
+func nameStatus(err error) int {
+	if _, ok := err.(*NameError); ok {
+		return http.StatusBadRequest
+	}
+	return http.StatusInternalServerError
+}
@@ -0,0 +1,131 @@
+package filestore
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNamePolicyValidate(t *testing.T) {
+	policy := NamePolicy{MaxLength: 12, AllowedExtensions: []string{"txt", ".log"}}
+	for name, code := range map[string]string{
+		"a.txt":         "",
+		"b.LOG":         "",
+		"":              ErrCodeNameEmpty,
+		"/etc/a.txt":    ErrCodeNameAbsolute,
+		`C:\a.txt`:      ErrCodeNameAbsolute,
+		"../../etc/x":   ErrCodeNameTraversal,
+		`..\a.txt`:      ErrCodeNameTraversal,
+		"dir/a.txt":     ErrCodeNameSeparator,
+		"a\n.txt":       ErrCodeNameControlChar,
+		"..":            ErrCodeNameTraversal,
+		".":             ErrCodeNameReserved,
+		".filestore":    ErrCodeNameReserved,
+		"NUL.txt":       ErrCodeNameReserved,
+		"very-long.txt": ErrCodeNameTooLong,
+		"a.exe":         ErrCodeNameExtension,
+		"README":        ErrCodeNameExtension,
+		"\xff.txt":      ErrCodeNameEncoding,
+	} {
+		err := policy.Validate(name)
+		if code == "" {
+			require.NoError(t, err, name)
+			continue
+		}
+		require.IsType(t, &NameError{}, err, name)
+		require.Equal(t, code, err.(*NameError).Code, name)
+	}
+}
+
+func TestRejectedNames(t *testing.T) {
+	fs := newTestStore(t)
+	defer os.RemoveAll(fs.StoreDir)
+
+	w := serve(fs.Add, multipartRequest(t, "POST", "/add", map[string]string{"../../etc/x": "escape"}))
+	require.Equal(t, http.StatusBadRequest, w.Code)
+	require.True(t, strings.HasPrefix(w.Body.String(), ErrCodeNameTraversal))
+	w = serve(fs.Update, multipartRequest(t, "POST", "/update", map[string]string{"/tmp/x": "escape"}))
+	require.Equal(t, http.StatusBadRequest, w.Code)
+	require.True(t, strings.HasPrefix(w.Body.String(), ErrCodeNameAbsolute))
+	w = serve(fs.Remove, httptest.NewRequest("POST", "/remove?file=../index.go", nil))
+	require.Equal(t, http.StatusBadRequest, w.Code)
+	w = serve(fs.Get, httptest.NewRequest("GET", "/get?file=.filestore", nil))
+	require.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestCaseInsensitiveNames(t *testing.T) {
+	fs := newTestStore(t)
+	defer os.RemoveAll(fs.StoreDir)
+	fs.NamePolicy.Case = CaseInsensitive
+
+	w := serve(fs.Add, multipartRequest(t, "POST", "/add", map[string]string{"Notes.txt": "content"}))
+	require.Equal(t, http.StatusOK, w.Code)
+	w = serve(fs.Add, multipartRequest(t, "POST", "/add", map[string]string{"NOTES.TXT": "other"}))
+	require.Equal(t, http.StatusConflict, w.Code)
+	w = serve(fs.Get, httptest.NewRequest("GET", "/get?file=notes.txt", nil))
+	require.Equal(t, "content", w.Body.String())
+	w = serve(fs.Update, multipartRequest(t, "POST", "/update", map[string]string{"notes.TXT": "updated"}))
+	require.Equal(t, http.StatusOK, w.Code)
+	w = serve(fs.List, httptest.NewRequest("GET", "/list", nil))
+	require.Equal(t, "Notes.txt\n", w.Body.String())
+}
+
+// listCountingBackend counts the listings of the store
+type listCountingBackend struct {
+	Backend
+	lists int32
+}
+
+func (b *listCountingBackend) List(dir string) ([]os.FileInfo, error) {
+	atomic.AddInt32(&b.lists, 1)
+	return b.Backend.List(dir)
+}
+
+func TestCaseInsensitiveConcurrentAdds(t *testing.T) {
+	fs := newTestStore(t)
+	defer os.RemoveAll(fs.StoreDir)
+	fs.NamePolicy.Case = CaseInsensitive
+	backend := &listCountingBackend{Backend: fs.backend}
+	fs.backend = backend
+
+	// names differing by case only are added once, without listing the store
+	names := []string{"a.txt", "A.txt", "a.TXT", "A.TXT", "a.Txt", "A.tXt"}
+	codes := make(chan int, len(names))
+	wg := &sync.WaitGroup{}
+	for _, name := range names {
+		wg.Add(1)
+		go func(name string) {
+			defer wg.Done()
+			codes <- serve(fs.Add, multipartRequest(t, "POST", "/add", map[string]string{name: name})).Code
+		}(name)
+	}
+	wg.Wait()
+	close(codes)
+	added := 0
+	for code := range codes {
+		if code == http.StatusOK {
+			added++
+		} else {
+			require.Equal(t, http.StatusConflict, code)
+		}
+	}
+	require.Equal(t, 1, added)
+	files, err := backend.Backend.List("")
+	require.NoError(t, err)
+	require.Len(t, files, 1)
+	require.Zero(t, atomic.LoadInt32(&backend.lists))
+
+	// the file is found by any case once removed and restored
+	w := serve(fs.Remove, httptest.NewRequest("POST", "/remove?file=A.TXT", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	w = serve(fs.Undelete, httptest.NewRequest("POST", "/trash/restore?file=a.txt", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	w = serve(fs.Get, httptest.NewRequest("GET", "/get?file=a.TxT", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+}
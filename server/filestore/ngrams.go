@@ -0,0 +1,218 @@
+package filestore
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	// minNgram and maxNgram bound the number of words of the n-grams
+	minNgram = 2
+	maxNgram = 5
+	// sentenceEnd holds the punctuation ending sentences, n-grams do not span sentences
+	sentenceEnd = ".!?;:"
+)
+
+// NgramStats is the body of the v2 n-gram statistics response, Errors lists the files that
+// could not be read, the n-grams covering the other files
+type NgramStats struct {
+	N      int         `json:"n"`
+	Count  int         `json:"count"`
+	Ngrams []wordFreq  `json:"ngrams"`
+	Errors []FileError `json:"errors,omitempty"`
+}
+
+// ngramQuery is a parsed n-gram statistics request
+type ngramQuery struct {
+	n     int
+	limit int
+	order string
+	a     *analyzer
+	scope *scope
+}
+
+// ngramParams parses the n, limit, order, analyzer and scope parameters of an n-gram
+// statistics request, n defaults to 2, limit to 10 and order to dsc
+func (fs *FileStore) ngramParams(r *http.Request) (*ngramQuery, error) {
+	q := &ngramQuery{n: minNgram, limit: 10, order: "dsc"}
+	if s := r.FormValue("n"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < minNgram || n > maxNgram {
+			return nil, fmt.Errorf("invalid n %q, expecting %d to %d", s, minNgram, maxNgram)
+		}
+		q.n = n
+	}
+	if s := r.FormValue("limit"); s != "" {
+		limit, err := strconv.Atoi(s)
+		if err != nil || limit < 0 {
+			return nil, fmt.Errorf("invalid limit %q", s)
+		}
+		q.limit = limit
+	}
+	switch order := r.FormValue("order"); order {
+	case "":
+	case "dsc", "asc":
+		q.order = order
+	default:
+		return nil, fmt.Errorf("invalid order %q, expecting asc or dsc", order)
+	}
+	var err error
+	if q.a, err = fs.analyzerParam(r); err != nil {
+		return nil, err
+	}
+	if q.scope, err = fs.scopeParam(r); err != nil {
+		return nil, err
+	}
+	return q, nil
+}
+
+// FreqNgrams returns the most frequent sequences of n words, n being 2 to 5, in the files
+// selected by the file, glob and selector parameters or in the whole store. Words are produced by the analyzer
+// named by the analyzer parameter, n-grams stop at stopwords and at the end of sentences.
+// Files that cannot be read are reported in ScanErrorHeader headers, the request fails
+// when no file could be read.
+func (fs *FileStore) FreqNgrams(w http.ResponseWriter, r *http.Request) {
+	q, err := fs.ngramParams(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	names, err := fs.scopeFiles(q.scope)
+	if err != nil {
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+	fs.Logger.Infof("Computing most frequent %d-grams in %d files", q.n, len(names))
+	ngrams, _, errs := ngramsInDir(fs.backend, names, q.n, q.a, fs.ScanWorkers)
+	if len(errs) > 0 && len(errs) == len(names) {
+		http.Error(w, (&ScanError{Files: errs}).Error(), http.StatusInternalServerError)
+		return
+	}
+	setScanErrors(w, errs)
+	for _, nf := range topWords(ngrams, q.limit, q.order) {
+		if _, err := io.WriteString(w, fmt.Sprintf("%3d %s\n", nf.Count, nf.Word)); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+}
+
+// ngramStats writes the number of n-grams in the store and the most frequent ones, it
+// takes the parameters of FreqNgrams
+func (req *v2Request) ngramStats() {
+	q, err := req.fs.ngramParams(req.r)
+	if err != nil {
+		req.fail(http.StatusBadRequest, ErrCodeInvalidRequest, err)
+		return
+	}
+	names, err := req.fs.scopeFiles(q.scope)
+	if err != nil {
+		req.failWith(err)
+		return
+	}
+	ngrams, count, errs := ngramsInDir(req.fs.backend, names, q.n, q.a, req.fs.ScanWorkers)
+	if len(errs) > 0 && len(errs) == len(names) {
+		req.failWith(&ScanError{Files: errs})
+		return
+	}
+	req.writeJSON(http.StatusOK, NgramStats{
+		N:      q.n,
+		Count:  count,
+		Ngrams: topWords(ngrams, q.limit, q.order),
+		Errors: errs,
+	})
+}
+
+// ngramsInDir scans the given files of the store on a pool of workers goroutines and returns
+// the occurrences of their n-grams and their total, along with the errors of the files that
+// could not be read
+func ngramsInDir(backend Backend, names []string, n int, a *analyzer, workers int) (map[string]int, int, []FileError) {
+	ngrams := make(map[string]int)
+	count := 0
+	var errs []FileError
+	wg := &sync.WaitGroup{}
+	queue := fileQueue(names)
+	resultChan := make(chan ngramResult)
+	for i := 0; i < poolSize(workers, len(names)); i++ {
+		wg.Add(1)
+		go ngramsInFile(backend, queue, n, a, resultChan, wg)
+	}
+	go func() {
+		wg.Wait()
+		close(resultChan)
+	}()
+	for r := range resultChan {
+		if r.err != nil {
+			errs = append(errs, FileError{Name: r.name, Error: r.err.Error()})
+			continue
+		}
+		for ngram, occurrences := range r.ngrams {
+			ngrams[ngram] += occurrences
+			count += occurrences
+		}
+	}
+	sortFileErrors(errs)
+	return ngrams, count, errs
+}
+
+// ngramResult holds the n-grams of a file scanned by ngramsInFile, or the error scanning it
+type ngramResult struct {
+	name   string
+	ngrams map[string]int
+	err    error
+}
+
+// ngramsInFile scans the files taken from queue and counts their n-grams
+func ngramsInFile(backend Backend, queue <-chan string, n int, a *analyzer, resultChan chan<- ngramResult, wg *sync.WaitGroup) {
+	defer wg.Done()
+	for name := range queue {
+		ngrams, err := scanNgrams(backend, name, n, a)
+		resultChan <- ngramResult{name: name, ngrams: ngrams, err: err}
+	}
+}
+
+// scanNgrams returns the occurrences of the n-grams of a file, the words of the n-grams
+// being joined by a space. Stopwords and the end of sentences break n-grams.
+func scanNgrams(backend Backend, name string, n int, a *analyzer) (map[string]int, error) {
+	file, err := backend.Get(name)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	scanner := newWordScanner(file)
+	ngrams := make(map[string]int)
+	window := make([]string, 0, n)
+	for scanner.Scan() {
+		word := scanner.Text()
+		for _, form := range a.surfaceForms(word) {
+			if a.stopwords[form] {
+				window = window[:0]
+				continue
+			}
+			token := a.stemmed(form)
+			if len(window) == n {
+				copy(window, window[1:])
+				window = window[:n-1]
+			}
+			window = append(window, token)
+			if len(window) == n {
+				ngrams[strings.Join(window, " ")]++
+			}
+		}
+		if endsSentence(word) {
+			window = window[:0]
+		}
+	}
+	return ngrams, scanner.Err()
+}
+
+// endsSentence reports whether word ends a sentence, closing quotes and brackets aside
+func endsSentence(word string) bool {
+	word = strings.TrimRight(word, "\"')]}»”’")
+	return word != "" && strings.ContainsAny(word[len(word)-1:], sentenceEnd)
+}
@@ -0,0 +1,46 @@
+package filestore
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFreqNgrams(t *testing.T) {
+	fs := newTestStore(t)
+	defer os.RemoveAll(fs.StoreDir)
+	w := serve(fs.Add, multipartRequest(t, "POST", "/add", map[string]string{
+		"a.txt": "The build failed. The build failed again, then the build passed.",
+		"b.txt": "Build failed on the main branch",
+		"c.txt": "Main branch builds fine",
+	}))
+	require.Equal(t, http.StatusOK, w.Code)
+
+	w = serve(fs.FreqNgrams, httptest.NewRequest("GET", "/freqngrams?limit=2", nil))
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	require.Equal(t, "  3 build failed\n  3 the build\n", w.Body.String())
+	// stopwords and the end of sentences break n-grams
+	w = serve(fs.FreqNgrams, httptest.NewRequest("GET", "/freqngrams?n=3&limit=3&stopwords=en", nil))
+	require.Equal(t, "  1 branch builds fine\n  1 main branch builds\n", w.Body.String())
+	w = serve(fs.FreqNgrams, httptest.NewRequest("GET", "/freqngrams?n=3&limit=1&file=b.txt&file=c.txt", nil))
+	require.Equal(t, "  1 branch builds fine\n", w.Body.String())
+	w = serve(fs.FreqNgrams, httptest.NewRequest("GET", "/freqngrams?n=2&limit=1&file=c.txt&stem=en", nil))
+	require.Equal(t, "  1 branch build\n", w.Body.String())
+
+	for _, target := range []string{"/freqngrams?n=1", "/freqngrams?n=6", "/freqngrams?order=up", "/freqngrams?analyzer=x"} {
+		w = serve(fs.FreqNgrams, httptest.NewRequest("GET", target, nil))
+		require.Equal(t, http.StatusBadRequest, w.Code, target)
+	}
+	w = serve(fs.FreqNgrams, httptest.NewRequest("GET", "/freqngrams?file=missing.txt", nil))
+	require.Equal(t, http.StatusNotFound, w.Code)
+
+	w = serve(fs.V2, httptest.NewRequest("GET", "/v2/stats/ngrams?n=2&limit=1&file=b.txt", nil))
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	stats := &NgramStats{}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), stats))
+	require.Equal(t, &NgramStats{N: 2, Count: 5, Ngrams: []wordFreq{{Word: "build failed", Count: 1}}}, stats)
+}
@@ -0,0 +1,67 @@
+package filestore
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// ScanErrorHeader is the response header reporting, once per file, the files a v1 statistics
+// request could not read, the results covering the other files
+const ScanErrorHeader = "X-Filestore-Scan-Error"
+
+// FileError reports a file a scan could not read
+type FileError struct {
+	Name  string `json:"name"`
+	Error string `json:"error"`
+}
+
+// ScanError is returned by scans which could not read some files, the other files being scanned
+type ScanError struct {
+	Files []FileError
+}
+
+// Error lists the first files that could not be read
+func (e *ScanError) Error() string {
+	const shown = 3
+	var files []string
+	for i, f := range e.Files {
+		if i == shown {
+			files = append(files, fmt.Sprintf("and %d more", len(e.Files)-shown))
+			break
+		}
+		files = append(files, f.Name+": "+f.Error)
+	}
+	return fmt.Sprintf("could not scan %d files: %s", len(e.Files), strings.Join(files, "; "))
+}
+
+// fileQueue returns a closed channel holding names, for workers to take files from
+func fileQueue(names []string) <-chan string {
+	queue := make(chan string, len(names))
+	for _, name := range names {
+		queue <- name
+	}
+	close(queue)
+	return queue
+}
+
+// poolSize returns the number of workers scanning n files, workers at most
+func poolSize(workers, n int) int {
+	if n < workers {
+		return n
+	}
+	return workers
+}
+
+// sortFileErrors sorts errors by file name
+func sortFileErrors(errs []FileError) {
+	sort.Slice(errs, func(i, j int) bool { return errs[i].Name < errs[j].Name })
+}
+
+// setScanErrors reports the files a scan could not read in the headers of w
+func setScanErrors(w http.ResponseWriter, errs []FileError) {
+	for _, f := range errs {
+		w.Header().Add(ScanErrorHeader, f.Name+": "+f.Error)
+	}
+}
@@ -0,0 +1,147 @@
+package filestore
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// poolBackend records how many files are read at once and fails reading some of them
+type poolBackend struct {
+	Backend
+	mu        sync.Mutex
+	reading   int
+	maxActive int
+	broken    map[string]bool
+}
+
+func (b *poolBackend) Get(name string) (Object, error) {
+	b.mu.Lock()
+	b.reading++
+	if b.reading > b.maxActive {
+		b.maxActive = b.reading
+	}
+	b.mu.Unlock()
+	defer func() {
+		b.mu.Lock()
+		b.reading--
+		b.mu.Unlock()
+	}()
+	time.Sleep(time.Millisecond)
+	if b.broken[name] {
+		return nil, errors.New("unreadable")
+	}
+	return b.Backend.Get(name)
+}
+
+func TestScanPool(t *testing.T) {
+	backend := &poolBackend{Backend: NewMemoryBackend(), broken: map[string]bool{"f3.txt": true}}
+	var names []string
+	for i := 0; i < 20; i++ {
+		name := fmt.Sprintf("f%d.txt", i)
+		require.NoError(t, backend.Put(name, strings.NewReader("one two")))
+		names = append(names, name)
+	}
+	names = append(names, "deleted.txt")
+
+	entries, errs := searchInDir(backend, names, 3)
+	require.Len(t, entries, 19)
+	require.Equal(t, []FileError{
+		{Name: "deleted.txt", Error: "file does not exist"},
+		{Name: "f3.txt", Error: "unreadable"},
+	}, scrubErrors(errs))
+	require.True(t, backend.maxActive <= 3, "%d files read at once", backend.maxActive)
+
+	ngrams, count, errs := ngramsInDir(backend, names, 2, &analyzer{}, 2)
+	require.Equal(t, map[string]int{"one two": 19}, ngrams)
+	require.Equal(t, 19, count)
+	require.Len(t, errs, 2)
+
+	ix := newWordIndex(backend)
+	changed, err := ix.Sync(4)
+	require.True(t, changed)
+	scanErr, ok := err.(*ScanError)
+	require.True(t, ok, "%v", err)
+	require.Equal(t, []FileError{{Name: "f3.txt", Error: "unreadable"}}, scanErr.Files)
+	require.Equal(t, 38, ix.Count())
+}
+
+// scrubErrors keeps the reason of errors reported by the backends, dropping paths
+func scrubErrors(errs []FileError) []FileError {
+	for i := range errs {
+		if strings.Contains(errs[i].Error, os.ErrNotExist.Error()) {
+			errs[i].Error = os.ErrNotExist.Error()
+		}
+	}
+	return errs
+}
+
+func TestNgramScanErrors(t *testing.T) {
+	fs := newTestStore(t)
+	defer os.RemoveAll(fs.StoreDir)
+	w := serve(fs.Add, multipartRequest(t, "POST", "/add", map[string]string{"a.txt": "build failed", "b.txt": "build passed"}))
+	require.Equal(t, http.StatusOK, w.Code)
+	fs.backend = &poolBackend{Backend: fs.backend, broken: map[string]bool{"b.txt": true}}
+
+	w = serve(fs.FreqNgrams, httptest.NewRequest("GET", "/freqngrams", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, "  1 build failed\n", w.Body.String())
+	require.Equal(t, []string{"b.txt: unreadable"}, w.Header()[ScanErrorHeader])
+
+	w = serve(fs.V2, httptest.NewRequest("GET", "/v2/stats/ngrams", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Contains(t, w.Body.String(), `"errors":[{"name":"b.txt","error":"unreadable"}]`)
+
+	// the request fails when no file could be read
+	w = serve(fs.FreqNgrams, httptest.NewRequest("GET", "/freqngrams?file=b.txt", nil))
+	require.Equal(t, http.StatusInternalServerError, w.Code)
+	require.Contains(t, w.Body.String(), "could not scan 1 files: b.txt: unreadable")
+}
+
+func TestWordStatsScanErrors(t *testing.T) {
+	fs := newTestStore(t)
+	defer os.RemoveAll(fs.StoreDir)
+	w := serve(fs.Add, multipartRequest(t, "POST", "/add", map[string]string{"a.txt": "build failed"}))
+	require.Equal(t, http.StatusOK, w.Code)
+	fs.backend = &poolBackend{Backend: fs.backend, broken: map[string]bool{"b.txt": true}}
+
+	// the write is kept but its file cannot be indexed
+	w = serve(fs.Add, multipartRequest(t, "POST", "/add", map[string]string{"b.txt": "build passed"}))
+	require.Equal(t, http.StatusOK, w.Code)
+	w = serve(fs.CountWords, httptest.NewRequest("GET", "/countwords", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, "  2\n", w.Body.String())
+	require.Equal(t, []string{"b.txt: unreadable"}, w.Header()[ScanErrorHeader])
+	w = serve(fs.FreqWords, httptest.NewRequest("GET", "/freqwords?limit=1&order=dsc&file=a.txt&file=b.txt", nil))
+	require.Equal(t, []string{"b.txt: unreadable"}, w.Header()[ScanErrorHeader])
+	w = serve(fs.FreqWords, httptest.NewRequest("GET", "/freqwords?limit=1&order=dsc&file=a.txt", nil))
+	require.Empty(t, w.Header()[ScanErrorHeader])
+
+	w = serve(fs.V2, httptest.NewRequest("GET", "/v2/stats/words", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Contains(t, w.Body.String(), `"errors":[{"name":"b.txt","error":"unreadable"}]`)
+
+	// the request fails when no file was scanned
+	w = serve(fs.CountWords, httptest.NewRequest("GET", "/countwords?file=b.txt", nil))
+	require.Equal(t, http.StatusInternalServerError, w.Code)
+	require.Contains(t, w.Body.String(), "could not scan 1 files: b.txt: unreadable")
+
+	// errors found on startup are kept until the file is indexed or removed
+	ix := newWordIndex(fs.backend)
+	_, err := ix.Sync(2)
+	require.Error(t, err)
+	errs, none := ix.ScanErrors(nil)
+	require.Equal(t, []FileError{{Name: "b.txt", Error: "unreadable"}}, errs)
+	require.False(t, none)
+	ix.Remove("b.txt")
+	errs, _ = ix.ScanErrors(nil)
+	require.Empty(t, errs)
+}
@@ -0,0 +1,223 @@
+package filestore
+
+import (
+	"fmt"
+	"net/http"
+	"path"
+	"strings"
+)
+
+// scope restricts statistics to some files of the store
+type scope struct {
+	// names are the files named by the request, all the files of the store when empty
+	names []string
+	// glob and selector filter the files by name and by labels
+	glob     string
+	selector labelSelector
+}
+
+// labelRequirement is a term of a label selector: the label key must be set to value, or
+// to another value when negated. An empty value only asks for the key to be set, or unset
+// when negated.
+type labelRequirement struct {
+	key, value string
+	negated    bool
+}
+
+// labelSelector selects files by their labels, a file must meet all the requirements
+type labelSelector []labelRequirement
+
+// parseSelector parses a comma separated list of key=value, key!=value, key and !key terms
+func parseSelector(s string) (labelSelector, error) {
+	var selector labelSelector
+	for _, term := range strings.Split(s, ",") {
+		term = strings.TrimSpace(term)
+		var req labelRequirement
+		switch {
+		case strings.Contains(term, "!="):
+			kv := strings.SplitN(term, "!=", 2)
+			req = labelRequirement{key: kv[0], value: kv[1], negated: true}
+		case strings.Contains(term, "="):
+			kv := strings.SplitN(term, "=", 2)
+			req = labelRequirement{key: kv[0], value: kv[1]}
+		case strings.HasPrefix(term, "!"):
+			req = labelRequirement{key: term[1:], negated: true}
+		default:
+			req = labelRequirement{key: term}
+		}
+		if req.key == "" {
+			return nil, fmt.Errorf("invalid selector term %q, expecting key=value, key!=value, key or !key", term)
+		}
+		selector = append(selector, req)
+	}
+	return selector, nil
+}
+
+// matches reports whether labels meet the requirements of the selector
+func (s labelSelector) matches(labels map[string]string) bool {
+	for _, req := range s {
+		value, ok := labels[req.key]
+		switch {
+		case req.value == "" && ok == req.negated:
+			return false
+		case req.value != "" && (ok && value == req.value) == req.negated:
+			return false
+		}
+	}
+	return true
+}
+
+// scopeParam returns the files of the store a statistics request is about: the files named
+// by file parameters, the files matching the glob parameter and the files whose labels
+// match the selector parameter. Filters apply to the named files when there are some, to
+// the whole store otherwise. A nil scope stands for the whole store.
+func (fs *FileStore) scopeParam(r *http.Request) (*scope, error) {
+	if err := r.ParseForm(); err != nil {
+		return nil, err
+	}
+	sc := &scope{names: r.Form["file"], glob: r.Form.Get("glob")}
+	if _, err := path.Match(sc.glob, ""); err != nil {
+		return nil, fmt.Errorf("invalid glob %q", sc.glob)
+	}
+	if s := r.Form.Get("selector"); s != "" {
+		var err error
+		if sc.selector, err = parseSelector(s); err != nil {
+			return nil, err
+		}
+	}
+	if len(sc.names) == 0 && sc.glob == "" && sc.selector == nil {
+		return nil, nil
+	}
+	return sc, nil
+}
+
+// scopeFiles returns the names of the files of sc in the store, named files must exist
+func (fs *FileStore) scopeFiles(sc *scope) ([]string, error) {
+	var names []string
+	if sc == nil || len(sc.names) == 0 {
+		files, err := fs.backend.List("")
+		if err != nil {
+			return nil, err
+		}
+		for _, fi := range files {
+			names = append(names, fi.Name())
+		}
+	} else {
+		seen := make(map[string]bool, len(sc.names))
+		for _, name := range sc.names {
+			name, err := fs.checkName(name)
+			if err != nil {
+				return nil, err
+			}
+			if _, err := fs.backend.Stat(name); err != nil {
+				return nil, err
+			}
+			if !seen[name] {
+				seen[name] = true
+				names = append(names, name)
+			}
+		}
+	}
+	if sc == nil {
+		return names, nil
+	}
+	kept := names[:0]
+	for _, name := range names {
+		if ok, _ := path.Match(sc.glob, name); sc.glob != "" && !ok {
+			continue
+		}
+		if sc.selector != nil {
+			meta, err := fs.metadata(name)
+			if err != nil {
+				return nil, err
+			}
+			if !sc.selector.matches(meta.Labels) {
+				continue
+			}
+		}
+		kept = append(kept, name)
+	}
+	return kept, nil
+}
+
+// FileWordStats are the word statistics of a single file of the store
+type FileWordStats struct {
+	Name  string     `json:"name"`
+	Count int        `json:"count"`
+	Words []wordFreq `json:"words"`
+	textCounts
+}
+
+// wordStatsQuery is a parsed word statistics request
+type wordStatsQuery struct {
+	a       *analyzer
+	scope   *scope
+	perFile bool
+}
+
+// wordStatsParams parses the analyzer, scope and per_file parameters of a word statistics request
+func (fs *FileStore) wordStatsParams(r *http.Request) (*wordStatsQuery, error) {
+	q := &wordStatsQuery{}
+	var err error
+	if q.a, err = fs.analyzerParam(r); err != nil {
+		return nil, err
+	}
+	if q.scope, err = fs.scopeParam(r); err != nil {
+		return nil, err
+	}
+	switch perFile := r.FormValue("per_file"); perFile {
+	case "", "false":
+	case "true":
+		q.perFile = true
+	default:
+		return nil, fmt.Errorf("invalid per_file %q, expecting true or false", perFile)
+	}
+	return q, nil
+}
+
+// analyze returns the words of the files of the query as produced by its analyzer, their
+// number, the most common surface forms of the stems and the text counts of the files,
+// along with the statistics of every file when the query asks for a breakdown. Files the index
+// could not scan are reported in Errors, a ScanError is returned when none of them was scanned.
+func (fs *FileStore) analyze(q *wordStatsQuery, limit int, order string) (*WordStats, error) {
+	var names []string
+	if q.scope != nil || q.perFile {
+		var err error
+		if names, err = fs.scopeFiles(q.scope); err != nil {
+			return nil, err
+		}
+	}
+	stats := &WordStats{}
+	var words map[string]int
+	var forms map[string]string
+	var none bool
+	if q.scope == nil {
+		words, stats.Count, forms = fs.index.Analyze(q.a)
+		stats.Errors, none = fs.index.ScanErrors(nil)
+	} else {
+		words, stats.Count, forms = fs.index.AnalyzeFiles(q.a, names)
+		if len(names) > 0 {
+			stats.Errors, none = fs.index.ScanErrors(names)
+		}
+	}
+	if none {
+		return nil, &ScanError{Files: stats.Errors}
+	}
+	stats.Words = withForms(topWords(words, limit, order), forms)
+	// names is only nil for the whole store, or an empty one
+	stats.textCounts = fs.index.TextCounts(names)
+	if !q.perFile {
+		return stats, nil
+	}
+	stats.Files = []FileWordStats{}
+	for _, name := range names {
+		words, count, forms := fs.index.AnalyzeFiles(q.a, []string{name})
+		stats.Files = append(stats.Files, FileWordStats{
+			Name:       name,
+			Count:      count,
+			Words:      withForms(topWords(words, limit, order), forms),
+			textCounts: fs.index.TextCounts([]string{name}),
+		})
+	}
+	return stats, nil
+}
@@ -0,0 +1,94 @@
+package filestore
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLabelSelector(t *testing.T) {
+	labels := map[string]string{"team": "ops", "env": "prod"}
+	for s, want := range map[string]bool{
+		"team=ops":          true,
+		"team=dev":          false,
+		"team!=dev":         true,
+		"team=ops,env=prod": true,
+		"team=ops,env=dev":  false,
+		"env":               true,
+		"!env":              false,
+		"!owner":            true,
+		"owner!=me":         true,
+	} {
+		selector, err := parseSelector(s)
+		require.NoError(t, err, s)
+		require.Equal(t, want, selector.matches(labels), s)
+	}
+	for _, s := range []string{"=ops", "!", "a,,b"} {
+		_, err := parseSelector(s)
+		require.Error(t, err, s)
+	}
+}
+
+func TestScopedWordStats(t *testing.T) {
+	fs := newTestStore(t)
+	defer os.RemoveAll(fs.StoreDir)
+	w := serve(fs.Add, multipartRequest(t, "POST", "/add?label=team=ops", map[string]string{
+		"a.log": "disk full disk",
+		"b.log": "disk ok",
+	}))
+	require.Equal(t, http.StatusOK, w.Code)
+	w = serve(fs.Add, multipartRequest(t, "POST", "/add", map[string]string{"c.txt": "cpu ok ok ok"}))
+	require.Equal(t, http.StatusOK, w.Code)
+
+	for target, want := range map[string]string{
+		"/countwords":                                 "  9\n",
+		"/countwords?file=a.log":                      "  3\n",
+		"/countwords?file=a.log&file=c.txt":           "  7\n",
+		"/countwords?glob=*.log":                      "  5\n",
+		"/countwords?selector=team=ops&file=b.log":    "  2\n",
+		"/countwords?selector=!team":                  "  4\n",
+		"/countwords?glob=*.log&per_file=true":        "  3 a.log\n  2 b.log\n  5 total\n",
+		"/countwords?file=c.txt&per_file=true":        "  4 c.txt\n",
+		"/freqwords?limit=1&order=dsc&glob=*.log":     "  3 disk\n",
+		"/freqwords?limit=1&order=dsc&selector=!team": "  3 ok\n",
+		"/freqwords?limit=1&order=dsc&per_file=true":  "==> a.log <==\n  2 disk\n\n==> b.log <==\n  1 disk\n\n==> c.txt <==\n  3 ok\n",
+	} {
+		handler := fs.CountWords
+		if target[1] == 'f' {
+			handler = fs.FreqWords
+		}
+		w = serve(handler, httptest.NewRequest("GET", target, nil))
+		require.Equal(t, want, w.Body.String(), target)
+	}
+	for target, status := range map[string]int{
+		"/countwords?file=missing.txt": http.StatusNotFound,
+		"/countwords?glob=[":           http.StatusBadRequest,
+		"/countwords?selector==x":      http.StatusBadRequest,
+		"/countwords?per_file=maybe":   http.StatusBadRequest,
+	} {
+		w = serve(fs.CountWords, httptest.NewRequest("GET", target, nil))
+		require.Equal(t, status, w.Code, target)
+	}
+
+	w = serve(fs.V2, httptest.NewRequest("GET", "/v2/stats/words?limit=1&selector=team=ops&per_file=true", nil))
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	stats := &WordStats{}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), stats))
+	require.Equal(t, &WordStats{
+		Count: 5,
+		Words: []wordFreq{{Word: "disk", Count: 3}},
+		Files: []FileWordStats{
+			{Name: "a.log", Count: 3, Words: []wordFreq{{Word: "disk", Count: 2}}, textCounts: textCounts{Bytes: 14, Chars: 14, MaxLineLength: 14}},
+			{Name: "b.log", Count: 2, Words: []wordFreq{{Word: "disk", Count: 1}}, textCounts: textCounts{Bytes: 7, Chars: 7, MaxLineLength: 7}},
+		},
+		textCounts: textCounts{Bytes: 21, Chars: 21, MaxLineLength: 14},
+	}, stats)
+
+	// n-grams take the same scope
+	w = serve(fs.FreqNgrams, httptest.NewRequest("GET", "/freqngrams?limit=1&selector=!team", nil))
+	require.Equal(t, "  2 ok ok\n", w.Body.String())
+}
@@ -0,0 +1,491 @@
+package filestore
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	// bm25K1 and bm25B are the term frequency saturation and length normalization of BM25
+	bm25K1 = 1.2
+	bm25B  = 0.75
+	// snippetBefore is the number of words shown before the first hit of a snippet
+	snippetBefore = 5
+	// snippetWords is the number of words of a snippet
+	snippetWords = 20
+)
+
+// SearchHit is a file matching a search query
+type SearchHit struct {
+	Name  string  `json:"name"`
+	Score float64 `json:"score"`
+	// Snippet is an excerpt of the file around its first hit, words being separated by
+	// single spaces, Highlights are the byte ranges of the matching words in it
+	Snippet    string   `json:"snippet"`
+	Highlights [][2]int `json:"highlights"`
+}
+
+// SearchResults is the body of the search response
+type SearchResults struct {
+	Total int         `json:"total"`
+	Hits  []SearchHit `json:"hits"`
+}
+
+// queryAtom is a word, a phrase of several words or a word prefix of a query
+type queryAtom struct {
+	words  []string
+	prefix bool
+}
+
+// query is a parsed search query: files match every group of must, matching one atom of a
+// group being enough, and no atom of not
+type query struct {
+	must [][]queryAtom
+	not  []queryAtom
+}
+
+// parseQuery parses a search query. Words are separated by spaces and must all be found,
+// OR between two words makes either enough, NOT or a leading - excludes the files containing
+// a word, double quotes match a phrase and a trailing * matches the words with a prefix.
+// Words are split and folded by the standard analyzer, like the words they are matched
+// with, so a word made of several of them matches as a phrase.
+func parseQuery(s string) (*query, error) {
+	lexemes, err := queryLexemes(s)
+	if err != nil {
+		return nil, err
+	}
+	q := &query{}
+	or, not := false, false
+	for _, lx := range lexemes {
+		if !lx.quoted {
+			switch lx.text {
+			case "AND":
+				continue
+			case "OR":
+				if len(q.must) == 0 || or || not {
+					return nil, fmt.Errorf("OR must be between two words")
+				}
+				or = true
+				continue
+			case "NOT":
+				if not {
+					return nil, fmt.Errorf("NOT must be followed by a word")
+				}
+				not = true
+				continue
+			}
+		}
+		atom := queryAtom{words: strings.Fields(lx.text)}
+		if !lx.quoted {
+			if strings.HasPrefix(lx.text, "-") && len(lx.text) > 1 {
+				atom.words[0] = lx.text[1:]
+				not = true
+			}
+			if w := atom.words[0]; strings.HasSuffix(w, "*") && len(w) > 1 {
+				atom.words[0], atom.prefix = strings.TrimSuffix(w, "*"), true
+			}
+		}
+		if len(atom.words) == 0 {
+			return nil, fmt.Errorf("empty phrase")
+		}
+		if atom.words, err = queryTerms(atom.words); err != nil {
+			return nil, err
+		}
+		if atom.prefix && len(atom.words) > 1 {
+			return nil, fmt.Errorf("prefix %s is not a single word", lx.text)
+		}
+		switch {
+		case not && or:
+			return nil, fmt.Errorf("excluded words cannot be part of OR")
+		case not:
+			q.not = append(q.not, atom)
+		case or:
+			q.must[len(q.must)-1] = append(q.must[len(q.must)-1], atom)
+		default:
+			q.must = append(q.must, []queryAtom{atom})
+		}
+		or, not = false, false
+	}
+	if or || not {
+		return nil, fmt.Errorf("query ends with an operator")
+	}
+	if len(q.must) == 0 {
+		return nil, fmt.Errorf("query has no word to look for")
+	}
+	return q, nil
+}
+
+// queryTerms returns the words of a query atom as produced by the standard analyzer
+func queryTerms(words []string) ([]string, error) {
+	a, _ := newAnalyzer(AnalyzerStandard)
+	var terms []string
+	for _, word := range words {
+		terms = append(terms, a.surfaceForms(word)...)
+	}
+	if len(terms) == 0 {
+		return nil, fmt.Errorf("no word to look for in %q", strings.Join(words, " "))
+	}
+	return terms, nil
+}
+
+// queryLexeme is a word or a quoted phrase of a query
+type queryLexeme struct {
+	text   string
+	quoted bool
+}
+
+// queryLexemes splits a query in words and quoted phrases
+func queryLexemes(s string) ([]queryLexeme, error) {
+	var lexemes []queryLexeme
+	for {
+		s = strings.TrimLeft(s, " \t\r\n")
+		if s == "" {
+			return lexemes, nil
+		}
+		if s[0] == '"' {
+			end := strings.IndexByte(s[1:], '"')
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated phrase %s", s)
+			}
+			lexemes = append(lexemes, queryLexeme{text: s[1 : end+1], quoted: true})
+			s = s[end+2:]
+			continue
+		}
+		end := strings.IndexAny(s, " \t\r\n\"")
+		if end < 0 {
+			end = len(s)
+		}
+		lexemes = append(lexemes, queryLexeme{text: s[:end]})
+		s = s[end:]
+	}
+}
+
+// searchMatch is a file matching a query with the positions of the matching words
+type searchMatch struct {
+	name  string
+	score float64
+	hits  []int
+}
+
+// matches is the score and hit positions of the files matching part of a query
+type matches map[string]*searchMatch
+
+// merge adds the score and hits of other to the files of m, adding missing files when union is set
+func (m matches) merge(other matches, union bool) {
+	for name, o := range other {
+		match, ok := m[name]
+		if !ok {
+			if !union {
+				continue
+			}
+			match = &searchMatch{name: name}
+			m[name] = match
+		}
+		match.score += o.score
+		match.hits = append(match.hits, o.hits...)
+	}
+}
+
+// Search returns the files matching q ranked by their BM25 score, and their number
+func (ix *wordIndex) Search(q *query, limit int) ([]*searchMatch, int) {
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+	var result matches
+	for _, group := range q.must {
+		found := matches{}
+		for _, atom := range group {
+			found.merge(ix.match(atom), true)
+		}
+		if result == nil {
+			result = found
+			continue
+		}
+		for name := range result {
+			if _, ok := found[name]; !ok {
+				delete(result, name)
+			}
+		}
+		result.merge(found, false)
+	}
+	for _, atom := range q.not {
+		for name := range ix.match(atom) {
+			delete(result, name)
+		}
+	}
+
+	ranked := make([]*searchMatch, 0, len(result))
+	for _, match := range result {
+		sort.Ints(match.hits)
+		ranked = append(ranked, match)
+	}
+	sort.Slice(ranked, func(i, j int) bool {
+		if ranked[i].score == ranked[j].score {
+			return ranked[i].name < ranked[j].name
+		}
+		return ranked[i].score > ranked[j].score
+	})
+	total := len(ranked)
+	if limit < len(ranked) {
+		ranked = ranked[:limit]
+	}
+	return ranked, total
+}
+
+// fileTerms are the words of a file as produced by the standard analyzer
+type fileTerms struct {
+	// positions lists the positions of every word among the analyzed words of the file
+	positions map[string][]int
+	// words maps the positions of the analyzed words to those of the indexed words they
+	// come from, which are highlighted in snippets
+	words []int
+}
+
+// wordsAt returns the positions of the indexed words the analyzed words at positions come from
+func (terms *fileTerms) wordsAt(positions []int) []int {
+	words := make([]int, len(positions))
+	for i, pos := range positions {
+		words[i] = terms.words[pos]
+	}
+	return words
+}
+
+// searchTerms returns the words searched by queries mapped to the indexed words they come
+// from, the caller holds the lock
+func (ix *wordIndex) searchTerms() map[string][]string {
+	ix.analyzedMu.Lock()
+	defer ix.analyzedMu.Unlock()
+	if ix.terms != nil {
+		return ix.terms
+	}
+	a, _ := newAnalyzer(AnalyzerStandard)
+	ix.terms = make(map[string][]string)
+	for word := range ix.docs {
+		for _, term := range a.surfaceForms(word) {
+			// a word made of the same term several times is listed once
+			if words := ix.terms[term]; len(words) == 0 || words[len(words)-1] != word {
+				ix.terms[term] = append(words, word)
+			}
+		}
+	}
+	return ix.terms
+}
+
+// termDocs returns the files containing term, the caller holds the lock
+func (ix *wordIndex) termDocs(term string) map[string]struct{} {
+	words := ix.searchTerms()[term]
+	if len(words) == 1 {
+		return ix.docs[words[0]]
+	}
+	docs := make(map[string]struct{})
+	for _, word := range words {
+		for name := range ix.docs[word] {
+			docs[name] = struct{}{}
+		}
+	}
+	return docs
+}
+
+// termsOf returns the analyzed words of an indexed file, the caller holds the lock
+func (ix *wordIndex) termsOf(name string) *fileTerms {
+	ix.analyzedMu.Lock()
+	defer ix.analyzedMu.Unlock()
+	if cached, ok := ix.fileTerms[name]; ok {
+		return cached
+	}
+	entry := ix.files[name]
+	words := make([]string, entry.Count)
+	for word, positions := range entry.Positions {
+		for _, pos := range positions {
+			if pos < len(words) {
+				words[pos] = word
+			}
+		}
+	}
+	a, _ := newAnalyzer(AnalyzerStandard)
+	terms := &fileTerms{positions: make(map[string][]int)}
+	for pos, word := range words {
+		for _, term := range a.surfaceForms(word) {
+			terms.positions[term] = append(terms.positions[term], len(terms.words))
+			terms.words = append(terms.words, pos)
+		}
+	}
+	if ix.fileTerms == nil {
+		ix.fileTerms = make(map[string]*fileTerms)
+	}
+	ix.fileTerms[name] = terms
+	return terms
+}
+
+// match returns the files containing atom, the caller holds the lock
+func (ix *wordIndex) match(atom queryAtom) matches {
+	found := matches{}
+	switch {
+	case atom.prefix:
+		for term := range ix.searchTerms() {
+			if strings.HasPrefix(term, atom.words[0]) {
+				found.merge(ix.matchWord(term), true)
+			}
+		}
+	case len(atom.words) == 1:
+		found = ix.matchWord(atom.words[0])
+	default:
+		found = ix.matchPhrase(atom.words)
+	}
+	return found
+}
+
+// matchWord returns the files containing the analyzed word term, the caller holds the lock
+func (ix *wordIndex) matchWord(term string) matches {
+	found := matches{}
+	docs := ix.termDocs(term)
+	idf := ix.idf(len(docs))
+	for name := range docs {
+		terms := ix.termsOf(name)
+		positions := terms.positions[term]
+		found[name] = &searchMatch{
+			name:  name,
+			score: idf * ix.tf(len(positions), ix.files[name].Count),
+			hits:  terms.wordsAt(positions),
+		}
+	}
+	return found
+}
+
+// matchPhrase returns the files containing the analyzed words one after the other, the
+// caller holds the lock
+func (ix *wordIndex) matchPhrase(words []string) matches {
+	found := matches{}
+	for name := range ix.termDocs(words[0]) {
+		terms := ix.termsOf(name)
+		var hits []int
+		count := 0
+		for _, start := range terms.positions[words[0]] {
+			if !phraseAt(terms, words, start) {
+				continue
+			}
+			count++
+			for i := range words {
+				hits = append(hits, terms.words[start+i])
+			}
+		}
+		if count > 0 {
+			found[name] = &searchMatch{name: name, score: ix.tf(count, ix.files[name].Count), hits: hits}
+		}
+	}
+	idf := ix.idf(len(found))
+	for _, match := range found {
+		match.score *= idf
+	}
+	return found
+}
+
+// phraseAt reports whether the words of a phrase follow each other in terms from start
+func phraseAt(terms *fileTerms, words []string, start int) bool {
+	for i, word := range words[1:] {
+		positions := terms.positions[word]
+		j := sort.SearchInts(positions, start+i+1)
+		if j == len(positions) || positions[j] != start+i+1 {
+			return false
+		}
+	}
+	return true
+}
+
+// idf returns the BM25 inverse document frequency of a term found in df files, the caller
+// holds the lock
+func (ix *wordIndex) idf(df int) float64 {
+	n := float64(len(ix.files))
+	return math.Log(1 + (n-float64(df)+0.5)/(float64(df)+0.5))
+}
+
+// tf returns the BM25 weight of a term found freq times in a file of length words, the
+// caller holds the lock
+func (ix *wordIndex) tf(freq, length int) float64 {
+	avg := float64(ix.count) / float64(len(ix.files))
+	if avg == 0 {
+		avg = 1
+	}
+	f := float64(freq)
+	return f * (bm25K1 + 1) / (f + bm25K1*(1-bm25B+bm25B*float64(length)/avg))
+}
+
+// snippet returns the words of a file around its first hit and the byte ranges of the
+// hits in it
+func (fs *FileStore) snippet(name string, hits []int) (string, [][2]int, error) {
+	file, err := fs.backend.Get(name)
+	if err != nil {
+		return "", nil, err
+	}
+	defer file.Close()
+	from := 0
+	if len(hits) > 0 && hits[0] > snippetBefore {
+		from = hits[0] - snippetBefore
+	}
+	highlighted := make(map[int]bool, len(hits))
+	for _, hit := range hits {
+		highlighted[hit] = true
+	}
+
+	scanner := newWordScanner(file)
+	var b strings.Builder
+	var highlights [][2]int
+	if from > 0 {
+		b.WriteString("...")
+	}
+	pos := 0
+	for ; scanner.Scan(); pos++ {
+		if pos < from {
+			continue
+		}
+		if pos == from+snippetWords {
+			b.WriteString(" ...")
+			break
+		}
+		if b.Len() > 0 {
+			b.WriteByte(' ')
+		}
+		if highlighted[pos] {
+			highlights = append(highlights, [2]int{b.Len(), b.Len() + len(scanner.Bytes())})
+		}
+		b.Write(scanner.Bytes())
+	}
+	return b.String(), highlights, scanner.Err()
+}
+
+// Search returns as JSON the files matching the query q ranked by relevance, with a
+// snippet of each. limit defaults to 10.
+func (fs *FileStore) Search(w http.ResponseWriter, r *http.Request) {
+	q, err := parseQuery(r.FormValue("q"))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("invalid query: %v", err), http.StatusBadRequest)
+		return
+	}
+	limit := 10
+	if s := r.FormValue("limit"); s != "" {
+		if limit, err = strconv.Atoi(s); err != nil || limit < 0 {
+			http.Error(w, fmt.Sprintf("invalid limit %q", s), http.StatusBadRequest)
+			return
+		}
+	}
+	fs.Logger.Infof("Searching %q", r.FormValue("q"))
+	ranked, total := fs.index.Search(q, limit)
+	results := SearchResults{Total: total, Hits: make([]SearchHit, 0, len(ranked))}
+	for _, match := range ranked {
+		hit := SearchHit{Name: match.name, Score: match.score}
+		hit.Snippet, hit.Highlights, err = fs.snippet(match.name, match.hits)
+		if err != nil {
+			// the file changed since the query was answered, it is shown without snippet
+			fs.Logger.Warnf("Could not read snippet of %s: %v", match.name, err)
+		}
+		results.Hits = append(results.Hits, hit)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(results); err != nil {
+		fs.Logger.Errorf("Could not write search results: %v", err)
+	}
+}
@@ -0,0 +1,121 @@
+package filestore
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseQuery(t *testing.T) {
+	q, err := parseQuery(`deploy* "build failed" OR error -debug NOT trace`)
+	require.NoError(t, err)
+	require.Equal(t, &query{
+		must: [][]queryAtom{
+			{{words: []string{"deploy"}, prefix: true}},
+			{{words: []string{"build", "failed"}}, {words: []string{"error"}}},
+		},
+		not: []queryAtom{{words: []string{"debug"}}, {words: []string{"trace"}}},
+	}, q)
+
+	for _, s := range []string{"", "OR a", "a OR", "a NOT", "-a", `"a b`, `a ""`, "a OR -b"} {
+		_, err := parseQuery(s)
+		require.Error(t, err, s)
+	}
+}
+
+func TestSearch(t *testing.T) {
+	fs := newTestStore(t)
+	defer os.RemoveAll(fs.StoreDir)
+	w := serve(fs.Add, multipartRequest(t, "POST", "/add", map[string]string{
+		"a.txt": "the build failed then the deploy failed",
+		"b.txt": "build ok deploying now",
+		"c.txt": "failed build failed build failed build",
+	}))
+	require.Equal(t, http.StatusOK, w.Code)
+
+	search := func(q string) SearchResults {
+		w := serve(fs.Search, httptest.NewRequest("GET", "/search?q="+url.QueryEscape(q), nil))
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		var results SearchResults
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &results))
+		return results
+	}
+	names := func(results SearchResults) []string {
+		var names []string
+		for _, hit := range results.Hits {
+			names = append(names, hit.Name)
+		}
+		return names
+	}
+
+	require.Equal(t, []string{"c.txt", "b.txt", "a.txt"}, names(search("build")))
+	require.Equal(t, []string{"c.txt", "a.txt"}, names(search("build failed")))
+	require.Equal(t, []string{"c.txt", "a.txt"}, names(search(`"build failed"`)))
+	require.Equal(t, []string{"c.txt"}, names(search(`"failed build"`)))
+	require.Equal(t, []string{"b.txt", "a.txt"}, names(search("deploy*")))
+	require.Equal(t, []string{"c.txt", "b.txt"}, names(search("build NOT deploy")))
+	require.Equal(t, []string{"b.txt"}, names(search("deploying OR missing")))
+	require.Empty(t, search("missing").Hits)
+
+	results := search(`"deploy failed"`)
+	require.Equal(t, 1, results.Total)
+	hit := results.Hits[0]
+	require.Equal(t, "the build failed then the deploy failed", hit.Snippet)
+	require.Equal(t, [][2]int{{26, 32}, {33, 39}}, hit.Highlights)
+
+	w = serve(fs.Search, httptest.NewRequest("GET", "/search?q=build&limit=1", nil))
+	var limited SearchResults
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &limited))
+	require.Equal(t, 3, limited.Total)
+	require.Len(t, limited.Hits, 1)
+
+	w = serve(fs.Search, httptest.NewRequest("GET", "/search?q=OR", nil))
+	require.Equal(t, http.StatusBadRequest, w.Code)
+
+	// removed files are no longer found
+	w = serve(fs.Remove, httptest.NewRequest("POST", "/remove?file=b.txt", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, []string{"a.txt"}, names(search("deploy*")))
+}
+
+func TestSearchAnalyzed(t *testing.T) {
+	fs := newTestStore(t)
+	defer os.RemoveAll(fs.StoreDir)
+	w := serve(fs.Add, multipartRequest(t, "POST", "/add", map[string]string{
+		"a.txt": "Deploy, then Build-Failed.",
+		"b.txt": "we deploy",
+	}))
+	require.Equal(t, http.StatusOK, w.Code)
+
+	search := func(q string) SearchResults {
+		w := serve(fs.Search, httptest.NewRequest("GET", "/search?q="+url.QueryEscape(q), nil))
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		var results SearchResults
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &results))
+		return results
+	}
+
+	// words match ignoring case and punctuation, and are highlighted as written
+	results := search("DEPLOY")
+	require.Equal(t, 2, results.Total)
+	hit := results.Hits[1]
+	require.Equal(t, "a.txt", hit.Name)
+	require.Equal(t, "Deploy, then Build-Failed.", hit.Snippet)
+	require.Equal(t, [][2]int{{0, 7}}, hit.Highlights)
+	require.True(t, results.Hits[0].Score > hit.Score)
+
+	for _, q := range []string{"failed", `"build failed"`, "build-failed", "Build*", `"then build"`} {
+		results = search(q)
+		require.Equal(t, 1, results.Total, q)
+		require.Equal(t, [][2]int{{13, 26}}, results.Hits[0].Highlights[len(results.Hits[0].Highlights)-1:], q)
+	}
+	require.Empty(t, search(`"deploy build"`).Hits)
+
+	w = serve(fs.Search, httptest.NewRequest("GET", "/search?q="+url.QueryEscape("deploy ,"), nil))
+	require.Equal(t, http.StatusBadRequest, w.Code)
+}
@@ -2,16 +2,24 @@ package filestore
 
 import (
 	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
 	"path/filepath"
 	"io"
-	"io/ioutil"
+	"mime"
 	"net"
 	"net/http"
 	"os"
+	"runtime"
 	"strconv"
 	"sort"
+	"strings"
 	"sync"
+	"time"
+	"unicode/utf8"
 
 	"filestore/helper"
 	"github.com/spf13/pflag"
@@ -21,6 +29,9 @@ import (
 const (
 	//DefaultPort for running filestore server
 	DefaultPort int16 = 9090
+	// maxWordLength is the length in bytes of the longest word, longer runs of non-space
+	// characters are split in several words
+	maxWordLength = 1 << 20
 )
 
 // Config struct holds filestore server parameters
@@ -28,6 +39,21 @@ type Config struct {
 	BindIP string
 	BindHTTPPort int
 	StoreDir string
+	Backend string
+	Dedup bool
+	GCInterval time.Duration
+	KeepVersions int
+	KeepVersionsDays int
+	NamePolicy NamePolicy
+	TrashMaxAge time.Duration
+	UploadMaxAge time.Duration
+	ReadTimeout time.Duration
+	ReadHeaderTimeout time.Duration
+	WriteTimeout time.Duration
+	IdleTimeout time.Duration
+	ShutdownTimeout time.Duration
+	Analyzer string
+	ScanWorkers int
 	Logger  *logrus.Logger
 }
 
@@ -37,6 +63,16 @@ func NewConfig() *Config {
 		BindIP:          "0.0.0.0",
 		BindHTTPPort:    int(DefaultPort),
 		StoreDir: "",
+		Backend: BackendLocal,
+		GCInterval: time.Hour,
+		TrashMaxAge: 30 * 24 * time.Hour,
+		UploadMaxAge: 24 * time.Hour,
+		ReadHeaderTimeout: time.Minute,
+		IdleTimeout: 2 * time.Minute,
+		ShutdownTimeout: 30 * time.Second,
+		Analyzer: AnalyzerStandard,
+		ScanWorkers: runtime.NumCPU(),
+		NamePolicy: NamePolicy{MaxLength: DefaultMaxNameLength, Case: CaseSensitive},
 		Logger: helper.NewLogger("filestore"),
 	}
 	return &c
@@ -48,6 +84,23 @@ func (c *Config) BindFlags(fs *pflag.FlagSet) {
 	fs.StringVar(&c.BindIP, "bind-ip", c.BindIP, "IP fileStore server will listen to")
 	fs.IntVar(&c.BindHTTPPort, "bind-http-port", c.BindHTTPPort, "HTTP Port fileStore server will listen to")
 	fs.StringVar(&c.StoreDir, "store-dir", filepath.Join(home,"store"), "filestore storage dir")
+	fs.StringVar(&c.Backend, "backend", c.Backend, "filestore storage backend, one of local or memory")
+	fs.BoolVar(&c.Dedup, "dedup", c.Dedup, "store identical contents once, files already stored are migrated on start")
+	fs.DurationVar(&c.GCInterval, "gc-interval", c.GCInterval, "interval between collections of unreferenced contents in dedup mode")
+	fs.IntVar(&c.KeepVersions, "keep-versions", c.KeepVersions, "number of previous versions kept per file, 0 keeps them all")
+	fs.IntVar(&c.KeepVersionsDays, "keep-versions-days", c.KeepVersionsDays, "days previous versions are kept for, 0 keeps them all")
+	fs.DurationVar(&c.TrashMaxAge, "trash-max-age", c.TrashMaxAge, "age after which removed files are purged from the trash, 0 keeps them")
+	fs.DurationVar(&c.UploadMaxAge, "upload-max-age", c.UploadMaxAge, "time after which resumable uploads receiving no chunk expire")
+	fs.DurationVar(&c.ReadTimeout, "read-timeout", c.ReadTimeout, "maximum duration for reading a request including its body, uploads included, 0 for no limit")
+	fs.DurationVar(&c.ReadHeaderTimeout, "read-header-timeout", c.ReadHeaderTimeout, "maximum duration for reading the headers of a request, 0 for no limit")
+	fs.DurationVar(&c.WriteTimeout, "write-timeout", c.WriteTimeout, "maximum duration for receiving a request and writing its response, downloads and grep streams included, 0 for no limit")
+	fs.DurationVar(&c.IdleTimeout, "idle-timeout", c.IdleTimeout, "maximum duration a keep-alive connection waits for the next request")
+	fs.DurationVar(&c.ShutdownTimeout, "shutdown-timeout", c.ShutdownTimeout, "maximum duration in-flight requests are given to complete on shutdown")
+	fs.IntVar(&c.NamePolicy.MaxLength, "max-name-length", c.NamePolicy.MaxLength, "maximum length in bytes of file names, 0 for no limit")
+	fs.StringSliceVar(&c.NamePolicy.AllowedExtensions, "allowed-extensions", c.NamePolicy.AllowedExtensions, "file extensions allowed in the store, all when empty")
+	fs.StringVar(&c.NamePolicy.Case, "name-case", c.NamePolicy.Case, "file names case policy, one of sensitive or insensitive")
+	fs.StringVar(&c.Analyzer, "analyzer", c.Analyzer, "default analyzer of word statistics, one of standard or raw")
+	fs.IntVar(&c.ScanWorkers, "scan-workers", c.ScanWorkers, "number of files scanned at once when indexing, counting n-grams or searching")
 	pflag.Usage = func() {
 		fmt.Fprintf(os.Stderr, "Usage of filestore:\n")
 		fs.PrintDefaults()
@@ -58,63 +111,209 @@ type FileStore struct {
 	Logger *logrus.Logger
 	StoreDir string
 	BindHTTPAddress string
+	KeepVersions int
+	KeepVersionsDays int
+	NamePolicy NamePolicy
+	GCInterval time.Duration
+	TrashMaxAge time.Duration
+	UploadMaxAge time.Duration
+	ReadTimeout time.Duration
+	ReadHeaderTimeout time.Duration
+	WriteTimeout time.Duration
+	IdleTimeout time.Duration
+	ShutdownTimeout time.Duration
+	Analyzer string
+	// ScanWorkers bounds the number of files scanned at once
+	ScanWorkers int
+	backend Backend
+	index *wordIndex
+	versionsMu sync.Mutex
+	uploadLocks keyedLocks
+	// fileLocks serializes the writes of a file, it is locked through lockName
+	fileLocks keyedLocks
 }
 
-// init creates the store if it doesnt exist
-func (fs *FileStore) init() {
-	if _, err := os.Stat(fs.StoreDir); os.IsNotExist(err) {
-			err = os.MkdirAll(fs.StoreDir, 0755)
-			if err != nil {
-				fs.Logger.Fatalf("Could not create file store: %s", err)
-			}
+// init creates the store backend if it doesnt exist and brings the word index up to date
+func (fs *FileStore) init(c *Config) error {
+	if fs.NamePolicy.Case != CaseSensitive && fs.NamePolicy.Case != CaseInsensitive {
+		return fmt.Errorf("unknown name case policy %q", fs.NamePolicy.Case)
 	}
+	if _, err := newAnalyzer(fs.Analyzer); err != nil {
+		return fmt.Errorf("invalid default analyzer: %v", err)
+	}
+	if fs.ScanWorkers < 1 {
+		return fmt.Errorf("invalid number of scan workers %d, expecting 1 or more", fs.ScanWorkers)
+	}
+	backend, err := newBackend(c)
+	if err != nil {
+		return fmt.Errorf("could not create file store: %v", err)
+	}
+	fs.backend = backend
+	index, err := loadWordIndex(fs.backend)
+	if err != nil {
+		fs.Logger.Warnf("Could not load word index, rebuilding it: %v", err)
+		index = newWordIndex(fs.backend)
+	}
+	fs.index = index
+	if outdated := fs.index.Outdated(); outdated > 0 {
+		fs.Logger.Infof("Upgrading %d word index entries of a previous release, their files are scanned again", outdated)
+	}
+	changed, err := fs.index.Sync(fs.ScanWorkers)
+	if _, ok := err.(*ScanError); ok {
+		fs.Logger.Warnf("Word index is missing files, they are scanned again on restart: %v", err)
+	} else if err != nil {
+		return fmt.Errorf("could not build word index: %v", err)
+	}
+	if changed {
+		fs.Logger.Infof("Word index was stale, saving rebuilt entries")
+	}
+	if err := fs.index.Save(); err != nil {
+		return fmt.Errorf("could not save word index: %v", err)
+	}
+	return nil
+}
+
+// reindex scans a stored file again and records its words in the index. A file that
+// cannot be scanned is dropped from the index rather than left with its previous words,
+// and its error is reported by the word statistics.
+func (fs *FileStore) reindex(name string) error {
+	entry, err := scanFile(fs.backend, name)
+	if err != nil {
+		fs.index.Fail(name, err)
+		return err
+	}
+	fs.index.Set(name, entry)
+	return nil
 }
 
-// NewFileStore creates a new Client
-func NewFileStore(c *Config) *FileStore {
+// NewFileStore creates a new Client, it fails on an invalid configuration or when the store
+// or its word index cannot be set up
+func NewFileStore(c *Config) (*FileStore, error) {
 	HTTPaddress := net.JoinHostPort(c.BindIP, strconv.Itoa(c.BindHTTPPort))
 	var fs = FileStore{
 		BindHTTPAddress: HTTPaddress,
 		Logger: c.Logger,
 		StoreDir: c.StoreDir,
+		KeepVersions: c.KeepVersions,
+		KeepVersionsDays: c.KeepVersionsDays,
+		NamePolicy: c.NamePolicy,
+		GCInterval: c.GCInterval,
+		TrashMaxAge: c.TrashMaxAge,
+		UploadMaxAge: c.UploadMaxAge,
+		ReadTimeout: c.ReadTimeout,
+		ReadHeaderTimeout: c.ReadHeaderTimeout,
+		WriteTimeout: c.WriteTimeout,
+		IdleTimeout: c.IdleTimeout,
+		ShutdownTimeout: c.ShutdownTimeout,
+		Analyzer: c.Analyzer,
+		ScanWorkers: c.ScanWorkers,
+	}
+	if err := fs.init(c); err != nil {
+		return nil, err
 	}
-	fs.init()
-	return &fs
+	return &fs, nil
 }
 
-// Run start a filestore server and make it listen for incoming connections
-func (fs *FileStore) Run() {
-	http.HandleFunc("/add", func(w http.ResponseWriter, r *http.Request) {
-		fs.Add(w, r)
-	})
-	http.HandleFunc("/list", func(w http.ResponseWriter, r *http.Request) {
-		fs.List(w, r)
-	})
-	http.HandleFunc("/remove", func(w http.ResponseWriter, r *http.Request) {
-		fs.Remove(w, r)
-	})
-	http.HandleFunc("/update", func(w http.ResponseWriter, r *http.Request) {
-		fs.Update(w, r)
-	})
-	http.HandleFunc("/freqwords", func(w http.ResponseWriter, r *http.Request) {
-		fs.FreqWords(w, r)
-	})
-	http.HandleFunc("/countwords", func(w http.ResponseWriter, r *http.Request) {
-		fs.CountWords(w, r)
-	})
-	HTTPServer := &http.Server{Addr: fs.BindHTTPAddress, Handler: nil}
-	if err := HTTPServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-		fs.Logger.Fatalf("Could not listen on %s: %v\n", fs.BindHTTPAddress, err)
+// Handler returns the handler serving the v1 and v2 routes of the store
+func (fs *FileStore) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/add", fs.Add)
+	mux.HandleFunc("/get", fs.Get)
+	mux.HandleFunc("/stat", fs.Stat)
+	mux.HandleFunc("/list", fs.List)
+	mux.HandleFunc("/remove", fs.Remove)
+	mux.HandleFunc("/update", fs.Update)
+	mux.HandleFunc("/versions", fs.Versions)
+	mux.HandleFunc("/restore", fs.Restore)
+	mux.HandleFunc("/freqwords", fs.FreqWords)
+	mux.HandleFunc("/countwords", fs.CountWords)
+	mux.HandleFunc("/freqngrams", fs.FreqNgrams)
+	mux.HandleFunc("/search", fs.Search)
+	mux.HandleFunc("/grep", fs.Grep)
+	mux.HandleFunc("/v2/", fs.V2)
+	mux.HandleFunc("/trash", fs.Trash)
+	mux.HandleFunc("/trash/restore", fs.Undelete)
+	mux.HandleFunc("/trash/purge", fs.PurgeTrash)
+	return mux
+}
+
+// Run starts a filestore server and serves incoming connections until ctx is done. In-flight
+// requests are then given ShutdownTimeout to complete before the server stops, streams
+// such as grep responses which could last longer are cancelled.
+func (fs *FileStore) Run(ctx context.Context) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	if dedup, ok := fs.backend.(*DedupBackend); ok && fs.GCInterval > 0 {
+		go fs.collectGarbage(ctx, dedup)
+	}
+	if fs.TrashMaxAge > 0 {
+		go fs.purgeExpiredTrash(ctx)
+	}
+	go fs.purgeExpiredUploads(ctx)
+	// request contexts are cancelled on shutdown, uploads and downloads dont watch them
+	// and complete while streams stop
+	requestsCtx, cancelRequests := context.WithCancel(context.Background())
+	defer cancelRequests()
+	HTTPServer := &http.Server{
+		Addr:              fs.BindHTTPAddress,
+		Handler:           fs.Handler(),
+		ReadTimeout:       fs.ReadTimeout,
+		ReadHeaderTimeout: fs.ReadHeaderTimeout,
+		WriteTimeout:      fs.WriteTimeout,
+		IdleTimeout:       fs.IdleTimeout,
+		BaseContext: func(net.Listener) context.Context {
+			return requestsCtx
+		},
+	}
+	errc := make(chan error, 1)
+	go func() {
+		errc <- HTTPServer.ListenAndServe()
+	}()
+	select {
+	case err := <-errc:
+		return fmt.Errorf("could not listen on %s: %v", fs.BindHTTPAddress, err)
+	case <-ctx.Done():
+	}
+	fs.Logger.Infof("Shutting down the filestore server")
+	cancelRequests()
+	shutdownCtx, cancelShutdown := context.WithTimeout(context.Background(), fs.ShutdownTimeout)
+	defer cancelShutdown()
+	if err := HTTPServer.Shutdown(shutdownCtx); err != nil {
+		return fmt.Errorf("could not drain in-flight requests: %v", err)
+	}
+	return nil
+}
+
+// collectGarbage periodically deletes the contents no file references anymore until ctx is done
+func (fs *FileStore) collectGarbage(ctx context.Context, dedup *DedupBackend) {
+	ticker := time.NewTicker(fs.GCInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		collected, err := dedup.GC()
+		if err != nil {
+			fs.Logger.Errorf("Could not collect unreferenced contents: %v", err)
+			continue
+		}
+		fs.Logger.Infof("Collected %d unreferenced contents", collected)
 	}
 }
 
 // Add adds files to the store
 func (fs *FileStore) Add(w http.ResponseWriter, r *http.Request) {
 	fs.Logger.Infof("Adding multipart files to the store")
+	u, err := uploadFromRequest(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 	reader, err := r.MultipartReader()
 	if err != nil {
-		fs.Logger.Fatalf("%v", err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 	for {
@@ -122,36 +321,159 @@ func (fs *FileStore) Add(w http.ResponseWriter, r *http.Request) {
 		if err == io.EOF {
 			break
 		}
-		if part.FileName() == "" {
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if partFileName(part) == "" {
 			continue
 		}
-		fs.Logger.Infof("checking if file exist in the store")
-		if _, err = os.Stat(filepath.Join(fs.StoreDir, part.FileName())); !os.IsNotExist(err) {
-			http.Error(w, "File already exist", http.StatusConflict)
+		fileName, err := fs.checkName(partFileName(part))
+		if err != nil {
+			http.Error(w, err.Error(), nameStatus(err))
 			return
 		}
-		fs.Logger.Infof("Adding file %s to the store", part.FileName())
-		dst, err := os.Create(filepath.Join(fs.StoreDir, part.FileName()))
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+		if status, err := fs.addPart(r, fileName, part, u); err != nil {
+			http.Error(w, err.Error(), status)
 			return
 		}
-		defer dst.Close()
-		if _, err := io.Copy(dst, part); err != nil {
+	}
+}
+
+// addPart writes a part of an Add request as a new file, it returns the status of the failure
+func (fs *FileStore) addPart(r *http.Request, fileName string, part io.Reader, u upload) (int, error) {
+	fileName, unlock := fs.lockName(fileName)
+	defer unlock()
+	if err := fs.checkPreconditions(r, fileName); err != nil {
+		return errorStatus(err), err
+	}
+	fs.Logger.Infof("checking if file exist in the store")
+	if _, err := fs.backend.Stat(fileName); !os.IsNotExist(err) {
+		return http.StatusConflict, fmt.Errorf("File already exist")
+	}
+	fs.Logger.Infof("Adding file %s to the store", fileName)
+	if err := fs.write(fileName, part, u); err != nil {
+		return http.StatusInternalServerError, err
+	}
+	return http.StatusOK, nil
+}
+
+// Get streams a file of the store, or one of its versions when a version is given
+func (fs *FileStore) Get(w http.ResponseWriter, r *http.Request) {
+	fileName, err := fs.checkName(r.FormValue("file"))
+	if err != nil {
+		http.Error(w, err.Error(), nameStatus(err))
+		return
+	}
+	version, err := versionParam(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	fs.Logger.Infof("Getting file %s from the store", fileName)
+	file, fi, meta, err := fs.open(fileName, version)
+	if os.IsNotExist(err) {
+		http.Error(w, "File does not exist", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer file.Close()
+	fs.serveContent(w, r, fileName, version, fi, meta, file)
+}
+
+// open opens a file, or one of its versions when version is not 0. The metadata record of a
+// file is read along with the opening under the lock of the file, so it describes the opened
+// content even when the file is written meanwhile. Versions have no record.
+func (fs *FileStore) open(fileName string, version int) (Object, os.FileInfo, *Metadata, error) {
+	name := fileName
+	if version > 0 {
+		name = versionPath(fileName, version)
+	} else {
+		var unlock func()
+		fileName, unlock = fs.lockName(fileName)
+		defer unlock()
+		name = fileName
+	}
+	file, err := fs.backend.Get(name)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	fi, err := fs.backend.Stat(name)
+	if err != nil {
+		file.Close()
+		return nil, nil, nil, err
+	}
+	if version > 0 {
+		return file, fi, nil, nil
+	}
+	meta, err := fs.metadata(fileName)
+	if err != nil {
+		fs.Logger.Warnf("Could not read metadata of %s: %v", fileName, err)
+	}
+	return file, fi, meta, nil
+}
+
+// serveContent writes the content of a file opened by open. The ETag is the SHA-256 of
+// the content, so ServeContent answers Range, If-Range, If-None-Match and If-Modified-Since
+// requests with 206 and 304 responses.
+func (fs *FileStore) serveContent(w http.ResponseWriter, r *http.Request, fileName string, version int, fi os.FileInfo, meta *Metadata, file Object) {
+	if version == 0 {
+		if meta != nil {
+			w.Header().Set("Content-Type", meta.ContentType)
+			w.Header().Set("ETag", etag(meta.SHA256))
+		}
+	} else {
+		if contentType := mime.TypeByExtension(filepath.Ext(fileName)); contentType != "" {
+			w.Header().Set("Content-Type", contentType)
+		}
+		// versions have no metadata record, their content never changes once archived
+		h := sha256.New()
+		if _, err := io.Copy(h, file); err == nil {
+			w.Header().Set("ETag", etag(hex.EncodeToString(h.Sum(nil))))
+		}
+		if _, err := file.Seek(0, io.SeekStart); err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
 	}
+	// ServeContent sniffs the content type when it is not known yet and sets
+	// Content-Length and Last-Modified
+	http.ServeContent(w, r, fileName, fi.ModTime(), file)
+}
+
+// allowMethods reports whether the method of r is one of allowed, the request is answered
+// with a 405 response listing them in the Allow header otherwise
+func allowMethods(w http.ResponseWriter, r *http.Request, allowed ...string) bool {
+	for _, method := range allowed {
+		if r.Method == method {
+			return true
+		}
+	}
+	w.Header().Set("Allow", strings.Join(allowed, ", "))
+	http.Error(w, fmt.Sprintf("method %s is not allowed", r.Method), http.StatusMethodNotAllowed)
+	return false
+}
+
+// etag returns the strong entity tag of a content of SHA-256 sum
+func etag(sum string) string {
+	return `"` + sum + `"`
 }
 
 // List lists files in the store
 func (fs *FileStore) List(w http.ResponseWriter, r *http.Request) {
 	fs.Logger.Infof("Listing files in the store")
-	files, err := ioutil.ReadDir(fs.StoreDir)
+	files, err := fs.backend.List("")
 	if err != nil {
         http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
     }
+	if long, _ := strconv.ParseBool(r.FormValue("long")); long {
+		fs.listLong(w, files)
+		return
+	}
     for _, file := range files {
 		_, err = io.WriteString(w, fmt.Sprintf("%s\n", file.Name()))
 		if err != nil {
@@ -161,47 +483,95 @@ func (fs *FileStore) List(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-// Remove removes files from the store
+// listLong writes the metadata records of files as a JSON array
+func (fs *FileStore) listLong(w http.ResponseWriter, files []os.FileInfo) {
+	records, err := fs.listMetadata(files)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(records); err != nil {
+		fs.Logger.Errorf("Could not write file list: %v", err)
+	}
+}
+
+// Remove moves a file of the store to the trash
 func (fs *FileStore) Remove(w http.ResponseWriter, r *http.Request) {
 	fs.Logger.Infof("Removing file from the store")
-	fileName := r.FormValue("file")
-	fs.Logger.Infof("Removing file name %s", fileName)
-	err := os.Remove(filepath.Join(fs.StoreDir, fileName))
+	fileName, err := fs.checkName(r.FormValue("file"))
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		http.Error(w, err.Error(), nameStatus(err))
+		return
+	}
+	fs.Logger.Infof("Removing file name %s", fileName)
+	if err := fs.remove(r, fileName); err != nil {
+		http.Error(w, err.Error(), errorStatus(err))
 		return
 	}
 }
 
+// remove moves a file to the trash and drops its words from the index once the
+// preconditions of r hold
+func (fs *FileStore) remove(r *http.Request, name string) error {
+	name, unlock := fs.lockName(name)
+	defer unlock()
+	if err := fs.checkPreconditions(r, name); err != nil {
+		return err
+	}
+	if err := fs.trash(name); err != nil {
+		return err
+	}
+	fs.index.Remove(name)
+	return fs.index.Save()
+}
+
 // Update updates a file in the store
 func (fs *FileStore) Update(w http.ResponseWriter, r *http.Request) {
 	fs.Logger.Infof("Updating file in the store")
+	u, err := uploadFromRequest(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 	reader, err := r.MultipartReader()
 	if err != nil {
-		fs.Logger.Fatalf("%v", err)
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 	part, err := reader.NextPart()
 	if err != nil {
-		fs.Logger.Fatalf("%v", err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	fs.Logger.Infof("Updating file %s",part.FileName())
-	dst, err := os.OpenFile(filepath.Join(fs.StoreDir,part.FileName()), os.O_WRONLY|os.O_CREATE, 0666)
+	fileName, err := fs.checkName(partFileName(part))
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		http.Error(w, err.Error(), nameStatus(err))
 		return
 	}
-	defer dst.Close()
-	if _, err := io.Copy(dst, part); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	fs.Logger.Infof("Updating file %s", fileName)
+	if err := fs.replace(r, fileName, part, u); err != nil {
+		http.Error(w, err.Error(), errorStatus(err))
 		return
 	}
 }
 
-// FreqWords return most frequent words
+// replace writes the content of part as a file once the preconditions of r hold, the
+// replaced content is archived as a version
+func (fs *FileStore) replace(r *http.Request, fileName string, part io.Reader, u upload) error {
+	fileName, unlock := fs.lockName(fileName)
+	defer unlock()
+	if err := fs.checkPreconditions(r, fileName); err != nil {
+		return err
+	}
+	return fs.writeVersioned(fileName, part, u)
+}
+
+// FreqWords return most frequent words, as split by the analyzer named by the analyzer parameter.
+// Stems are followed by their most common surface form in parentheses. The file, glob and
+// selector parameters restrict the words to some files, per_file=true lists the most frequent
+// words of every file under a ==> name <== header. Files the index could not scan are reported
+// in ScanErrorHeader headers.
 func (fs *FileStore) FreqWords(w http.ResponseWriter, r *http.Request) {
 	queryValues := r.URL.Query()
 	order := queryValues.Get("order")
@@ -210,137 +580,219 @@ func (fs *FileStore) FreqWords(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	q, err := fs.wordStatsParams(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 	fs.Logger.Infof("Computing most %s frequent words in %s ordering", queryValues.Get("limit"), queryValues.Get("order"))
-	result, err := searchInDir(fs.StoreDir)
+	stats, err := fs.analyze(q, limit, order)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		http.Error(w, err.Error(), errorStatus(err))
 		return
 	}
-	type keyval struct {
-		key string
-		val int
+	setScanErrors(w, stats.Errors)
+	if !q.perFile {
+		writeWords(w, stats.Words)
+		return
 	}
-	var sortedRes []keyval
-    for k, v := range result {
-        sortedRes = append(sortedRes, keyval{k, v})
+	for i, file := range stats.Files {
+		header := fmt.Sprintf("==> %s <==\n", file.Name)
+		if i > 0 {
+			header = "\n" + header
+		}
+		if _, err := io.WriteString(w, header); err != nil {
+			return
+		}
+		if !writeWords(w, file.Words) {
+			return
+		}
 	}
+}
 
-	sort.Slice(sortedRes, func(i, j int) bool {
-		if order == "dsc" {
-			return sortedRes[i].val > sortedRes[j].val
-		} 
-		return sortedRes[i].val < sortedRes[j].val
-	})
-	for rank := 0; (rank < limit && rank < len(sortedRes)); rank++ {
-        word := sortedRes[rank].key
-		freq := sortedRes[rank].val
-		_, err = io.WriteString(w,fmt.Sprintf("%3d %s\n", freq, word))
-		if err != nil {
+// writeWords writes words and their number of occurrences one per line, it reports
+// whether they were written
+func writeWords(w http.ResponseWriter, words []wordFreq) bool {
+	for _, wf := range words {
+		line := fmt.Sprintf("%3d %s\n", wf.Count, wf.Word)
+		if wf.Form != "" {
+			line = fmt.Sprintf("%3d %s (%s)\n", wf.Count, wf.Word, wf.Form)
+		}
+		if _, err := io.WriteString(w, line); err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
+			return false
 		}
-    }
+	}
+	return true
 }
 
-// CountWords counts words in the store
+// wordFreq is a word and its number of occurrences, Form is the most common surface form
+// of the word when it is a stem
+type wordFreq struct {
+	Word  string `json:"word"`
+	Count int    `json:"count"`
+	Form  string `json:"form,omitempty"`
+}
+
+// topWords returns the limit most frequent words when order is dsc, the least frequent otherwise
+func topWords(words map[string]int, limit int, order string) []wordFreq {
+	sorted := make([]wordFreq, 0, len(words))
+	for k, v := range words {
+		sorted = append(sorted, wordFreq{Word: k, Count: v})
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].Count == sorted[j].Count {
+			return sorted[i].Word < sorted[j].Word
+		}
+		if order == "dsc" {
+			return sorted[i].Count > sorted[j].Count
+		}
+		return sorted[i].Count < sorted[j].Count
+	})
+	if limit < len(sorted) {
+		sorted = sorted[:limit]
+	}
+	return sorted
+}
+
+// withForms sets the surface form of words from forms, the most common surface form of stems
+func withForms(words []wordFreq, forms map[string]string) []wordFreq {
+	if forms == nil {
+		return words
+	}
+	for i := range words {
+		words[i].Form = forms[words[i].Word]
+	}
+	return words
+}
+
+// CountWords counts words in the store, as split by the analyzer named by the analyzer parameter.
+// The file, glob and selector parameters restrict the count to some files, per_file=true
+// counts the words of every file like wc does, followed by their total. The fields parameter
+// selects the counts among lines, words, chars, bytes and max_line_length, words by default.
+// Files the index could not scan are reported in ScanErrorHeader headers.
 func (fs *FileStore) CountWords(w http.ResponseWriter, r *http.Request) {
-	fs.Logger.Infof("Counting words in the store")
-	result, err := countInDir(fs.StoreDir)
+	fields, err := parseCountFields(r.FormValue("fields"))
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	_, err = io.WriteString(w, fmt.Sprintf("%3d\n", result))
+	q, err := fs.wordStatsParams(r)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-}
-
-
-// searchInDir return a map of words and their occurence inside a folder
-func searchInDir(dir string) (map[string]int, error) {
-	SuperResult := make(map[string]int)
-	filelist, err := ioutil.ReadDir(dir)
+	fs.Logger.Infof("Counting words in the store")
+	stats, err := fs.analyze(q, 0, "")
 	if err != nil {
-		helper.NewLogger("filestore").Fatalf("%v", err)
-		return nil, err
+		http.Error(w, err.Error(), errorStatus(err))
+		return
 	}
+	setScanErrors(w, stats.Errors)
+	if !q.perFile {
+		_, err = io.WriteString(w, formatCounts(fields, stats.Count, stats.textCounts, ""))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+	for _, file := range stats.Files {
+		if _, err := io.WriteString(w, formatCounts(fields, file.Count, file.textCounts, file.Name)); err != nil {
+			return
+		}
+	}
+	if len(stats.Files) > 1 {
+		io.WriteString(w, formatCounts(fields, stats.Count, stats.textCounts, "total")) // nolint: errcheck
+	}
+}
+
+// searchInDir scans the given files of the store on a pool of workers goroutines and returns
+// their index entries, along with the errors of the files that could not be read
+func searchInDir(backend Backend, names []string, workers int) (map[string]*fileEntry, []FileError) {
+	SuperResult := make(map[string]*fileEntry)
+	var errs []FileError
 	wg := &sync.WaitGroup{}
-	resultChan := make(chan map[string]int)
-	for _, fileinfo := range filelist {
+	queue := fileQueue(names)
+	resultChan := make(chan fileResult)
+	for i := 0; i < poolSize(workers, len(names)); i++ {
 		wg.Add(1)
-		go searchInFile(filepath.Join(dir,fileinfo.Name()), resultChan, wg)
+		go searchInFile(backend, queue, resultChan, wg)
 	}
-	go func() {   
+	go func() {
 		wg.Wait()
 		close(resultChan)
 	}()
-	for m := range resultChan {
-		for k, v := range m {
-			SuperResult[k] = SuperResult[k] + v
+	for r := range resultChan {
+		if r.err != nil {
+			errs = append(errs, FileError{Name: r.name, Error: r.err.Error()})
+			continue
 		}
+		SuperResult[r.name] = r.entry
 	}
-	return SuperResult, nil
+	sortFileErrors(errs)
+	return SuperResult, errs
 }
 
-// searchInFile scan a file and build a map of string occurence
-func searchInFile(path string, resultChan chan map[string]int, wg *sync.WaitGroup) {
-	defer wg.Done()
-	file, err := os.Open(path)
-	if err != nil {
-		helper.NewLogger("filestore").Fatalf("%v", err)
-	}
-	defer file.Close()
+// fileResult is the index entry of a file scanned by searchInFile, or the error scanning it
+type fileResult struct {
+	name  string
+	entry *fileEntry
+	err   error
+}
 
-	scanner := bufio.NewScanner(file)
-	scanner.Split(bufio.ScanWords)
-	result := make(map[string]int)
-	for scanner.Scan() {
-		result[scanner.Text()]++
+// searchInFile scans the files taken from queue and builds their index entries
+func searchInFile(backend Backend, queue <-chan string, resultChan chan<- fileResult, wg *sync.WaitGroup) {
+	defer wg.Done()
+	for name := range queue {
+		entry, err := scanFile(backend, name)
+		resultChan <- fileResult{name: name, entry: entry, err: err}
 	}
-	resultChan <- result
 }
 
-// countInDir counts the words in a folder
-func countInDir(dir string) (int, error) {
-	filelist, err := ioutil.ReadDir(dir)
+// scanFile builds the map of word occurences of a file and counts its lines and characters
+// in the same pass
+func scanFile(backend Backend, name string) (*fileEntry, error) {
+	fi, err := backend.Stat(name)
 	if err != nil {
-		helper.NewLogger("filestore").Fatalf("%v", err)
-		return 0, err
+		return nil, err
 	}
-	wg := &sync.WaitGroup{}
-	resultChan := make(chan int)
-	for _, fileinfo := range filelist {
-		wg.Add(1)
-		go countInFile(filepath.Join(dir,fileinfo.Name()), resultChan, wg)
+	file, err := backend.Get(name)
+	if err != nil {
+		return nil, err
 	}
-	go func() {   
-		wg.Wait()
-		close(resultChan)
-	}()
+	defer file.Close()
 
-	SuperCount := 0
-	for i := range resultChan {
-		SuperCount = SuperCount + i
+	counter := &textCounter{}
+	scanner := newWordScanner(io.TeeReader(file, counter))
+	entry := &fileEntry{Size: fi.Size(), ModTime: fi.ModTime(), Words: make(map[string]int), Positions: make(map[string][]int)}
+	for scanner.Scan() {
+		entry.Words[scanner.Text()]++
+		entry.Positions[scanner.Text()] = append(entry.Positions[scanner.Text()], entry.Count)
+		entry.Count++
 	}
-	return SuperCount, nil
+	entry.textCounts = counter.counts()
+	return entry, scanner.Err()
 }
 
-// countInFile counts the words in a file
-func countInFile(path string, resultChan chan int, wg *sync.WaitGroup) {
-	defer wg.Done()
-	file, err := os.Open(path)
-	if err != nil {
-		helper.NewLogger("filestore").Fatalf("%v", err)
-	}
-	defer file.Close()
+// newWordScanner returns a scanner splitting r into words, words longer than maxWordLength
+// are cut so that no content makes the scan fail
+func newWordScanner(r io.Reader) *bufio.Scanner {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxWordLength)
+	scanner.Split(scanWords)
+	return scanner
+}
 
-	scanner := bufio.NewScanner(file)
-	scanner.Split(bufio.ScanWords)
-	result := 0
-	for scanner.Scan() {
-		result++
+// scanWords is bufio.ScanWords cutting words at maxWordLength bytes, on a character boundary
+func scanWords(data []byte, atEOF bool) (int, []byte, error) {
+	advance, token, err := bufio.ScanWords(data, atEOF)
+	if advance > 0 || token != nil || err != nil || len(data) < maxWordLength {
+		return advance, token, err
+	}
+	// data starts with a word not ending within maxWordLength bytes
+	n := maxWordLength - utf8.UTFMax
+	for n > 0 && !utf8.RuneStart(data[n]) {
+		n--
 	}
-	resultChan <- result
-}
\ No newline at end of file
+	return n, data[:n], nil
+}
@@ -1,22 +1,77 @@
 package filestore
 
 import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"os"
 	"math/rand"
 	"path/filepath"
+	"strings"
+	"sync/atomic"
 	"time"
 	"testing"
 	"mime/multipart"
+	"net"
 	"net/http"
 	"net/http/httptest"
+
+	"github.com/stretchr/testify/require"
 )
 
-func TestAdd(t *testing.T) {
+// newTestStore returns a FileStore on a temporary directory, callers remove StoreDir when done
+func newTestStore(t *testing.T) *FileStore {
+	dir, err := ioutil.TempDir("", "filestore")
+	require.NoError(t, err)
 	config := NewConfig()
-	config.StoreDir = "./testdata"
-	fs := NewFileStore(config)
+	config.StoreDir = dir
+	fs, err := NewFileStore(config)
+	require.NoError(t, err)
+	return fs
+}
+
+// multipartRequest builds a request uploading files, a map of file names to content
+func multipartRequest(t *testing.T, method, target string, files map[string]string) *http.Request {
+	body := new(bytes.Buffer)
+	writer := multipart.NewWriter(body)
+	for name, content := range files {
+		part, err := writer.CreateFormFile("file", name)
+		require.NoError(t, err)
+		_, err = part.Write([]byte(content))
+		require.NoError(t, err)
+	}
+	require.NoError(t, writer.Close())
+	req := httptest.NewRequest(method, target, body)
+	req.Header.Add("Content-Type", writer.FormDataContentType())
+	return req
+}
+
+// serve runs handler on req and returns the recorded response
+func serve(handler http.HandlerFunc, req *http.Request) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	handler(w, req)
+	return w
+}
+
+func TestNewFileStoreInvalidConfig(t *testing.T) {
+	config := NewConfig()
+	config.Backend = BackendMemory
+	config.ScanWorkers = 0
+	_, err := NewFileStore(config)
+	require.Error(t, err)
+	config.ScanWorkers = 1
+	config.Analyzer = "unknown"
+	_, err = NewFileStore(config)
+	require.Error(t, err)
+}
+
+func TestAdd(t *testing.T) {
+	fs := newTestStore(t)
+	defer os.RemoveAll(fs.StoreDir)
 	pr, pw := io.Pipe()
 	writer := multipart.NewWriter(pw)
 	rand.Seed(time.Now().UnixNano())
@@ -43,12 +98,168 @@ func TestAdd(t *testing.T) {
     if !(resp.StatusCode == http.StatusOK || resp.StatusCode == http.StatusCreated) {
         t.Errorf("Expected %d, received %d", 201, resp.StatusCode)
     }
-    t.Logf("It should create a file named '%s' in testdata folder", fn)
-    if _, err := os.Stat(filepath.Join("./testdata", fn)); os.IsNotExist(err) {
-        t.Error("Expected file ./testdata/test.txt' to exist")
+    t.Logf("It should create a file named '%s' in the store", fn)
+    if _, err := os.Stat(filepath.Join(fs.StoreDir, fn)); os.IsNotExist(err) {
+        t.Errorf("Expected file %s to exist", fn)
     }
 }
 
+func TestAddTruncatedBody(t *testing.T) {
+	fs := newTestStore(t)
+	defer os.RemoveAll(fs.StoreDir)
+
+	// the body ends in the headers of the second part
+	body := "--b\r\nContent-Disposition: form-data; name=\"file\"; filename=\"a.txt\"\r\n\r\nfoo\r\n--b\r\nContent-Disp"
+	req := httptest.NewRequest("POST", "/add", strings.NewReader(body))
+	req.Header.Set("Content-Type", "multipart/form-data; boundary=b")
+	w := serve(fs.Add, req)
+	require.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestGet(t *testing.T) {
+	fs := newTestStore(t)
+	defer os.RemoveAll(fs.StoreDir)
+	require.NoError(t, ioutil.WriteFile(filepath.Join(fs.StoreDir, "a.txt"), []byte("Test Get function"), 0644))
+
+	w := serve(fs.Get, httptest.NewRequest("GET", "/get?file=a.txt", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, "Test Get function", w.Body.String())
+	require.Equal(t, "17", w.Header().Get("Content-Length"))
+	require.Equal(t, "text/plain; charset=utf-8", w.Header().Get("Content-Type"))
+	require.NotEmpty(t, w.Header().Get("Last-Modified"))
+
+	w = serve(fs.Get, httptest.NewRequest("GET", "/get?file=missing.txt", nil))
+	require.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestConditionalGet(t *testing.T) {
+	fs := newTestStore(t)
+	defer os.RemoveAll(fs.StoreDir)
+	w := serve(fs.Add, multipartRequest(t, "POST", "/add", map[string]string{"a.txt": "0123456789"}))
+	require.Equal(t, http.StatusOK, w.Code)
+	meta, err := fs.metadata("a.txt")
+	require.NoError(t, err)
+	tag := `"` + meta.SHA256 + `"`
+
+	get := func(header ...string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/get?file=a.txt", nil)
+		for i := 0; i < len(header); i += 2 {
+			req.Header.Set(header[i], header[i+1])
+		}
+		return serve(fs.Get, req)
+	}
+	w = get()
+	require.Equal(t, tag, w.Header().Get("ETag"))
+	require.Equal(t, "bytes", w.Header().Get("Accept-Ranges"))
+	lastModified := w.Header().Get("Last-Modified")
+
+	w = get("Range", "bytes=2-5")
+	require.Equal(t, http.StatusPartialContent, w.Code)
+	require.Equal(t, "2345", w.Body.String())
+	require.Equal(t, "bytes 2-5/10", w.Header().Get("Content-Range"))
+	w = get("Range", "bytes=20-")
+	require.Equal(t, http.StatusRequestedRangeNotSatisfiable, w.Code)
+
+	w = get("Range", "bytes=8-", "If-Range", tag)
+	require.Equal(t, http.StatusPartialContent, w.Code)
+	require.Equal(t, "89", w.Body.String())
+	// a changed content is sent whole
+	w = get("Range", "bytes=8-", "If-Range", `"other"`)
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, "0123456789", w.Body.String())
+
+	w = get("If-None-Match", tag)
+	require.Equal(t, http.StatusNotModified, w.Code)
+	require.Empty(t, w.Body.String())
+	w = get("If-None-Match", `"other"`)
+	require.Equal(t, http.StatusOK, w.Code)
+	w = get("If-Modified-Since", lastModified)
+	require.Equal(t, http.StatusNotModified, w.Code)
+
+	// versions get the tag of their own content
+	w = serve(fs.Update, multipartRequest(t, "POST", "/update", map[string]string{"a.txt": "new"}))
+	require.Equal(t, http.StatusOK, w.Code)
+	w = serve(fs.Get, httptest.NewRequest("GET", "/get?file=a.txt&version=1", nil))
+	require.Equal(t, tag, w.Header().Get("ETag"))
+	require.Equal(t, "0123456789", w.Body.String())
+	w = get("If-None-Match", tag)
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, "new", w.Body.String())
+}
+
+// racingBackend starts a write the first time a file is opened
+type racingBackend struct {
+	Backend
+	started int32
+	write   func()
+}
+
+func (b *racingBackend) Get(name string) (Object, error) {
+	obj, err := b.Backend.Get(name)
+	if atomic.CompareAndSwapInt32(&b.started, 0, 1) {
+		go b.write()
+		// leaves the write time to land
+		time.Sleep(100 * time.Millisecond)
+	}
+	return obj, err
+}
+
+func TestGetETagMatchesContent(t *testing.T) {
+	fs := newTestStore(t)
+	defer os.RemoveAll(fs.StoreDir)
+	w := serve(fs.Add, multipartRequest(t, "POST", "/add", map[string]string{"a.txt": "old"}))
+	require.Equal(t, http.StatusOK, w.Code)
+
+	// an update landing while the file is opened doesnt give the old content the new tag
+	written := make(chan int)
+	fs.backend = &racingBackend{Backend: fs.backend, write: func() {
+		written <- serve(fs.Update, multipartRequest(t, "POST", "/update", map[string]string{"a.txt": "new"})).Code
+	}}
+	w = serve(fs.Get, httptest.NewRequest("GET", "/get?file=a.txt", nil))
+	require.Equal(t, http.StatusOK, <-written)
+	sum := sha256.Sum256(w.Body.Bytes())
+	require.Equal(t, etag(hex.EncodeToString(sum[:])), w.Header().Get("ETag"), w.Body.String())
+}
+
+func TestPreconditions(t *testing.T) {
+	fs := newTestStore(t)
+	defer os.RemoveAll(fs.StoreDir)
+	withHeader := func(req *http.Request, key, value string) *http.Request {
+		req.Header.Set(key, value)
+		return req
+	}
+	w := serve(fs.Add, multipartRequest(t, "POST", "/add", map[string]string{"a.txt": "first"}))
+	require.Equal(t, http.StatusOK, w.Code)
+	w = serve(fs.Stat, httptest.NewRequest("HEAD", "/stat?file=a.txt", nil))
+	tag := w.Header().Get("ETag")
+	require.NotEmpty(t, tag)
+
+	w = serve(fs.Add, withHeader(multipartRequest(t, "POST", "/add", map[string]string{"a.txt": "again"}), "If-None-Match", "*"))
+	require.Equal(t, http.StatusPreconditionFailed, w.Code)
+	w = serve(fs.Add, withHeader(multipartRequest(t, "POST", "/add", map[string]string{"b.txt": "other"}), "If-None-Match", "*"))
+	require.Equal(t, http.StatusOK, w.Code)
+
+	w = serve(fs.Update, withHeader(multipartRequest(t, "POST", "/update", map[string]string{"a.txt": "stale"}), "If-Match", `"other"`))
+	require.Equal(t, http.StatusPreconditionFailed, w.Code)
+	w = serve(fs.Update, withHeader(multipartRequest(t, "POST", "/update", map[string]string{"missing.txt": "new"}), "If-Match", "*"))
+	require.Equal(t, http.StatusPreconditionFailed, w.Code)
+	w = serve(fs.Update, withHeader(multipartRequest(t, "POST", "/update", map[string]string{"a.txt": "second"}), "If-Match", tag))
+	require.Equal(t, http.StatusOK, w.Code)
+	// the tag changed with the content
+	w = serve(fs.Update, withHeader(multipartRequest(t, "POST", "/update", map[string]string{"a.txt": "lost"}), "If-Match", tag))
+	require.Equal(t, http.StatusPreconditionFailed, w.Code)
+	w = serve(fs.Get, httptest.NewRequest("GET", "/get?file=a.txt", nil))
+	require.Equal(t, "second", w.Body.String())
+
+	w = serve(fs.Remove, withHeader(httptest.NewRequest("POST", "/remove?file=a.txt", nil), "If-Match", tag))
+	require.Equal(t, http.StatusPreconditionFailed, w.Code)
+	tag = serve(fs.Stat, httptest.NewRequest("HEAD", "/stat?file=a.txt", nil)).Header().Get("ETag")
+	w = serve(fs.Remove, withHeader(httptest.NewRequest("POST", "/remove?file=a.txt", nil), "If-Match", `"other", `+tag))
+	require.Equal(t, http.StatusOK, w.Code)
+	_, err := fs.backend.Stat("a.txt")
+	require.True(t, os.IsNotExist(err))
+}
+
 func randString(n int) string {
     var letters = []rune("abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ")
     b := make([]rune, n)
@@ -56,4 +267,145 @@ func randString(n int) string {
         b[i] = letters[rand.Intn(len(letters))]
     }
     return string(b)
-}
\ No newline at end of file
+}
+
+func TestHandlerIsolatesStores(t *testing.T) {
+	fs1 := newTestStore(t)
+	defer os.RemoveAll(fs1.StoreDir)
+	fs2 := newTestStore(t)
+	defer os.RemoveAll(fs2.StoreDir)
+	srv1 := httptest.NewServer(fs1.Handler())
+	defer srv1.Close()
+	srv2 := httptest.NewServer(fs2.Handler())
+	defer srv2.Close()
+
+	req, err := http.NewRequest("PUT", srv1.URL+"/v2/files/a.txt", strings.NewReader("foo"))
+	require.NoError(t, err)
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+
+	resp, err = http.Get(srv1.URL + "/get?file=a.txt")
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	resp, err = http.Get(srv2.URL + "/get?file=a.txt")
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
+
+func TestRunDrainsOnCancel(t *testing.T) {
+	fs := newTestStore(t)
+	defer os.RemoveAll(fs.StoreDir)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	fs.BindHTTPAddress = l.Addr().String()
+	l.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- fs.Run(ctx)
+	}()
+	url := "http://" + fs.BindHTTPAddress + "/v2/files/a.txt"
+	for i := 0; ; i++ {
+		resp, err := http.Get(url)
+		if err == nil {
+			resp.Body.Close()
+			break
+		}
+		require.True(t, i < 500, "server did not start: %v", err)
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// an upload still sending its body when the server is stopped completes
+	body, writer := io.Pipe()
+	req, err := http.NewRequest("PUT", url, body)
+	require.NoError(t, err)
+	status := make(chan int, 1)
+	go func() {
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			status <- 0
+			return
+		}
+		resp.Body.Close()
+		status <- resp.StatusCode
+	}()
+	_, err = writer.Write([]byte("foo "))
+	require.NoError(t, err)
+	time.Sleep(100 * time.Millisecond)
+	cancel()
+	time.Sleep(100 * time.Millisecond)
+	_, err = writer.Write([]byte("bar"))
+	require.NoError(t, err)
+	require.NoError(t, writer.Close())
+	require.Equal(t, http.StatusCreated, <-status)
+	require.NoError(t, <-done)
+
+	content, err := ioutil.ReadFile(filepath.Join(fs.StoreDir, "a.txt"))
+	require.NoError(t, err)
+	require.Equal(t, "foo bar", string(content))
+}
+
+// endlessBackend serves an endless content for endless.txt
+type endlessBackend struct {
+	Backend
+}
+
+func (b endlessBackend) Get(name string) (Object, error) {
+	if name != "endless.txt" {
+		return b.Backend.Get(name)
+	}
+	return endlessObject{}, nil
+}
+
+// endlessObject is an endless sequence of lines
+type endlessObject struct{}
+
+func (endlessObject) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = "x\n"[i%2]
+	}
+	return len(p), nil
+}
+
+func (endlessObject) Seek(int64, int) (int64, error) { return 0, nil }
+func (endlessObject) Close() error                  { return nil }
+
+func TestRunCancelsStreams(t *testing.T) {
+	fs := newTestStore(t)
+	defer os.RemoveAll(fs.StoreDir)
+	require.NoError(t, fs.backend.Put("endless.txt", strings.NewReader("")))
+	fs.backend = endlessBackend{fs.backend}
+	fs.ShutdownTimeout = 5 * time.Second
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	fs.BindHTTPAddress = l.Addr().String()
+	l.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- fs.Run(ctx)
+	}()
+	var resp *http.Response
+	for i := 0; ; i++ {
+		resp, err = http.Get("http://" + fs.BindHTTPAddress + "/grep?pattern=y")
+		if err == nil {
+			break
+		}
+		require.True(t, i < 500, "server did not start: %v", err)
+		time.Sleep(10 * time.Millisecond)
+	}
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	// the search never ends, shutdown stops it rather than waiting for it
+	started := time.Now()
+	cancel()
+	require.NoError(t, <-done)
+	require.True(t, time.Since(started) < fs.ShutdownTimeout)
+}
@@ -0,0 +1,432 @@
+package filestore
+
+import (
+	"fmt"
+	"strings"
+)
+
+// stemmers reduce folded words to their stem, by language code
+var stemmers = map[string]func(string) string{
+	"en": stemEnglish,
+	"fr": stemFrench,
+	"de": stemGerman,
+	"es": stemSpanish,
+}
+
+// stemmer returns the stemmer of a language
+func stemmer(language string) (func(string) string, error) {
+	stem, ok := stemmers[language]
+	if !ok {
+		return nil, fmt.Errorf("no stemmer for language %q, expecting en, fr, de or es", language)
+	}
+	return stem, nil
+}
+
+// hasSuffix reports whether w ends with suffix
+func hasSuffix(w []rune, suffix string) bool {
+	s := []rune(suffix)
+	if len(s) > len(w) {
+		return false
+	}
+	for i := range s {
+		if w[len(w)-len(s)+i] != s[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// longestSuffix returns the longest of suffixes w ends with, or ""
+func longestSuffix(w []rune, suffixes ...string) string {
+	longest := ""
+	for _, suffix := range suffixes {
+		if len(suffix) > len(longest) && hasSuffix(w, suffix) {
+			longest = suffix
+		}
+	}
+	return longest
+}
+
+// replaceSuffix returns w with suffix replaced by replacement
+func replaceSuffix(w []rune, suffix, replacement string) []rune {
+	return append(w[:len(w)-len([]rune(suffix)):len(w)-len([]rune(suffix))], []rune(replacement)...)
+}
+
+// porter2 holds a word being stemmed by the English Snowball stemmer, also known as Porter2
+type porter2 struct {
+	w      []rune
+	r1, r2 int
+}
+
+// porter2Exceptions are the words the English stemmer maps to a fixed stem
+var porter2Exceptions = map[string]string{
+	"skis": "ski", "skies": "sky", "dying": "die", "lying": "lie", "tying": "tie",
+	"idly": "idl", "gently": "gentl", "ugly": "ugli", "early": "earli", "only": "onli", "singly": "singl",
+	"sky": "sky", "news": "news", "howe": "howe", "atlas": "atlas", "cosmos": "cosmos", "bias": "bias", "andes": "andes",
+}
+
+// porter2Invariants are the words the English stemmer leaves alone once step 1a is done
+var porter2Invariants = map[string]bool{
+	"inning": true, "outing": true, "canning": true, "herring": true,
+	"earring": true, "proceed": true, "exceed": true, "succeed": true,
+}
+
+// stemEnglish returns the stem of an English word with the Snowball English algorithm
+func stemEnglish(word string) string {
+	word = strings.Replace(word, "’", "'", -1)
+	if stem, ok := porter2Exceptions[word]; ok {
+		return stem
+	}
+	if len([]rune(word)) <= 2 {
+		return word
+	}
+	p := &porter2{w: []rune(strings.TrimPrefix(word, "'"))}
+	for i, r := range p.w {
+		if r == 'y' && (i == 0 || p.vowel(i-1)) {
+			p.w[i] = 'Y'
+		}
+	}
+	p.regions()
+	p.step0()
+	p.step1a()
+	if porter2Invariants[string(p.w)] {
+		return p.String()
+	}
+	p.step1b()
+	p.step1c()
+	p.step2()
+	p.step3()
+	p.step4()
+	p.step5()
+	return p.String()
+}
+
+// String returns the stem
+func (p *porter2) String() string {
+	return strings.Replace(string(p.w), "Y", "y", -1)
+}
+
+// vowel reports whether the letter at i is a vowel
+func (p *porter2) vowel(i int) bool {
+	switch p.w[i] {
+	case 'a', 'e', 'i', 'o', 'u', 'y':
+		return true
+	}
+	return false
+}
+
+// regions sets R1, the region after the first non-vowel following a vowel, and R2, the
+// same region inside R1
+func (p *porter2) regions() {
+	p.r1 = len(p.w)
+	for _, prefix := range []string{"gener", "commun", "arsen"} {
+		if strings.HasPrefix(string(p.w), prefix) {
+			p.r1 = len(prefix)
+		}
+	}
+	if p.r1 == len(p.w) {
+		p.r1 = p.regionAfter(0)
+	}
+	p.r2 = p.regionAfter(p.r1)
+}
+
+// regionAfter returns the start of the region after the first non-vowel following a vowel from start
+func (p *porter2) regionAfter(start int) int {
+	for i := start + 1; i < len(p.w); i++ {
+		if !p.vowel(i) && p.vowel(i-1) {
+			return i + 1
+		}
+	}
+	return len(p.w)
+}
+
+// in reports whether suffix lies in the region starting at region
+func (p *porter2) in(suffix string, region int) bool {
+	return len(p.w)-len([]rune(suffix)) >= region
+}
+
+// replace replaces suffix by replacement
+func (p *porter2) replace(suffix, replacement string) {
+	p.w = replaceSuffix(p.w, suffix, replacement)
+}
+
+// shortSyllableAt reports whether a short syllable ends at end: a vowel
+// followed by a non-vowel other than w, x or Y and preceded by a non-vowel, or a vowel
+// starting the word followed by a non-vowel
+func (p *porter2) shortSyllableAt(end int) bool {
+	switch {
+	case end == 1:
+		return p.vowel(0) && !p.vowel(1)
+	case end >= 2:
+		return !p.vowel(end-2) && p.vowel(end-1) && !p.vowel(end) && !strings.ContainsRune("wxY", p.w[end])
+	}
+	return false
+}
+
+// short reports whether the word is short: it ends with a short syllable and R1 is empty
+func (p *porter2) short() bool {
+	return p.r1 >= len(p.w) && p.shortSyllableAt(len(p.w)-1)
+}
+
+// step0 removes the possessive suffixes
+func (p *porter2) step0() {
+	if suffix := longestSuffix(p.w, "'", "'s", "'s'"); suffix != "" {
+		p.replace(suffix, "")
+	}
+}
+
+// step1a handles plurals
+func (p *porter2) step1a() {
+	switch suffix := longestSuffix(p.w, "sses", "ied", "ies", "s", "us", "ss"); suffix {
+	case "sses":
+		p.replace(suffix, "ss")
+	case "ied", "ies":
+		if len(p.w) > 4 {
+			p.replace(suffix, "i")
+		} else {
+			p.replace(suffix, "ie")
+		}
+	case "s":
+		for i := 0; i < len(p.w)-2; i++ {
+			if p.vowel(i) {
+				p.replace(suffix, "")
+				break
+			}
+		}
+	}
+}
+
+// step1b handles past tenses and gerunds
+func (p *porter2) step1b() {
+	suffix := longestSuffix(p.w, "eed", "eedly", "ed", "edly", "ing", "ingly")
+	switch suffix {
+	case "":
+		return
+	case "eed", "eedly":
+		if p.in(suffix, p.r1) {
+			p.replace(suffix, "ee")
+		}
+		return
+	}
+	stem := p.w[:len(p.w)-len(suffix)]
+	hasVowel := false
+	for i := range stem {
+		if p.vowel(i) {
+			hasVowel = true
+			break
+		}
+	}
+	if !hasVowel {
+		return
+	}
+	p.replace(suffix, "")
+	switch {
+	case hasSuffix(p.w, "at") || hasSuffix(p.w, "bl") || hasSuffix(p.w, "iz"):
+		p.w = append(p.w, 'e')
+	case longestSuffix(p.w, "bb", "dd", "ff", "gg", "mm", "nn", "pp", "rr", "tt") != "":
+		p.w = p.w[:len(p.w)-1]
+	case p.short():
+		p.w = append(p.w, 'e')
+	}
+}
+
+// step1c replaces a final y preceded by a non-vowel which is not the first letter by i
+func (p *porter2) step1c() {
+	n := len(p.w)
+	if n > 2 && (p.w[n-1] == 'y' || p.w[n-1] == 'Y') && !p.vowel(n-2) {
+		p.w[n-1] = 'i'
+	}
+}
+
+// step2Suffixes are the replacements of step 2
+var step2Suffixes = map[string]string{
+	"tional": "tion", "enci": "ence", "anci": "ance", "abli": "able", "entli": "ent",
+	"izer": "ize", "ization": "ize", "ational": "ate", "ation": "ate", "ator": "ate",
+	"alism": "al", "aliti": "al", "alli": "al", "fulness": "ful", "ousli": "ous", "ousness": "ous",
+	"iveness": "ive", "iviti": "ive", "biliti": "ble", "bli": "ble", "ogi": "og", "fulli": "ful",
+	"lessli": "less", "li": "",
+}
+
+// step3Suffixes are the replacements of step 3
+var step3Suffixes = map[string]string{
+	"tional": "tion", "ational": "ate", "alize": "al", "icate": "ic", "iciti": "ic",
+	"ical": "ic", "ful": "", "ness": "", "ative": "",
+}
+
+// suffixes returns the keys of m
+func suffixes(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+// step2 replaces derivational suffixes in R1
+func (p *porter2) step2() {
+	suffix := longestSuffix(p.w, suffixes(step2Suffixes)...)
+	if suffix == "" || !p.in(suffix, p.r1) {
+		return
+	}
+	stemEnd := len(p.w) - len([]rune(suffix))
+	switch suffix {
+	case "ogi":
+		if stemEnd == 0 || p.w[stemEnd-1] != 'l' {
+			return
+		}
+	case "li":
+		if stemEnd == 0 || !strings.ContainsRune("cdeghkmnrt", p.w[stemEnd-1]) {
+			return
+		}
+	}
+	p.replace(suffix, step2Suffixes[suffix])
+}
+
+// step3 replaces more derivational suffixes in R1
+func (p *porter2) step3() {
+	suffix := longestSuffix(p.w, suffixes(step3Suffixes)...)
+	if suffix == "" || !p.in(suffix, p.r1) || (suffix == "ative" && !p.in(suffix, p.r2)) {
+		return
+	}
+	p.replace(suffix, step3Suffixes[suffix])
+}
+
+// step4 removes the suffixes found in R2
+func (p *porter2) step4() {
+	suffix := longestSuffix(p.w, "al", "ance", "ence", "er", "ic", "able", "ible", "ant", "ement",
+		"ment", "ent", "ism", "ate", "iti", "ous", "ive", "ize", "ion")
+	if suffix == "" || !p.in(suffix, p.r2) {
+		return
+	}
+	if suffix == "ion" {
+		stemEnd := len(p.w) - 3
+		if stemEnd == 0 || (p.w[stemEnd-1] != 's' && p.w[stemEnd-1] != 't') {
+			return
+		}
+	}
+	p.replace(suffix, "")
+}
+
+// step5 removes a final e or l
+func (p *porter2) step5() {
+	n := len(p.w)
+	switch {
+	case hasSuffix(p.w, "e"):
+		if p.in("e", p.r2) || (p.in("e", p.r1) && !p.shortSyllableAt(n-2)) {
+			p.w = p.w[:n-1]
+		}
+	case hasSuffix(p.w, "ll") && p.in("l", p.r2):
+		p.w = p.w[:n-1]
+	}
+}
+
+// stripAccents replaces the accented vowels of w by their base letter
+func stripAccents(w []rune) []rune {
+	for i, r := range w {
+		switch r {
+		case 'à', 'á', 'â', 'ä':
+			w[i] = 'a'
+		case 'è', 'é', 'ê', 'ë':
+			w[i] = 'e'
+		case 'ì', 'í', 'î', 'ï':
+			w[i] = 'i'
+		case 'ò', 'ó', 'ô', 'ö':
+			w[i] = 'o'
+		case 'ù', 'ú', 'û', 'ü':
+			w[i] = 'u'
+		}
+	}
+	return w
+}
+
+// frenchSuffixes are the derivational suffixes removed by the French stemmer, longest first
+var frenchSuffixes = []string{"issement", "atrice", "ateur", "ation", "ement", "ment", "euse", "eux",
+	"isme", "iste", "ique", "ite", "ive", "if", "ance", "ence", "ante", "ant"}
+
+// stemFrench returns the stem of a French word: plurals, feminine forms and the common
+// derivational suffixes are removed, accents are stripped
+func stemFrench(word string) string {
+	w := stripAccents([]rune(strings.Replace(word, "’", "'", -1)))
+	if i := strings.LastIndexByte(string(w), '\''); i >= 0 {
+		// elided articles and pronouns: l'été, d'un, qu'il
+		w = []rune(string(w)[i+1:])
+	}
+	if len(w) < 4 {
+		return string(w)
+	}
+	switch {
+	case hasSuffix(w, "aux") && len(w) > 4:
+		w = replaceSuffix(w, "aux", "al")
+	case hasSuffix(w, "eux"):
+	case hasSuffix(w, "x") || hasSuffix(w, "s"):
+		w = w[:len(w)-1]
+	}
+	for _, suffix := range frenchSuffixes {
+		if hasSuffix(w, suffix) && len(w)-len([]rune(suffix)) >= 3 {
+			w = replaceSuffix(w, suffix, "")
+			break
+		}
+	}
+	for hasSuffix(w, "e") && len(w) > 3 {
+		w = w[:len(w)-1]
+	}
+	if hasSuffix(w, "er") && len(w) > 4 {
+		w = w[:len(w)-2]
+	}
+	if n := len(w); n > 3 && w[n-1] == w[n-2] {
+		w = w[:n-1]
+	}
+	return string(w)
+}
+
+// stemGerman returns the stem of a German word with the light stemmer of J. Savoy:
+// umlauts are stripped and inflectional endings removed
+func stemGerman(word string) string {
+	w := stripAccents([]rune(strings.Replace(word, "ß", "ss", -1)))
+	n := len(w)
+	switch {
+	case n > 5 && hasSuffix(w, "ern"):
+		n -= 3
+	case n > 4 && (hasSuffix(w, "em") || hasSuffix(w, "en") || hasSuffix(w, "er") || hasSuffix(w, "es")):
+		n -= 2
+	case n > 3 && hasSuffix(w, "e"):
+		n--
+	case n > 3 && hasSuffix(w, "s") && strings.ContainsRune("bdfghklmnrt", w[n-2]):
+		n--
+	}
+	w = w[:n]
+	switch {
+	case n > 5 && hasSuffix(w, "est"):
+		n -= 3
+	case n > 4 && (hasSuffix(w, "er") || hasSuffix(w, "en")):
+		n -= 2
+	case n > 4 && hasSuffix(w, "st") && strings.ContainsRune("bdfghklmnt", w[n-3]):
+		n -= 2
+	}
+	return string(w[:n])
+}
+
+// stemSpanish returns the stem of a Spanish word with the light stemmer of J. Savoy:
+// accents are stripped and gender and number endings removed
+func stemSpanish(word string) string {
+	w := stripAccents([]rune(word))
+	n := len(w)
+	if n < 5 {
+		return string(w)
+	}
+	switch w[n-1] {
+	case 'o', 'a', 'e':
+		n--
+	case 's':
+		switch {
+		case hasSuffix(w, "eses"):
+			n -= 2
+		case hasSuffix(w, "ces"):
+			w[n-3] = 'z'
+			n -= 2
+		case hasSuffix(w, "os") || hasSuffix(w, "as") || hasSuffix(w, "es"):
+			n -= 2
+		}
+	}
+	return string(w[:n])
+}
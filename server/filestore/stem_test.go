@@ -0,0 +1,84 @@
+package filestore
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestStemmers(t *testing.T) {
+	tests := map[string]map[string]string{
+		"en": {
+			"deploy": "deploy", "deploys": "deploy", "deployed": "deploy", "deploying": "deploy",
+			"caresses": "caress", "ponies": "poni", "ties": "tie", "agreed": "agre", "hopping": "hop",
+			"hoping": "hope", "filing": "file", "happy": "happi", "relational": "relat",
+			"generously": "generous", "communism": "communism", "hopefulness": "hope",
+			"replacement": "replac", "adoption": "adopt", "controll": "control", "dying": "die",
+			"news": "news", "it's": "it", "ab": "ab",
+		},
+		"fr": {
+			"chevaux": "cheval", "rapidement": "rapid", "manger": "mang", "mangée": "mang",
+			"l'été": "ete", "chanteuse": "chant", "heureux": "heur", "heureuse": "heur",
+		},
+		"de": {"häuser": "haus", "kindern": "kind", "schnellsten": "schnell", "tages": "tag"},
+		"es": {"luces": "luz", "gatos": "gat", "gatas": "gat", "meses": "mes", "canción": "cancion"},
+	}
+	for language, words := range tests {
+		stem, err := stemmer(language)
+		require.NoError(t, err)
+		for word, want := range words {
+			require.Equal(t, want, stem(word), language+" "+word)
+		}
+	}
+	_, err := stemmer("xx")
+	require.Error(t, err)
+}
+
+func TestStatsStemming(t *testing.T) {
+	fs := newTestStore(t)
+	defer os.RemoveAll(fs.StoreDir)
+	w := serve(fs.Add, multipartRequest(t, "POST", "/add", map[string]string{
+		"a.txt": "Deploy deploys deployed, deploying the deployed service. The services",
+	}))
+	require.Equal(t, http.StatusOK, w.Code)
+
+	w = serve(fs.FreqWords, httptest.NewRequest("GET", "/freqwords?limit=2&order=dsc&stem=en", nil))
+	require.Equal(t, "  5 deploy (deployed)\n  2 servic (service)\n", w.Body.String())
+	w = serve(fs.FreqWords, httptest.NewRequest("GET", "/freqwords?limit=1&order=dsc", nil))
+	require.Equal(t, "  2 deployed\n", w.Body.String())
+	w = serve(fs.CountWords, httptest.NewRequest("GET", "/countwords?stem=en&stopwords=en", nil))
+	require.Equal(t, "  7\n", w.Body.String())
+	w = serve(fs.CountWords, httptest.NewRequest("GET", "/countwords?stem=xx", nil))
+	require.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = serve(fs.V2, httptest.NewRequest("GET", "/v2/stats/words?limit=1&stem=en", nil))
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	stats := &WordStats{}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), stats))
+	require.Equal(t, &WordStats{
+		Count:      9,
+		Words:      []wordFreq{{Word: "deploy", Count: 5, Form: "deployed"}},
+		textCounts: textCounts{Bytes: 69, Chars: 69, MaxLineLength: 69},
+	}, stats)
+}
+
+func TestStatsStemmingStopwords(t *testing.T) {
+	fs := newTestStore(t)
+	defer os.RemoveAll(fs.StoreDir)
+	w := serve(fs.Add, multipartRequest(t, "POST", "/add", map[string]string{
+		"a.txt": "she owned three cans and owned wills",
+	}))
+	require.Equal(t, http.StatusOK, w.Code)
+
+	// stopwords are removed before stemming, words sharing their stem are kept
+	w = serve(fs.FreqWords, httptest.NewRequest("GET", "/freqwords?limit=10&order=dsc&stem=en&stopwords=en", nil))
+	require.Equal(t, "  2 own (owned)\n  1 can (cans)\n  1 three (three)\n  1 will (wills)\n", w.Body.String())
+	w = serve(fs.CountWords, httptest.NewRequest("GET", "/countwords?stem=en&stopwords=en", nil))
+	require.Equal(t, "  5\n", w.Body.String())
+	w = serve(fs.FreqNgrams, httptest.NewRequest("GET", "/freqngrams?n=2&stem=en&stopwords=en", nil))
+	require.Equal(t, "  1 own three\n  1 own will\n  1 three can\n", w.Body.String())
+}
@@ -0,0 +1,211 @@
+package filestore
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// ErrCodeReservedList is reported when a custom stopword list is given the name of a built-in list
+const ErrCodeReservedList = "reserved_list"
+
+// maxStopwordsSize bounds the size of custom stopword lists
+const maxStopwordsSize = 1 << 20
+
+// builtinStopwords are the stopword lists shipped with the server, by language code
+var builtinStopwords = map[string]string{
+	"en": `a about above after again against all am an and any are as at be because been before
+		being below between both but by can could did do does doing down during each few for from
+		further had has have having he her here hers herself him himself his how i if in into is it
+		its itself just me more most my myself no nor not now of off on once only or other our ours
+		ourselves out over own same she should so some such than that the their theirs them
+		themselves then there these they this those through to too under until up very was we were
+		what when where which while who whom why will with would you your yours yourself yourselves
+		don't i'm it's isn't aren't wasn't weren't doesn't didn't can't won't`,
+	"fr": `a ai aie au aux avec avait avez avons c ce ceci cela ces cet cette d dans de des du elle
+		elles en es est et étaient était été être eu eux il ils j je l la le les leur leurs lui m ma
+		mais me même mes moi mon n ne nos notre nous on ont ou où par pas pour qu que qui s sa sans se
+		ses si son sont sur t ta te tes toi ton tu un une vos votre vous y c'est d'un d'une l'on
+		qu'il n'est`,
+	"de": `aber alle allem allen aller alles als also am an ander andere anderem anderen anderer
+		anderes auch auf aus bei bin bis bist da damit dann das dass dem den der des dich die dir du
+		durch ein eine einem einen einer eines er es etwas euch euer für gegen hat hatte hätte ich
+		ihm ihn ihnen ihr ihre im in ins ist ja jede jedem jeden jeder jedes kann kein keine man mich
+		mir mit muss nach nicht nichts noch nun nur ob oder ohne sehr sein seine sich sie sind so
+		über um und uns unser unter vom von vor war waren was weil wenn wer wie wir wird wo zu zum zur`,
+	"es": `a al algo algunas algunos ante antes como con contra cual cuando de del desde donde
+		durante e el ella ellas ellos en entre era es esa esas ese eso esos esta estaba estas este
+		esto estos fue fueron ha hay la las le les lo los más me mi mis mucho muy nada ni no nos
+		nosotros o os otra otras otro otros para pero poco por porque que quien se sea ser si sí sin
+		sobre son su sus también te tiene todo todos tu tus un una uno unos y ya yo`,
+}
+
+// stopwordListName is the pattern of custom stopword list names
+var stopwordListName = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,63}$`)
+
+// stopwordsPath returns the backend name of a custom stopword list
+func stopwordsPath(name string) string {
+	return metaPath("stopwords", name)
+}
+
+// parseStopwords returns the words of a list, separated by spaces or newlines. Lines
+// starting with # are comments.
+func parseStopwords(r io.Reader) ([]string, error) {
+	var words []string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+		words = append(words, strings.Fields(line)...)
+	}
+	return words, scanner.Err()
+}
+
+// stopwordList returns the words of a built-in or custom stopword list
+func (fs *FileStore) stopwordList(name string) ([]string, error) {
+	if list, ok := builtinStopwords[name]; ok {
+		return strings.Fields(list), nil
+	}
+	if !stopwordListName.MatchString(name) {
+		return nil, fmt.Errorf("invalid stopword list name %q", name)
+	}
+	obj, err := fs.backend.Get(stopwordsPath(name))
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("unknown stopword list %q", name)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer obj.Close()
+	return parseStopwords(obj)
+}
+
+// stopwordsParam returns the stopwords of a statistics request as produced by a before
+// stemming, the stopwords parameter being a comma separated list of built-in or custom
+// list names
+func (fs *FileStore) stopwordsParam(r *http.Request, a *analyzer) (map[string]bool, error) {
+	param := r.FormValue("stopwords")
+	if param == "" {
+		return nil, nil
+	}
+	stopwords := make(map[string]bool)
+	for _, name := range strings.Split(param, ",") {
+		words, err := fs.stopwordList(strings.TrimSpace(name))
+		if err != nil {
+			return nil, err
+		}
+		for _, word := range words {
+			for _, form := range a.surfaceForms(word) {
+				stopwords[form] = true
+			}
+		}
+	}
+	return stopwords, nil
+}
+
+// withoutStopwords returns words and their total count without the stopwords
+func withoutStopwords(words map[string]int, count int, stopwords map[string]bool) (map[string]int, int) {
+	if len(stopwords) == 0 {
+		return words, count
+	}
+	kept := make(map[string]int, len(words))
+	for word, n := range words {
+		if stopwords[word] {
+			count -= n
+			continue
+		}
+		kept[word] = n
+	}
+	return kept, count
+}
+
+// stopwords serves the custom stopword lists under /v2/stopwords:
+//
+//	GET /v2/stopwords            lists the names of the built-in and custom lists
+//	GET, PUT, DELETE /v2/stopwords/x  reads, writes or removes custom list x
+//
+// Lists are sent as words separated by spaces or newlines, lines starting with # are comments.
+func (req *v2Request) stopwords(path string) {
+	if path == "" {
+		if req.r.Method != http.MethodGet && req.r.Method != http.MethodHead {
+			req.methodNotAllowed(http.MethodGet, http.MethodHead)
+			return
+		}
+		req.listStopwords()
+		return
+	}
+	name := strings.TrimPrefix(path, "/")
+	if _, ok := builtinStopwords[name]; ok && req.r.Method != http.MethodGet && req.r.Method != http.MethodHead {
+		req.fail(http.StatusBadRequest, ErrCodeReservedList, fmt.Errorf("%s is a built-in stopword list", name))
+		return
+	}
+	if _, ok := builtinStopwords[name]; !ok && !stopwordListName.MatchString(name) {
+		req.fail(http.StatusBadRequest, ErrCodeInvalidRequest, fmt.Errorf("invalid stopword list name %q, expecting lowercase letters, digits, - and _", name))
+		return
+	}
+	switch req.r.Method {
+	case http.MethodGet, http.MethodHead:
+		words, err := req.fs.stopwordList(name)
+		if err != nil {
+			req.fail(http.StatusNotFound, ErrCodeNotFound, err)
+			return
+		}
+		req.writeJSON(http.StatusOK, words)
+	case http.MethodPut:
+		b, err := ioutil.ReadAll(io.LimitReader(req.r.Body, maxStopwordsSize+1))
+		if err != nil {
+			req.fail(http.StatusBadRequest, ErrCodeInvalidRequest, err)
+			return
+		}
+		if len(b) > maxStopwordsSize {
+			req.fail(http.StatusRequestEntityTooLarge, ErrCodeInvalidRequest, fmt.Errorf("stopword lists are limited to %d bytes", maxStopwordsSize))
+			return
+		}
+		words, err := parseStopwords(bytes.NewReader(b))
+		if err != nil {
+			req.failWith(err)
+			return
+		}
+		req.fs.Logger.Infof("Writing stopword list %s of %d words", name, len(words))
+		if err := req.fs.backend.Put(stopwordsPath(name), strings.NewReader(strings.Join(words, "\n"))); err != nil {
+			req.failWith(err)
+			return
+		}
+		req.writeJSON(http.StatusOK, words)
+	case http.MethodDelete:
+		if err := req.fs.backend.Delete(stopwordsPath(name)); err != nil {
+			req.failWith(err)
+			return
+		}
+		req.w.WriteHeader(http.StatusNoContent)
+	default:
+		req.methodNotAllowed(http.MethodGet, http.MethodHead, http.MethodPut, http.MethodDelete)
+	}
+}
+
+// listStopwords writes the names of the built-in and custom stopword lists
+func (req *v2Request) listStopwords() {
+	var names []string
+	for name := range builtinStopwords {
+		names = append(names, name)
+	}
+	files, err := req.fs.backend.List(metaPath("stopwords"))
+	if err != nil {
+		req.failWith(err)
+		return
+	}
+	for _, fi := range files {
+		names = append(names, fi.Name())
+	}
+	sort.Strings(names)
+	req.writeJSON(http.StatusOK, names)
+}
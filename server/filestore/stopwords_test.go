@@ -0,0 +1,60 @@
+package filestore
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestStopwords(t *testing.T) {
+	fs := newTestStore(t)
+	defer os.RemoveAll(fs.StoreDir)
+	w := serve(fs.Add, multipartRequest(t, "POST", "/add", map[string]string{
+		"a.txt": "The cat and the dog. Le chat et le chien, the end",
+	}))
+	require.Equal(t, http.StatusOK, w.Code)
+
+	w = serve(fs.FreqWords, httptest.NewRequest("GET", "/freqwords?limit=2&order=dsc&stopwords=en", nil))
+	require.Equal(t, "  2 le\n  1 cat\n", w.Body.String())
+	w = serve(fs.FreqWords, httptest.NewRequest("GET", "/freqwords?limit=2&order=dsc&stopwords=en,fr", nil))
+	require.Equal(t, "  1 cat\n  1 chat\n", w.Body.String())
+	w = serve(fs.CountWords, httptest.NewRequest("GET", "/countwords?stopwords=en,fr", nil))
+	require.Equal(t, "  5\n", w.Body.String())
+	w = serve(fs.FreqWords, httptest.NewRequest("GET", "/freqwords?limit=2&order=dsc&stopwords=pets", nil))
+	require.Equal(t, http.StatusBadRequest, w.Code)
+
+	// custom lists are uploaded through the v2 API and analyzed like the files
+	w = serve(fs.V2, httptest.NewRequest("PUT", "/v2/stopwords/pets", strings.NewReader("# animals\nCat Dog\nchat chien\n")))
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	w = serve(fs.V2, httptest.NewRequest("GET", "/v2/stats/words?limit=1&stopwords=en,fr,pets", nil))
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	stats := &WordStats{}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), stats))
+	require.Equal(t, &WordStats{Count: 1, Words: []wordFreq{{Word: "end", Count: 1}}, textCounts: textCounts{Bytes: 49, Chars: 49, MaxLineLength: 49}}, stats)
+
+	w = serve(fs.V2, httptest.NewRequest("GET", "/v2/stopwords", nil))
+	var names []string
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &names))
+	require.Equal(t, []string{"de", "en", "es", "fr", "pets"}, names)
+	w = serve(fs.V2, httptest.NewRequest("GET", "/v2/stopwords/pets", nil))
+	require.Equal(t, "[\"Cat\",\"Dog\",\"chat\",\"chien\"]\n", w.Body.String())
+
+	for _, tt := range []struct {
+		method, target string
+		status         int
+	}{
+		{"PUT", "/v2/stopwords/en", http.StatusBadRequest},
+		{"PUT", "/v2/stopwords/Bad.Name", http.StatusBadRequest},
+		{"GET", "/v2/stopwords/missing", http.StatusNotFound},
+		{"DELETE", "/v2/stopwords/pets", http.StatusNoContent},
+		{"DELETE", "/v2/stopwords/pets", http.StatusNotFound},
+	} {
+		w = serve(fs.V2, httptest.NewRequest(tt.method, tt.target, strings.NewReader("x")))
+		require.Equal(t, tt.status, w.Code, tt.method+" "+tt.target)
+	}
+}
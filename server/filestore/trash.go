@@ -0,0 +1,280 @@
+package filestore
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// trashPurgeInterval is the interval between purges of expired trash entries
+const trashPurgeInterval = time.Hour
+
+var (
+	trashFilesDir = metaPath("trash", "files")
+	trashMetaDir  = metaPath("trash", "meta")
+	// trashVersionsDir holds a dir of versions per trash entry
+	trashVersionsDir = metaPath("trash", "versions")
+)
+
+// trashEntry is a removed file waiting in the trash
+type trashEntry struct {
+	ID      string
+	Name    string
+	Size    int64
+	Deleted time.Time
+}
+
+// trashID returns the id of name trashed at t, ids sort by deletion time. They are made of
+// the time and a hash of the name, so they stay short whatever the length of the name.
+func trashID(name string, t time.Time) string {
+	sum := sha256.Sum256([]byte(name))
+	return fmt.Sprintf("%019d-%x", t.UnixNano(), sum[:8])
+}
+
+// parseTrashID returns the deletion time of a trash id, along with the file name the ids of
+// previous releases ended with
+func parseTrashID(id string) (time.Time, string, error) {
+	parts := strings.SplitN(id, "-", 2)
+	if len(parts) != 2 {
+		return time.Time{}, "", fmt.Errorf("invalid trash id %q", id)
+	}
+	nanos, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return time.Time{}, "", fmt.Errorf("invalid trash id %q", id)
+	}
+	return time.Unix(0, nanos), parts[1], nil
+}
+
+// loadTrashMetadata reads the metadata record of a trash entry
+func (fs *FileStore) loadTrashMetadata(id string) (*Metadata, error) {
+	obj, err := fs.backend.Get(path.Join(trashMetaDir, id))
+	if err != nil {
+		return nil, err
+	}
+	defer obj.Close()
+	meta := &Metadata{}
+	if err := json.NewDecoder(obj).Decode(meta); err != nil {
+		return nil, err
+	}
+	return meta, nil
+}
+
+// listTrash returns the trash entries, oldest first. The name of an entry is read from its
+// metadata record, entries of previous releases without one are named after their id.
+func (fs *FileStore) listTrash() ([]trashEntry, error) {
+	files, err := fs.backend.List(trashFilesDir)
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]trashEntry, 0, len(files))
+	for _, fi := range files {
+		deleted, name, err := parseTrashID(fi.Name())
+		if err != nil {
+			continue
+		}
+		meta, err := fs.loadTrashMetadata(fi.Name())
+		if err == nil {
+			name = meta.Name
+		} else if !os.IsNotExist(err) {
+			fs.Logger.Warnf("Could not read metadata of trash entry %s: %v", fi.Name(), err)
+		}
+		entries = append(entries, trashEntry{ID: fi.Name(), Name: name, Size: fi.Size(), Deleted: deleted})
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].ID < entries[j].ID
+	})
+	return entries, nil
+}
+
+// trash moves a file, its metadata record and its versions to the trash. The record, built
+// first for files which have none, keeps the name of the file.
+func (fs *FileStore) trash(name string) error {
+	if _, err := fs.metadata(name); err != nil {
+		return err
+	}
+	id := trashID(name, time.Now())
+	if err := fs.backend.Rename(name, path.Join(trashFilesDir, id)); err != nil {
+		return err
+	}
+	if err := fs.backend.Rename(metadataPath(name), path.Join(trashMetaDir, id)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return fs.moveVersions(versionsDir(name), path.Join(trashVersionsDir, id))
+}
+
+// untrash moves the most recently trashed file named name back to the store under its
+// original name, which it returns. Its versions follow the versions the name got meanwhile.
+func (fs *FileStore) untrash(name string) (string, error) {
+	entries, err := fs.listTrash()
+	if err != nil {
+		return "", err
+	}
+	for i := len(entries) - 1; i >= 0; i-- {
+		if !fs.sameName(entries[i].Name, name) {
+			continue
+		}
+		restored := entries[i].Name
+		if err := fs.backend.Rename(path.Join(trashFilesDir, entries[i].ID), restored); err != nil {
+			return "", err
+		}
+		err := fs.backend.Rename(path.Join(trashMetaDir, entries[i].ID), metadataPath(restored))
+		if err != nil && !os.IsNotExist(err) {
+			return "", err
+		}
+		if err := fs.moveVersions(path.Join(trashVersionsDir, entries[i].ID), versionsDir(restored)); err != nil {
+			return "", err
+		}
+		return restored, nil
+	}
+	return "", &os.PathError{Op: "restore", Path: name, Err: os.ErrNotExist}
+}
+
+// purgeTrash deletes the trash entries purge selects, it returns the number of purged entries
+func (fs *FileStore) purgeTrash(purge func(trashEntry) bool) (int, error) {
+	entries, err := fs.listTrash()
+	if err != nil {
+		return 0, err
+	}
+	purged := 0
+	for _, entry := range entries {
+		if !purge(entry) {
+			continue
+		}
+		if err := fs.backend.Delete(path.Join(trashFilesDir, entry.ID)); err != nil && !os.IsNotExist(err) {
+			return purged, err
+		}
+		if err := fs.backend.Delete(path.Join(trashMetaDir, entry.ID)); err != nil && !os.IsNotExist(err) {
+			return purged, err
+		}
+		versions, err := fs.listVersionsIn(path.Join(trashVersionsDir, entry.ID))
+		if err != nil {
+			return purged, err
+		}
+		for _, v := range versions {
+			err := fs.backend.Delete(path.Join(trashVersionsDir, entry.ID, strconv.Itoa(v.Version)))
+			if err != nil && !os.IsNotExist(err) {
+				return purged, err
+			}
+		}
+		purged++
+	}
+	return purged, nil
+}
+
+// purgeTrashOlderThan deletes the trash entries removed more than age ago
+func (fs *FileStore) purgeTrashOlderThan(age time.Duration) (int, error) {
+	deadline := time.Now().Add(-age)
+	return fs.purgeTrash(func(entry trashEntry) bool {
+		return entry.Deleted.Before(deadline)
+	})
+}
+
+// purgeExpiredTrash periodically deletes the trash entries older than TrashMaxAge until ctx is done
+func (fs *FileStore) purgeExpiredTrash(ctx context.Context) {
+	ticker := time.NewTicker(trashPurgeInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		purged, err := fs.purgeTrashOlderThan(fs.TrashMaxAge)
+		if err != nil {
+			fs.Logger.Errorf("Could not purge expired trash entries: %v", err)
+			continue
+		}
+		fs.Logger.Infof("Purged %d expired trash entries", purged)
+	}
+}
+
+// Trash lists the files in the trash
+func (fs *FileStore) Trash(w http.ResponseWriter, r *http.Request) {
+	fs.Logger.Infof("Listing files in the trash")
+	entries, err := fs.listTrash()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	for _, entry := range entries {
+		_, err = io.WriteString(w, fmt.Sprintf("%s %10d %s\n", entry.Deleted.Format(time.RFC3339), entry.Size, entry.Name))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+}
+
+// Undelete moves the most recently removed file of a name back to the store, it only
+// accepts POST requests
+func (fs *FileStore) Undelete(w http.ResponseWriter, r *http.Request) {
+	if !allowMethods(w, r, http.MethodPost) {
+		return
+	}
+	fileName, err := fs.checkName(r.FormValue("file"))
+	if err != nil {
+		http.Error(w, err.Error(), nameStatus(err))
+		return
+	}
+	fs.Logger.Infof("Restoring file %s from the trash", fileName)
+	fileName, unlock := fs.lockName(fileName)
+	defer unlock()
+	if _, err := fs.backend.Stat(fileName); !os.IsNotExist(err) {
+		http.Error(w, "File already exist", http.StatusConflict)
+		return
+	}
+	fileName, err = fs.untrash(fileName)
+	if os.IsNotExist(err) {
+		http.Error(w, "File is not in the trash", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := fs.reindex(fileName); err != nil {
+		fs.Logger.Errorf("Could not index %s: %v", fileName, err)
+	}
+	if err := fs.index.Save(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+// PurgeTrash deletes for good the files in the trash, or only the entries of a file when one
+// is given. It only accepts POST and DELETE requests, so links followed by crawlers dont purge.
+func (fs *FileStore) PurgeTrash(w http.ResponseWriter, r *http.Request) {
+	if !allowMethods(w, r, http.MethodPost, http.MethodDelete) {
+		return
+	}
+	fileName := r.FormValue("file")
+	if fileName != "" {
+		var err error
+		if fileName, err = fs.checkName(fileName); err != nil {
+			http.Error(w, err.Error(), nameStatus(err))
+			return
+		}
+	}
+	fs.Logger.Infof("Purging the trash")
+	purged, err := fs.purgeTrash(func(entry trashEntry) bool {
+		return fileName == "" || fs.sameName(entry.Name, fileName)
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	_, err = io.WriteString(w, fmt.Sprintf("%d\n", purged))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
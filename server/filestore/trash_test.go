@@ -0,0 +1,139 @@
+package filestore
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTrash(t *testing.T) {
+	fs := newTestStore(t)
+	defer os.RemoveAll(fs.StoreDir)
+
+	w := serve(fs.Add, multipartRequest(t, "POST", "/add?label=team=ops", map[string]string{"a.txt": "foo bar", "b.txt": "baz"}))
+	require.Equal(t, http.StatusOK, w.Code)
+	w = serve(fs.Remove, httptest.NewRequest("POST", "/remove?file=a.txt", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+
+	// removed files leave the listing and the word statistics
+	w = serve(fs.List, httptest.NewRequest("GET", "/list", nil))
+	require.Equal(t, "b.txt\n", w.Body.String())
+	w = serve(fs.CountWords, httptest.NewRequest("GET", "/countwords", nil))
+	require.Equal(t, "  1\n", w.Body.String())
+	w = serve(fs.Trash, httptest.NewRequest("GET", "/trash", nil))
+	require.True(t, strings.HasSuffix(w.Body.String(), " a.txt\n"), w.Body.String())
+
+	w = serve(fs.Undelete, httptest.NewRequest("POST", "/trash/restore?file=a.txt", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	w = serve(fs.CountWords, httptest.NewRequest("GET", "/countwords", nil))
+	require.Equal(t, "  3\n", w.Body.String())
+	meta, err := fs.metadata("a.txt")
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"team": "ops"}, meta.Labels)
+	w = serve(fs.Undelete, httptest.NewRequest("POST", "/trash/restore?file=a.txt", nil))
+	require.Equal(t, http.StatusConflict, w.Code)
+
+	w = serve(fs.Remove, httptest.NewRequest("POST", "/remove?file=a.txt", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	w = serve(fs.Remove, httptest.NewRequest("POST", "/remove?file=b.txt", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	// state changes are refused to GET requests
+	w = serve(fs.PurgeTrash, httptest.NewRequest("GET", "/trash/purge", nil))
+	require.Equal(t, http.StatusMethodNotAllowed, w.Code)
+	require.Equal(t, "POST, DELETE", w.Header().Get("Allow"))
+	w = serve(fs.Undelete, httptest.NewRequest("GET", "/trash/restore?file=a.txt", nil))
+	require.Equal(t, http.StatusMethodNotAllowed, w.Code)
+	require.Equal(t, "POST", w.Header().Get("Allow"))
+	w = serve(fs.PurgeTrash, httptest.NewRequest("DELETE", "/trash/purge?file=a.txt", nil))
+	require.Equal(t, "1\n", w.Body.String())
+	w = serve(fs.Undelete, httptest.NewRequest("POST", "/trash/restore?file=a.txt", nil))
+	require.Equal(t, http.StatusNotFound, w.Code)
+	w = serve(fs.PurgeTrash, httptest.NewRequest("POST", "/trash/purge", nil))
+	require.Equal(t, "1\n", w.Body.String())
+	entries, err := fs.listTrash()
+	require.NoError(t, err)
+	require.Empty(t, entries)
+}
+
+func TestTrashExpiry(t *testing.T) {
+	fs := newTestStore(t)
+	defer os.RemoveAll(fs.StoreDir)
+	w := serve(fs.Add, multipartRequest(t, "POST", "/add", map[string]string{"a.txt": "foo"}))
+	require.Equal(t, http.StatusOK, w.Code)
+	require.NoError(t, fs.trash("a.txt"))
+
+	purged, err := fs.purgeTrashOlderThan(time.Hour)
+	require.NoError(t, err)
+	require.Equal(t, 0, purged)
+	time.Sleep(10 * time.Millisecond)
+	purged, err = fs.purgeTrashOlderThan(time.Millisecond)
+	require.NoError(t, err)
+	require.Equal(t, 1, purged)
+}
+
+func TestTrashVersions(t *testing.T) {
+	fs := newTestStore(t)
+	defer os.RemoveAll(fs.StoreDir)
+	for _, content := range []string{"one", "two", "three"} {
+		w := serve(fs.Update, multipartRequest(t, "POST", "/update", map[string]string{"a.txt": content}))
+		require.Equal(t, http.StatusOK, w.Code)
+	}
+
+	// versions follow the file to the trash and back
+	w := serve(fs.Remove, httptest.NewRequest("POST", "/remove?file=a.txt", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	w = serve(fs.Versions, httptest.NewRequest("GET", "/versions?file=a.txt", nil))
+	require.Equal(t, http.StatusNotFound, w.Code)
+	w = serve(fs.Undelete, httptest.NewRequest("POST", "/trash/restore?file=a.txt", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	w = serve(fs.Get, httptest.NewRequest("GET", "/get?file=a.txt&version=2", nil))
+	require.Equal(t, "two", w.Body.String())
+
+	// purged entries take their versions along
+	w = serve(fs.Remove, httptest.NewRequest("POST", "/remove?file=a.txt", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	entries, err := fs.listTrash()
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	w = serve(fs.PurgeTrash, httptest.NewRequest("POST", "/trash/purge", nil))
+	require.Equal(t, "1\n", w.Body.String())
+	versions, err := fs.listVersionsIn(path.Join(trashVersionsDir, entries[0].ID))
+	require.NoError(t, err)
+	require.Empty(t, versions)
+}
+
+func TestTrashLongName(t *testing.T) {
+	fs := newTestStore(t)
+	defer os.RemoveAll(fs.StoreDir)
+	name := strings.Repeat("a", DefaultMaxNameLength-len(".txt")) + ".txt"
+	w := serve(fs.Add, multipartRequest(t, "POST", "/add", map[string]string{name: "foo"}))
+	require.Equal(t, http.StatusOK, w.Code)
+
+	w = serve(fs.Remove, httptest.NewRequest("POST", "/remove?file="+name, nil))
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	entries, err := fs.listTrash()
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	require.Equal(t, name, entries[0].Name)
+	w = serve(fs.Undelete, httptest.NewRequest("POST", "/trash/restore?file="+name, nil))
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	w = serve(fs.Get, httptest.NewRequest("GET", "/get?file="+name, nil))
+	require.Equal(t, "foo", w.Body.String())
+}
+
+func TestTrashLegacyIDs(t *testing.T) {
+	fs := newTestStore(t)
+	defer os.RemoveAll(fs.StoreDir)
+	// entries of previous releases are named by their id and have no metadata record
+	require.NoError(t, fs.backend.Put(path.Join(trashFilesDir, "0000000000000000001-a.txt"), strings.NewReader("foo")))
+	w := serve(fs.Undelete, httptest.NewRequest("POST", "/trash/restore?file=a.txt", nil))
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	w = serve(fs.Get, httptest.NewRequest("GET", "/get?file=a.txt", nil))
+	require.Equal(t, "foo", w.Body.String())
+}
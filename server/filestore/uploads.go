@@ -0,0 +1,446 @@
+package filestore
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Error codes reported by the resumable upload endpoints
+const (
+	ErrCodeAlreadyExists    = "already_exists"
+	ErrCodeOffsetMismatch   = "offset_mismatch"
+	ErrCodeChecksumMismatch = "checksum_mismatch"
+)
+
+// UploadOffsetHeader carries the number of bytes an upload session has received
+const UploadOffsetHeader = "Upload-Offset"
+
+// uploadPurgeInterval is the interval between purges of expired upload sessions
+const uploadPurgeInterval = time.Hour
+
+var (
+	uploadSessionsDir = metaPath("uploads", "sessions")
+	uploadChunksDir   = metaPath("uploads", "chunks")
+	// uploadPendingDir holds the chunks being received, before they are checked against
+	// the session and moved to its chunks
+	uploadPendingDir = metaPath("uploads", "pending")
+)
+
+// Upload is a resumable upload session. Chunks are stored as separate objects named
+// after their offset until the session is completed into a file.
+type Upload struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	// Size is the announced size of the file, -1 when unknown
+	Size int64 `json:"size"`
+	// Offset is the number of bytes received so far
+	Offset   int64             `json:"offset"`
+	Replace  bool              `json:"replace"`
+	Uploader string            `json:"uploader,omitempty"`
+	Labels   map[string]string `json:"labels,omitempty"`
+	Created  time.Time         `json:"created"`
+	Updated  time.Time         `json:"updated"`
+	Expires  time.Time         `json:"expires"`
+}
+
+// uploadSessionPath returns the backend name of the record of an upload session
+func uploadSessionPath(id string) string {
+	return path.Join(uploadSessionsDir, id+".json")
+}
+
+// uploadChunkPath returns the backend name of the chunk of an upload session starting at offset
+func uploadChunkPath(id string, offset int64) string {
+	return path.Join(uploadChunksDir, id, fmt.Sprintf("%020d", offset))
+}
+
+// newUploadID returns a random upload session id
+func newUploadID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// validUploadID reports whether id can be an upload session id
+func validUploadID(id string) bool {
+	if len(id) != 32 {
+		return false
+	}
+	_, err := hex.DecodeString(id)
+	return err == nil
+}
+
+// loadUpload reads the record of an upload session, expired sessions are not found
+func (fs *FileStore) loadUpload(id string) (*Upload, error) {
+	obj, err := fs.backend.Get(uploadSessionPath(id))
+	if err != nil {
+		return nil, err
+	}
+	defer obj.Close()
+	u := &Upload{}
+	if err := json.NewDecoder(obj).Decode(u); err != nil {
+		return nil, err
+	}
+	if time.Now().After(u.Expires) {
+		return nil, &os.PathError{Op: "open", Path: id, Err: os.ErrNotExist}
+	}
+	return u, nil
+}
+
+// saveUpload writes the record of an upload session and pushes back its expiry
+func (fs *FileStore) saveUpload(u *Upload) error {
+	u.Updated = time.Now()
+	u.Expires = u.Updated.Add(fs.UploadMaxAge)
+	b, err := json.Marshal(u)
+	if err != nil {
+		return err
+	}
+	return fs.backend.Put(uploadSessionPath(u.ID), bytes.NewReader(b))
+}
+
+// deleteUpload deletes the chunks and the record of an upload session
+func (fs *FileStore) deleteUpload(id string) error {
+	chunks, err := fs.backend.List(path.Join(uploadChunksDir, id))
+	if err != nil {
+		return err
+	}
+	for _, chunk := range chunks {
+		if err := fs.backend.Delete(path.Join(uploadChunksDir, id, chunk.Name())); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	if err := fs.backend.Delete(uploadSessionPath(id)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// uploadContent returns a reader of the chunks of an upload session in order, the caller
+// closes the returned objects
+func (fs *FileStore) uploadContent(u *Upload) (io.Reader, []Object, error) {
+	chunks, err := fs.backend.List(path.Join(uploadChunksDir, u.ID))
+	if err != nil {
+		return nil, nil, err
+	}
+	var objects []Object
+	var readers []io.Reader
+	for _, chunk := range chunks {
+		obj, err := fs.backend.Get(path.Join(uploadChunksDir, u.ID, chunk.Name()))
+		if err != nil {
+			for _, o := range objects {
+				o.Close()
+			}
+			return nil, nil, err
+		}
+		objects = append(objects, obj)
+		readers = append(readers, obj)
+	}
+	return io.MultiReader(readers...), objects, nil
+}
+
+// closeObjects closes the objects returned by uploadContent
+func closeObjects(objects []Object) {
+	for _, obj := range objects {
+		obj.Close()
+	}
+}
+
+// purgeUploadsOlderThan deletes the upload sessions not written to for more than age,
+// it returns the number of purged sessions. Pending chunks left by interrupted requests
+// are deleted too.
+func (fs *FileStore) purgeUploadsOlderThan(age time.Duration) (int, error) {
+	deadline := time.Now().Add(-age)
+	pending, err := fs.backend.List(uploadPendingDir)
+	if err != nil {
+		return 0, err
+	}
+	for _, fi := range pending {
+		if fi.ModTime().Before(deadline) {
+			fs.backend.Delete(path.Join(uploadPendingDir, fi.Name())) // nolint: errcheck
+		}
+	}
+	sessions, err := fs.backend.List(uploadSessionsDir)
+	if err != nil {
+		return 0, err
+	}
+	purged := 0
+	for _, fi := range sessions {
+		if fi.ModTime().After(deadline) {
+			continue
+		}
+		if err := fs.deleteUpload(strings.TrimSuffix(fi.Name(), ".json")); err != nil {
+			return purged, err
+		}
+		purged++
+	}
+	return purged, nil
+}
+
+// purgeExpiredUploads periodically deletes the upload sessions older than UploadMaxAge until ctx is done
+func (fs *FileStore) purgeExpiredUploads(ctx context.Context) {
+	ticker := time.NewTicker(uploadPurgeInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		purged, err := fs.purgeUploadsOlderThan(fs.UploadMaxAge)
+		if err != nil {
+			fs.Logger.Errorf("Could not purge expired upload sessions: %v", err)
+			continue
+		}
+		fs.Logger.Infof("Purged %d expired upload sessions", purged)
+	}
+}
+
+// uploads serves the resumable upload endpoints:
+//
+//	POST /v2/uploads?name=x[&size=n][&replace=true]  creates a session
+//	GET, HEAD /v2/uploads/id                         returns the session and its offset
+//	PUT /v2/uploads/id?offset=n                      appends a chunk at offset n
+//	POST /v2/uploads/id/complete?sha256=h            writes the file once its checksum is verified
+//	DELETE /v2/uploads/id                            aborts the session
+func (req *v2Request) uploads(path string) {
+	if path == "" {
+		if req.r.Method != http.MethodPost {
+			req.methodNotAllowed(http.MethodPost)
+			return
+		}
+		req.createUpload()
+		return
+	}
+	id, complete := strings.TrimPrefix(path, "/"), false
+	if strings.HasSuffix(id, "/complete") {
+		id, complete = strings.TrimSuffix(id, "/complete"), true
+	}
+	if !validUploadID(id) {
+		req.fail(http.StatusNotFound, ErrCodeNotFound, fmt.Errorf("upload session does not exist"))
+		return
+	}
+	// chunks are received without holding the session lock, so a client resuming after
+	// a dropped connection is not kept waiting by the request it left
+	if !complete && req.r.Method == http.MethodPut {
+		req.putChunk(id)
+		return
+	}
+	defer req.fs.uploadLocks.lock(id)()
+	u, ok := req.loadUpload(id)
+	if !ok {
+		return
+	}
+	switch {
+	case complete && req.r.Method == http.MethodPost:
+		req.completeUpload(u)
+	case complete:
+		req.methodNotAllowed(http.MethodPost)
+	case req.r.Method == http.MethodGet || req.r.Method == http.MethodHead:
+		req.w.Header().Set(UploadOffsetHeader, strconv.FormatInt(u.Offset, 10))
+		req.writeJSON(http.StatusOK, u)
+	case req.r.Method == http.MethodDelete:
+		if err := req.fs.deleteUpload(u.ID); err != nil {
+			req.failWith(err)
+			return
+		}
+		req.w.WriteHeader(http.StatusNoContent)
+	default:
+		req.methodNotAllowed(http.MethodGet, http.MethodHead, http.MethodPut, http.MethodDelete)
+	}
+}
+
+// loadUpload reads the record of an upload session, it reports whether it was found and
+// answers the request otherwise
+func (req *v2Request) loadUpload(id string) (*Upload, bool) {
+	u, err := req.fs.loadUpload(id)
+	if os.IsNotExist(err) {
+		req.fail(http.StatusNotFound, ErrCodeNotFound, fmt.Errorf("upload session does not exist or expired"))
+		return nil, false
+	}
+	if err != nil {
+		req.failWith(err)
+		return nil, false
+	}
+	return u, true
+}
+
+// createUpload starts an upload session
+func (req *v2Request) createUpload() {
+	query := req.r.URL.Query()
+	name, err := req.fs.checkName(query.Get("name"))
+	if err != nil {
+		req.failWith(err)
+		return
+	}
+	up, err := uploadFromRequest(req.r)
+	if err != nil {
+		req.fail(http.StatusBadRequest, ErrCodeInvalidRequest, err)
+		return
+	}
+	u := &Upload{Name: name, Size: -1, Uploader: up.Uploader, Labels: up.Labels, Created: time.Now()}
+	if s := query.Get("size"); s != "" {
+		if u.Size, err = strconv.ParseInt(s, 10, 64); err != nil || u.Size < 0 {
+			req.fail(http.StatusBadRequest, ErrCodeInvalidRequest, fmt.Errorf("invalid size %q", s))
+			return
+		}
+	}
+	u.Replace, _ = strconv.ParseBool(query.Get("replace"))
+	if _, err := req.fs.backend.Stat(name); !u.Replace && !os.IsNotExist(err) {
+		req.fail(http.StatusConflict, ErrCodeAlreadyExists, fmt.Errorf("file %s already exists", name))
+		return
+	}
+	if u.ID, err = newUploadID(); err != nil {
+		req.failWith(err)
+		return
+	}
+	if err := req.fs.saveUpload(u); err != nil {
+		req.failWith(err)
+		return
+	}
+	req.fs.Logger.Infof("Starting upload %s of file %s", u.ID, name)
+	req.w.Header().Set("Location", "/v2/uploads/"+u.ID)
+	req.w.Header().Set(UploadOffsetHeader, "0")
+	req.writeJSON(http.StatusCreated, u)
+}
+
+// putChunk stores the request body as the chunk at the offset of the session. The body is
+// received as a pending chunk, the session lock is only held to check the offset once the
+// chunk is received and to add it to the session.
+func (req *v2Request) putChunk(id string) {
+	offset, err := strconv.ParseInt(req.r.URL.Query().Get("offset"), 10, 64)
+	if err != nil {
+		req.fail(http.StatusBadRequest, ErrCodeInvalidRequest, fmt.Errorf("invalid offset %q", req.r.URL.Query().Get("offset")))
+		return
+	}
+	// a chunk at the wrong offset is refused before its body is received
+	u, ok := req.loadUpload(id)
+	if !ok || !req.checkOffset(u, offset) {
+		return
+	}
+	suffix, err := newUploadID()
+	if err != nil {
+		req.failWith(err)
+		return
+	}
+	pending := path.Join(uploadPendingDir, id+"-"+suffix)
+	d := newDigest()
+	if err := req.fs.backend.Put(pending, io.TeeReader(req.r.Body, d)); err != nil {
+		// the chunk is discarded, the client resumes from the current offset
+		req.failWith(err)
+		return
+	}
+	defer req.fs.backend.Delete(pending) // nolint: errcheck
+
+	defer req.fs.uploadLocks.lock(id)()
+	if u, ok = req.loadUpload(id); !ok || !req.checkOffset(u, offset) {
+		return
+	}
+	if u.Size >= 0 && u.Offset+d.size > u.Size {
+		req.fail(http.StatusBadRequest, ErrCodeInvalidRequest, fmt.Errorf("chunk goes past the announced size %d", u.Size))
+		return
+	}
+	if d.size > 0 {
+		if err := req.fs.backend.Rename(pending, uploadChunkPath(u.ID, offset)); err != nil {
+			req.failWith(err)
+			return
+		}
+	}
+	u.Offset += d.size
+	if err := req.fs.saveUpload(u); err != nil {
+		req.failWith(err)
+		return
+	}
+	req.w.Header().Set(UploadOffsetHeader, strconv.FormatInt(u.Offset, 10))
+	req.writeJSON(http.StatusOK, u)
+}
+
+// checkOffset reports whether a chunk at offset is the next chunk of the session, and
+// answers the request otherwise
+func (req *v2Request) checkOffset(u *Upload, offset int64) bool {
+	req.w.Header().Set(UploadOffsetHeader, strconv.FormatInt(u.Offset, 10))
+	if offset != u.Offset {
+		req.fail(http.StatusConflict, ErrCodeOffsetMismatch, fmt.Errorf("upload is at offset %d, not %d", u.Offset, offset))
+		return false
+	}
+	return true
+}
+
+// completeUpload verifies the checksum of the received content and writes it as the
+// file of the session once the If-Match and If-None-Match headers of the request hold,
+// the session is then deleted
+func (req *v2Request) completeUpload(u *Upload) {
+	sum := strings.ToLower(req.r.URL.Query().Get("sha256"))
+	if sum == "" {
+		req.fail(http.StatusBadRequest, ErrCodeInvalidRequest, fmt.Errorf("missing sha256 parameter"))
+		return
+	}
+	if u.Size >= 0 && u.Offset != u.Size {
+		req.fail(http.StatusConflict, ErrCodeOffsetMismatch, fmt.Errorf("upload is at offset %d of %d", u.Offset, u.Size))
+		return
+	}
+	content, objects, err := req.fs.uploadContent(u)
+	if err != nil {
+		req.failWith(err)
+		return
+	}
+	h := sha256.New()
+	_, err = io.Copy(h, content)
+	closeObjects(objects)
+	if err != nil {
+		req.failWith(err)
+		return
+	}
+	if hex.EncodeToString(h.Sum(nil)) != sum {
+		req.fail(http.StatusBadRequest, ErrCodeChecksumMismatch, fmt.Errorf("received content does not match sha256 %s", sum))
+		return
+	}
+	var unlock func()
+	u.Name, unlock = req.fs.lockName(u.Name)
+	defer unlock()
+	if err := req.fs.checkPreconditions(req.r, u.Name); err != nil {
+		req.failWith(err)
+		return
+	}
+	status := http.StatusOK
+	if _, err := req.fs.backend.Stat(u.Name); os.IsNotExist(err) {
+		status = http.StatusCreated
+	} else if !u.Replace {
+		req.fail(http.StatusConflict, ErrCodeAlreadyExists, fmt.Errorf("file %s already exists", u.Name))
+		return
+	}
+	content, objects, err = req.fs.uploadContent(u)
+	if err != nil {
+		req.failWith(err)
+		return
+	}
+	err = req.fs.writeVersioned(u.Name, content, upload{Uploader: u.Uploader, Labels: u.Labels})
+	closeObjects(objects)
+	if err != nil {
+		req.failWith(err)
+		return
+	}
+	if err := req.fs.deleteUpload(u.ID); err != nil {
+		req.fs.Logger.Warnf("Could not delete completed upload %s: %v", u.ID, err)
+	}
+	req.fs.Logger.Infof("Completed upload %s of file %s", u.ID, u.Name)
+	meta, err := req.fs.metadata(u.Name)
+	if err != nil {
+		req.failWith(err)
+		return
+	}
+	req.w.Header().Set("ETag", etag(meta.SHA256))
+	req.writeJSON(status, meta)
+}
@@ -0,0 +1,146 @@
+package filestore
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// createUpload starts an upload session and returns it
+func createUpload(t *testing.T, fs *FileStore, target string) *Upload {
+	w := serve(fs.V2, httptest.NewRequest("POST", target, nil))
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	u := &Upload{}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), u))
+	return u
+}
+
+func TestResumableUpload(t *testing.T) {
+	fs := newTestStore(t)
+	defer os.RemoveAll(fs.StoreDir)
+	content := "foo bar baz qux"
+	sum := sha256.Sum256([]byte(content))
+
+	u := createUpload(t, fs, "/v2/uploads?name=a.txt&size=15&label=team=ops")
+	require.Equal(t, "a.txt", u.Name)
+	require.Equal(t, int64(0), u.Offset)
+
+	w := serve(fs.V2, httptest.NewRequest("PUT", "/v2/uploads/"+u.ID+"?offset=0", strings.NewReader(content[:8])))
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	require.Equal(t, "8", w.Header().Get(UploadOffsetHeader))
+
+	// a chunk sent again after a lost response is rejected with the current offset
+	w = serve(fs.V2, httptest.NewRequest("PUT", "/v2/uploads/"+u.ID+"?offset=0", strings.NewReader(content[:8])))
+	require.Equal(t, http.StatusConflict, w.Code)
+	require.Equal(t, "8", w.Header().Get(UploadOffsetHeader))
+	w = serve(fs.V2, httptest.NewRequest("HEAD", "/v2/uploads/"+u.ID, nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, "8", w.Header().Get(UploadOffsetHeader))
+
+	// completing before all the announced bytes are received fails
+	w = serve(fs.V2, httptest.NewRequest("POST", "/v2/uploads/"+u.ID+"/complete?sha256="+hex.EncodeToString(sum[:]), nil))
+	require.Equal(t, http.StatusConflict, w.Code)
+
+	w = serve(fs.V2, httptest.NewRequest("PUT", "/v2/uploads/"+u.ID+"?offset=8", strings.NewReader(content[8:])))
+	require.Equal(t, http.StatusOK, w.Code)
+	w = serve(fs.V2, httptest.NewRequest("POST", "/v2/uploads/"+u.ID+"/complete?sha256=00", nil))
+	require.Equal(t, http.StatusBadRequest, w.Code)
+	require.Contains(t, w.Body.String(), ErrCodeChecksumMismatch)
+	w = serve(fs.V2, httptest.NewRequest("POST", "/v2/uploads/"+u.ID+"/complete?sha256="+hex.EncodeToString(sum[:]), nil))
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	meta := &Metadata{}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), meta))
+	require.Equal(t, hex.EncodeToString(sum[:]), meta.SHA256)
+	require.Equal(t, map[string]string{"team": "ops"}, meta.Labels)
+
+	w = serve(fs.Get, httptest.NewRequest("GET", "/get?file=a.txt", nil))
+	require.Equal(t, content, w.Body.String())
+	require.Equal(t, 4, fs.index.Count())
+
+	// completed sessions are gone with their chunks
+	w = serve(fs.V2, httptest.NewRequest("HEAD", "/v2/uploads/"+u.ID, nil))
+	require.Equal(t, http.StatusNotFound, w.Code)
+	chunks, err := fs.backend.List(uploadChunksDir + "/" + u.ID)
+	require.NoError(t, err)
+	require.Empty(t, chunks)
+
+	// existing files are only replaced when asked to
+	w = serve(fs.V2, httptest.NewRequest("POST", "/v2/uploads?name=a.txt", nil))
+	require.Equal(t, http.StatusConflict, w.Code)
+	require.Contains(t, w.Body.String(), ErrCodeAlreadyExists)
+	u = createUpload(t, fs, "/v2/uploads?name=a.txt&replace=true")
+	w = serve(fs.V2, httptest.NewRequest("PUT", "/v2/uploads/"+u.ID+"?offset=0", strings.NewReader("new")))
+	require.Equal(t, http.StatusOK, w.Code)
+	sum = sha256.Sum256([]byte("new"))
+	w = serve(fs.V2, httptest.NewRequest("POST", "/v2/uploads/"+u.ID+"/complete?sha256="+hex.EncodeToString(sum[:]), nil))
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	versions, err := fs.listVersions("a.txt")
+	require.NoError(t, err)
+	require.Len(t, versions, 1)
+}
+
+func TestUploadExpiry(t *testing.T) {
+	fs := newTestStore(t)
+	defer os.RemoveAll(fs.StoreDir)
+
+	u := createUpload(t, fs, "/v2/uploads?name=a.txt")
+	w := serve(fs.V2, httptest.NewRequest("PUT", "/v2/uploads/"+u.ID+"?offset=0", strings.NewReader("foo")))
+	require.Equal(t, http.StatusOK, w.Code)
+	purged, err := fs.purgeUploadsOlderThan(time.Hour)
+	require.NoError(t, err)
+	require.Equal(t, 0, purged)
+	purged, err = fs.purgeUploadsOlderThan(-time.Second)
+	require.NoError(t, err)
+	require.Equal(t, 1, purged)
+	w = serve(fs.V2, httptest.NewRequest("PUT", "/v2/uploads/"+u.ID+"?offset=3", strings.NewReader("bar")))
+	require.Equal(t, http.StatusNotFound, w.Code)
+
+	// sessions past their expiry are refused before being purged
+	fs.UploadMaxAge = -time.Second
+	u = createUpload(t, fs, "/v2/uploads?name=b.txt")
+	w = serve(fs.V2, httptest.NewRequest("HEAD", "/v2/uploads/"+u.ID, nil))
+	require.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestUploadChunkWithoutLock(t *testing.T) {
+	fs := newTestStore(t)
+	defer os.RemoveAll(fs.StoreDir)
+	u := createUpload(t, fs, "/v2/uploads?name=a.txt")
+
+	// a chunk whose body is still being received doesnt hold the session
+	body, sender := io.Pipe()
+	done := make(chan *httptest.ResponseRecorder)
+	go func() {
+		done <- serve(fs.V2, httptest.NewRequest("PUT", "/v2/uploads/"+u.ID+"?offset=0", body))
+	}()
+	_, err := sender.Write([]byte("stale"))
+	require.NoError(t, err)
+	w := serve(fs.V2, httptest.NewRequest("HEAD", "/v2/uploads/"+u.ID, nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, "0", w.Header().Get(UploadOffsetHeader))
+
+	// the client resumes with a new request, the offset is checked again once the first
+	// one ends
+	w = serve(fs.V2, httptest.NewRequest("PUT", "/v2/uploads/"+u.ID+"?offset=0", strings.NewReader("foo")))
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	require.NoError(t, sender.Close())
+	w = <-done
+	require.Equal(t, http.StatusConflict, w.Code, w.Body.String())
+	require.Equal(t, "3", w.Header().Get(UploadOffsetHeader))
+	pending, err := fs.backend.List(uploadPendingDir)
+	require.NoError(t, err)
+	require.Empty(t, pending)
+
+	sum := sha256.Sum256([]byte("foo"))
+	w = serve(fs.V2, httptest.NewRequest("POST", "/v2/uploads/"+u.ID+"/complete?sha256="+hex.EncodeToString(sum[:]), nil))
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+}
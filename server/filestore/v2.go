@@ -0,0 +1,267 @@
+package filestore
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// RequestIDHeader carries the id of a request, the one sent by the client is kept
+const RequestIDHeader = "X-Request-ID"
+
+// Error codes reported by the v2 API, rejected file names report the NameError code
+const (
+	ErrCodeNotFound         = "not_found"
+	ErrCodeInvalidRequest   = "invalid_request"
+	ErrCodeMethodNotAllowed = "method_not_allowed"
+	ErrCodeInternal         = "internal"
+)
+
+// maxRequestIDLength bounds the length of request ids sent by clients
+const maxRequestIDLength = 128
+
+// APIError is the body of the v2 API error responses
+type APIError struct {
+	Code      string `json:"code"`
+	Message   string `json:"message"`
+	RequestID string `json:"request_id"`
+}
+
+// WordStats is the body of the v2 word statistics response along with the text counts of the
+// files, Files holds the statistics of every file when a breakdown is asked for and Errors
+// lists the files the index could not scan
+type WordStats struct {
+	Count  int             `json:"count"`
+	Words  []wordFreq      `json:"words"`
+	Files  []FileWordStats `json:"files,omitempty"`
+	Errors []FileError     `json:"errors,omitempty"`
+	textCounts
+}
+
+// requestID returns the id of r, a new one is generated when the client sent none
+func requestID(r *http.Request) string {
+	if id := r.Header.Get(RequestIDHeader); id != "" && len(id) <= maxRequestIDLength {
+		return id
+	}
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "unknown"
+	}
+	return hex.EncodeToString(b)
+}
+
+// v2Request is a request to the v2 API with its id
+type v2Request struct {
+	fs *FileStore
+	w  http.ResponseWriter
+	r  *http.Request
+	id string
+}
+
+// writeJSON writes v as the body of the response with status
+func (req *v2Request) writeJSON(status int, v interface{}) {
+	req.w.Header().Set("Content-Type", "application/json")
+	req.w.WriteHeader(status)
+	if err := json.NewEncoder(req.w).Encode(v); err != nil {
+		req.fs.Logger.Errorf("Could not write response to request %s: %v", req.id, err)
+	}
+}
+
+// fail writes an error response
+func (req *v2Request) fail(status int, code string, err error) {
+	if status == http.StatusInternalServerError {
+		req.fs.Logger.Errorf("Request %s failed: %v", req.id, err)
+	}
+	req.writeJSON(status, APIError{Code: code, Message: err.Error(), RequestID: req.id})
+}
+
+// failWith writes the error response matching err
+func (req *v2Request) failWith(err error) {
+	switch e := err.(type) {
+	case *NameError:
+		req.fail(http.StatusBadRequest, e.Code, e)
+	case *PreconditionError:
+		req.fail(http.StatusPreconditionFailed, ErrCodePreconditionFailed, e)
+	default:
+		if os.IsNotExist(err) {
+			req.fail(http.StatusNotFound, ErrCodeNotFound, fmt.Errorf("file does not exist"))
+			return
+		}
+		req.fail(http.StatusInternalServerError, ErrCodeInternal, err)
+	}
+}
+
+// methodNotAllowed rejects the request method, allowed lists the methods of the route
+func (req *v2Request) methodNotAllowed(allowed ...string) {
+	req.w.Header().Set("Allow", strings.Join(allowed, ", "))
+	req.fail(http.StatusMethodNotAllowed, ErrCodeMethodNotAllowed, fmt.Errorf("method %s is not allowed", req.r.Method))
+}
+
+// V2 serves the resource style JSON API under /v2:
+//
+//	GET /v2/files                 lists the metadata records of the files
+//	GET, PUT, DELETE /v2/files/x  downloads, writes or removes file x
+//	GET /v2/stats/words           counts words and returns the most frequent ones
+//	GET /v2/stats/ngrams          counts n-grams and returns the most frequent ones
+//	/v2/uploads                   resumable uploads, see uploads
+//	/v2/stopwords                 stopword lists, see stopwords
+func (fs *FileStore) V2(w http.ResponseWriter, r *http.Request) {
+	req := &v2Request{fs: fs, w: w, r: r, id: requestID(r)}
+	w.Header().Set(RequestIDHeader, req.id)
+	path := strings.TrimPrefix(r.URL.Path, "/v2")
+	switch {
+	case path == "/files":
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			req.methodNotAllowed(http.MethodGet, http.MethodHead)
+			return
+		}
+		req.listFiles()
+	case strings.HasPrefix(path, "/files/"):
+		name, err := fs.checkName(strings.TrimPrefix(path, "/files/"))
+		if err != nil {
+			req.failWith(err)
+			return
+		}
+		switch r.Method {
+		case http.MethodGet, http.MethodHead:
+			req.getFile(name)
+		case http.MethodPut:
+			req.putFile(name)
+		case http.MethodDelete:
+			req.deleteFile(name)
+		default:
+			req.methodNotAllowed(http.MethodGet, http.MethodHead, http.MethodPut, http.MethodDelete)
+		}
+	case path == "/uploads" || strings.HasPrefix(path, "/uploads/"):
+		req.uploads(strings.TrimPrefix(path, "/uploads"))
+	case path == "/stopwords" || strings.HasPrefix(path, "/stopwords/"):
+		req.stopwords(strings.TrimPrefix(path, "/stopwords"))
+	case path == "/stats/words":
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			req.methodNotAllowed(http.MethodGet, http.MethodHead)
+			return
+		}
+		req.wordStats()
+	case path == "/stats/ngrams":
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			req.methodNotAllowed(http.MethodGet, http.MethodHead)
+			return
+		}
+		req.ngramStats()
+	default:
+		req.fail(http.StatusNotFound, ErrCodeNotFound, fmt.Errorf("no route for %s", r.URL.Path))
+	}
+}
+
+// listFiles writes the metadata records of the files in the store
+func (req *v2Request) listFiles() {
+	files, err := req.fs.backend.List("")
+	if err != nil {
+		req.failWith(err)
+		return
+	}
+	records, err := req.fs.listMetadata(files)
+	if err != nil {
+		req.failWith(err)
+		return
+	}
+	req.writeJSON(http.StatusOK, records)
+}
+
+// getFile streams a file, or one of its versions when a version is given
+func (req *v2Request) getFile(name string) {
+	version, err := versionParam(req.r)
+	if err != nil {
+		req.fail(http.StatusBadRequest, ErrCodeInvalidRequest, err)
+		return
+	}
+	file, fi, meta, err := req.fs.open(name, version)
+	if err != nil {
+		req.failWith(err)
+		return
+	}
+	defer file.Close()
+	req.fs.serveContent(req.w, req.r, name, version, fi, meta, file)
+}
+
+// putFile writes the request body as a file, the previous content is kept as a version.
+// If-Match and If-None-Match are checked against the current content.
+func (req *v2Request) putFile(name string) {
+	u, err := uploadFromRequest(req.r)
+	if err != nil {
+		req.fail(http.StatusBadRequest, ErrCodeInvalidRequest, err)
+		return
+	}
+	name, unlock := req.fs.lockName(name)
+	defer unlock()
+	if err := req.fs.checkPreconditions(req.r, name); err != nil {
+		req.failWith(err)
+		return
+	}
+	status := http.StatusOK
+	if _, err := req.fs.backend.Stat(name); os.IsNotExist(err) {
+		status = http.StatusCreated
+	}
+	req.fs.Logger.Infof("Writing file %s", name)
+	if err := req.fs.writeVersioned(name, req.r.Body, u); err != nil {
+		req.failWith(err)
+		return
+	}
+	meta, err := req.fs.metadata(name)
+	if err != nil {
+		req.failWith(err)
+		return
+	}
+	req.w.Header().Set("ETag", etag(meta.SHA256))
+	req.writeJSON(status, meta)
+}
+
+// deleteFile moves a file to the trash, honoring If-Match
+func (req *v2Request) deleteFile(name string) {
+	req.fs.Logger.Infof("Removing file name %s", name)
+	if err := req.fs.remove(req.r, name); err != nil {
+		req.failWith(err)
+		return
+	}
+	req.w.WriteHeader(http.StatusNoContent)
+}
+
+// wordStats writes the number of words in the store, or in the files of the request, and
+// the most frequent ones as split by the analyzer parameter, limit defaults to 10 and order
+// to dsc. per_file=true adds the statistics of every file.
+func (req *v2Request) wordStats() {
+	query := req.r.URL.Query()
+	limit := 10
+	if s := query.Get("limit"); s != "" {
+		var err error
+		if limit, err = strconv.Atoi(s); err != nil || limit < 0 {
+			req.fail(http.StatusBadRequest, ErrCodeInvalidRequest, fmt.Errorf("invalid limit %q", s))
+			return
+		}
+	}
+	q, err := req.fs.wordStatsParams(req.r)
+	if err != nil {
+		req.fail(http.StatusBadRequest, ErrCodeInvalidRequest, err)
+		return
+	}
+	order := query.Get("order")
+	switch order {
+	case "":
+		order = "dsc"
+	case "dsc", "asc":
+	default:
+		req.fail(http.StatusBadRequest, ErrCodeInvalidRequest, fmt.Errorf("invalid order %q, expecting asc or dsc", order))
+		return
+	}
+	stats, err := req.fs.analyze(q, limit, order)
+	if err != nil {
+		req.failWith(err)
+		return
+	}
+	req.writeJSON(http.StatusOK, stats)
+}
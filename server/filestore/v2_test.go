@@ -0,0 +1,116 @@
+package filestore
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestV2Files(t *testing.T) {
+	fs := newTestStore(t)
+	defer os.RemoveAll(fs.StoreDir)
+
+	w := serve(fs.V2, httptest.NewRequest("PUT", "/v2/files/a.txt?label=team=ops", strings.NewReader("foo bar foo")))
+	require.Equal(t, http.StatusCreated, w.Code)
+	require.NotEmpty(t, w.Header().Get(RequestIDHeader))
+	meta := &Metadata{}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), meta))
+	require.Equal(t, "a.txt", meta.Name)
+	require.Equal(t, int64(11), meta.Size)
+	require.Equal(t, map[string]string{"team": "ops"}, meta.Labels)
+
+	w = serve(fs.V2, httptest.NewRequest("PUT", "/v2/files/a.txt", strings.NewReader("foo baz")))
+	require.Equal(t, http.StatusOK, w.Code)
+	w = serve(fs.V2, httptest.NewRequest("GET", "/v2/files/a.txt", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, "foo baz", w.Body.String())
+	w = serve(fs.V2, httptest.NewRequest("GET", "/v2/files/a.txt?version=1", nil))
+	require.Equal(t, "foo bar foo", w.Body.String())
+
+	w = serve(fs.V2, httptest.NewRequest("GET", "/v2/files", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	var records []Metadata
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &records))
+	require.Len(t, records, 1)
+	require.Equal(t, "a.txt", records[0].Name)
+
+	w = serve(fs.V2, httptest.NewRequest("GET", "/v2/stats/words?limit=1", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	stats := &WordStats{}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), stats))
+	require.Equal(t, &WordStats{Count: 2, Words: []wordFreq{{Word: "baz", Count: 1}}, textCounts: textCounts{Bytes: 7, Chars: 7, MaxLineLength: 7}}, stats)
+
+	w = serve(fs.V2, httptest.NewRequest("DELETE", "/v2/files/a.txt", nil))
+	require.Equal(t, http.StatusNoContent, w.Code)
+	w = serve(fs.V2, httptest.NewRequest("GET", "/v2/files/a.txt", nil))
+	require.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestV2Preconditions(t *testing.T) {
+	fs := newTestStore(t)
+	defer os.RemoveAll(fs.StoreDir)
+	put := func(content, header, value string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("PUT", "/v2/files/a.txt", strings.NewReader(content))
+		req.Header.Set(header, value)
+		return serve(fs.V2, req)
+	}
+
+	w := put("first", "If-None-Match", "*")
+	require.Equal(t, http.StatusCreated, w.Code)
+	tag := w.Header().Get("ETag")
+	require.NotEmpty(t, tag)
+	w = put("again", "If-None-Match", "*")
+	require.Equal(t, http.StatusPreconditionFailed, w.Code)
+	apiErr := &APIError{}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), apiErr))
+	require.Equal(t, ErrCodePreconditionFailed, apiErr.Code)
+
+	w = put("second", "If-Match", tag)
+	require.Equal(t, http.StatusOK, w.Code)
+	require.NotEqual(t, tag, w.Header().Get("ETag"))
+	w = put("lost", "If-Match", tag)
+	require.Equal(t, http.StatusPreconditionFailed, w.Code)
+
+	req := httptest.NewRequest("DELETE", "/v2/files/a.txt", nil)
+	req.Header.Set("If-Match", tag)
+	w = serve(fs.V2, req)
+	require.Equal(t, http.StatusPreconditionFailed, w.Code)
+	w = serve(fs.V2, httptest.NewRequest("GET", "/v2/files/a.txt", nil))
+	require.Equal(t, "second", w.Body.String())
+}
+
+func TestV2Errors(t *testing.T) {
+	fs := newTestStore(t)
+	defer os.RemoveAll(fs.StoreDir)
+
+	tests := []struct {
+		method, target string
+		status         int
+		code           string
+	}{
+		{"GET", "/v2/files/missing.txt", http.StatusNotFound, ErrCodeNotFound},
+		{"DELETE", "/v2/files/missing.txt", http.StatusNotFound, ErrCodeNotFound},
+		{"GET", "/v2/files/.filestore", http.StatusBadRequest, ErrCodeNameReserved},
+		{"POST", "/v2/files/a.txt", http.StatusMethodNotAllowed, ErrCodeMethodNotAllowed},
+		{"DELETE", "/v2/files", http.StatusMethodNotAllowed, ErrCodeMethodNotAllowed},
+		{"GET", "/v2/stats/words?limit=x", http.StatusBadRequest, ErrCodeInvalidRequest},
+		{"GET", "/v2/stats/words?order=up", http.StatusBadRequest, ErrCodeInvalidRequest},
+		{"GET", "/v2/unknown", http.StatusNotFound, ErrCodeNotFound},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(tt.method, tt.target, nil)
+		req.Header.Set(RequestIDHeader, "req-1")
+		w := serve(fs.V2, req)
+		require.Equal(t, tt.status, w.Code, tt.target)
+		apiErr := &APIError{}
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), apiErr), tt.target)
+		require.Equal(t, tt.code, apiErr.Code, tt.target)
+		require.Equal(t, "req-1", apiErr.RequestID)
+		require.Equal(t, "req-1", w.Header().Get(RequestIDHeader))
+	}
+}
@@ -0,0 +1,229 @@
+package filestore
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// fileVersion describes a previous content of a file
+type fileVersion struct {
+	Version int
+	Size    int64
+	ModTime time.Time
+}
+
+// versionsDir returns the backend dir holding the versions of a file
+func versionsDir(name string) string {
+	return metaPath("versions", name)
+}
+
+// versionPath returns the backend name of a version of a file
+func versionPath(name string, version int) string {
+	return path.Join(versionsDir(name), strconv.Itoa(version))
+}
+
+// listVersions returns the versions of a file, oldest first
+func (fs *FileStore) listVersions(name string) ([]fileVersion, error) {
+	return fs.listVersionsIn(versionsDir(name))
+}
+
+// listVersionsIn returns the versions held in dir, oldest first
+func (fs *FileStore) listVersionsIn(dir string) ([]fileVersion, error) {
+	files, err := fs.backend.List(dir)
+	if err != nil {
+		return nil, err
+	}
+	var versions []fileVersion
+	for _, fi := range files {
+		version, err := strconv.Atoi(fi.Name())
+		if err != nil {
+			continue
+		}
+		versions = append(versions, fileVersion{Version: version, Size: fi.Size(), ModTime: fi.ModTime()})
+	}
+	sort.Slice(versions, func(i, j int) bool {
+		return versions[i].Version < versions[j].Version
+	})
+	return versions, nil
+}
+
+// writeVersioned writes the content of r as name like write does, the content it replaces
+// is then archived as the next version of name. The replaced content is opened beforehand
+// so it can be archived once the new content is stored, a failed write archives nothing.
+func (fs *FileStore) writeVersioned(name string, r io.Reader, u upload) error {
+	current, err := fs.backend.Get(name)
+	if os.IsNotExist(err) {
+		return fs.write(name, r, u)
+	}
+	if err != nil {
+		return err
+	}
+	defer current.Close()
+	d := newDigest()
+	if err := fs.backend.Put(name, io.TeeReader(r, d)); err != nil {
+		return err
+	}
+	// the new content is stored, failing now would have clients write it again
+	if err := fs.archive(name, current); err != nil {
+		fs.Logger.Errorf("Could not archive the previous content of %s: %v", name, err)
+	}
+	return fs.record(name, d, u)
+}
+
+// archive keeps current, the content a file had before being replaced, as the next version
+// of the file then applies the retention policy
+func (fs *FileStore) archive(name string, current io.Reader) error {
+	fs.versionsMu.Lock()
+	defer fs.versionsMu.Unlock()
+	versions, err := fs.listVersions(name)
+	if err != nil {
+		return err
+	}
+	next := 1
+	if len(versions) > 0 {
+		next = versions[len(versions)-1].Version + 1
+	}
+	if err := fs.backend.Put(versionPath(name, next), current); err != nil {
+		return err
+	}
+	return fs.pruneVersions(name)
+}
+
+// moveVersions moves the versions held in dir from to dir to, they are numbered after the
+// versions to already holds
+func (fs *FileStore) moveVersions(from, to string) error {
+	fs.versionsMu.Lock()
+	defer fs.versionsMu.Unlock()
+	moved, err := fs.listVersionsIn(from)
+	if err != nil {
+		return err
+	}
+	existing, err := fs.listVersionsIn(to)
+	if err != nil {
+		return err
+	}
+	offset := 0
+	if len(existing) > 0 {
+		offset = existing[len(existing)-1].Version
+	}
+	for _, v := range moved {
+		err := fs.backend.Rename(path.Join(from, strconv.Itoa(v.Version)), path.Join(to, strconv.Itoa(offset+v.Version)))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// pruneVersions deletes the versions of a file the retention policy doesnt keep: a version is
+// kept when it is one of the last KeepVersions versions or when it is newer than
+// KeepVersionsDays days. A zero setting disables its rule, both zero keeps every version.
+func (fs *FileStore) pruneVersions(name string) error {
+	if fs.KeepVersions <= 0 && fs.KeepVersionsDays <= 0 {
+		return nil
+	}
+	versions, err := fs.listVersions(name)
+	if err != nil {
+		return err
+	}
+	deadline := time.Now().AddDate(0, 0, -fs.KeepVersionsDays)
+	for i, v := range versions {
+		if fs.KeepVersions > 0 && i >= len(versions)-fs.KeepVersions {
+			continue
+		}
+		if fs.KeepVersionsDays > 0 && v.ModTime.After(deadline) {
+			continue
+		}
+		fs.Logger.Infof("Pruning version %d of %s", v.Version, name)
+		if err := fs.backend.Delete(versionPath(name, v.Version)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// versionParam returns the version requested by r, 0 when none is
+func versionParam(r *http.Request) (int, error) {
+	value := r.FormValue("version")
+	if value == "" {
+		return 0, nil
+	}
+	version, err := strconv.Atoi(value)
+	if err != nil || version <= 0 {
+		return 0, fmt.Errorf("invalid version %q", value)
+	}
+	return version, nil
+}
+
+// Versions lists the versions of a file of the store
+func (fs *FileStore) Versions(w http.ResponseWriter, r *http.Request) {
+	fileName, err := fs.checkName(r.FormValue("file"))
+	if err != nil {
+		http.Error(w, err.Error(), nameStatus(err))
+		return
+	}
+	fs.Logger.Infof("Listing versions of file %s", fileName)
+	versions, err := fs.listVersions(fileName)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if _, err := fs.backend.Stat(fileName); os.IsNotExist(err) && len(versions) == 0 {
+		http.Error(w, "File does not exist", http.StatusNotFound)
+		return
+	}
+	for _, v := range versions {
+		_, err = io.WriteString(w, fmt.Sprintf("%3d %10d %s\n", v.Version, v.Size, v.ModTime.Format(time.RFC3339)))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+}
+
+// Restore makes a version the current content of a file, the replaced content is archived as a new version.
+// If-Match and If-None-Match are checked against the replaced content.
+func (fs *FileStore) Restore(w http.ResponseWriter, r *http.Request) {
+	fileName, err := fs.checkName(r.FormValue("file"))
+	if err != nil {
+		http.Error(w, err.Error(), nameStatus(err))
+		return
+	}
+	version, err := versionParam(r)
+	if err != nil || version == 0 {
+		http.Error(w, fmt.Sprintf("invalid version %q", r.FormValue("version")), http.StatusBadRequest)
+		return
+	}
+	u, err := uploadFromRequest(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	fs.Logger.Infof("Restoring version %d of file %s", version, fileName)
+	src, err := fs.backend.Get(versionPath(fileName, version))
+	if os.IsNotExist(err) {
+		http.Error(w, "Version does not exist", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer src.Close()
+	fileName, unlock := fs.lockName(fileName)
+	defer unlock()
+	if err := fs.checkPreconditions(r, fileName); err != nil {
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+	if err := fs.writeVersioned(fileName, src, u); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
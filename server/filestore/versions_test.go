@@ -0,0 +1,90 @@
+package filestore
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestVersions(t *testing.T) {
+	fs := newTestStore(t)
+	defer os.RemoveAll(fs.StoreDir)
+
+	for _, content := range []string{"one", "two", "three"} {
+		w := serve(fs.Update, multipartRequest(t, "POST", "/update", map[string]string{"a.txt": content}))
+		require.Equal(t, http.StatusOK, w.Code)
+	}
+	w := serve(fs.Versions, httptest.NewRequest("GET", "/versions?file=a.txt", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	lines := strings.Split(strings.TrimSpace(w.Body.String()), "\n")
+	require.Len(t, lines, 2)
+	require.True(t, strings.HasPrefix(strings.TrimSpace(lines[0]), "1 "))
+
+	w = serve(fs.Get, httptest.NewRequest("GET", "/get?file=a.txt&version=1", nil))
+	require.Equal(t, "one", w.Body.String())
+	w = serve(fs.Get, httptest.NewRequest("GET", "/get?file=a.txt&version=3", nil))
+	require.Equal(t, http.StatusNotFound, w.Code)
+
+	w = serve(fs.Restore, httptest.NewRequest("POST", "/restore?file=a.txt&version=1", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	w = serve(fs.Get, httptest.NewRequest("GET", "/get?file=a.txt", nil))
+	require.Equal(t, "one", w.Body.String())
+	w = serve(fs.Get, httptest.NewRequest("GET", "/get?file=a.txt&version=3", nil))
+	require.Equal(t, "three", w.Body.String())
+	w = serve(fs.FreqWords, httptest.NewRequest("GET", "/freqwords?limit=5&order=dsc", nil))
+	require.Equal(t, "  1 one\n", w.Body.String())
+
+	w = serve(fs.Restore, httptest.NewRequest("POST", "/restore?file=a.txt&version=9", nil))
+	require.Equal(t, http.StatusNotFound, w.Code)
+	w = serve(fs.Versions, httptest.NewRequest("GET", "/versions?file=b.txt", nil))
+	require.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestVersionsRetention(t *testing.T) {
+	fs := newTestStore(t)
+	defer os.RemoveAll(fs.StoreDir)
+	fs.KeepVersions = 2
+
+	for _, content := range []string{"one", "two", "three", "four"} {
+		w := serve(fs.Update, multipartRequest(t, "POST", "/update", map[string]string{"a.txt": content}))
+		require.Equal(t, http.StatusOK, w.Code)
+	}
+	versions, err := fs.listVersions("a.txt")
+	require.NoError(t, err)
+	require.Len(t, versions, 2)
+	require.Equal(t, 2, versions[0].Version)
+	require.Equal(t, 3, versions[1].Version)
+
+	// versions younger than the age limit survive the count limit
+	fs.KeepVersions = 1
+	fs.KeepVersionsDays = 1
+	require.NoError(t, fs.pruneVersions("a.txt"))
+	versions, err = fs.listVersions("a.txt")
+	require.NoError(t, err)
+	require.Len(t, versions, 2)
+}
+
+func TestVersionsFailedWrite(t *testing.T) {
+	fs := newTestStore(t)
+	defer os.RemoveAll(fs.StoreDir)
+	require.NoError(t, fs.write("a.txt", strings.NewReader("one"), upload{}))
+
+	// a write failing twice leaves the content and its versions untouched
+	for i := 0; i < 2; i++ {
+		require.Error(t, fs.writeVersioned("a.txt", failingReader{}, upload{}))
+	}
+	versions, err := fs.listVersions("a.txt")
+	require.NoError(t, err)
+	require.Empty(t, versions)
+
+	require.NoError(t, fs.writeVersioned("a.txt", strings.NewReader("two"), upload{}))
+	versions, err = fs.listVersions("a.txt")
+	require.NoError(t, err)
+	require.Len(t, versions, 1)
+	w := serve(fs.Get, httptest.NewRequest("GET", "/get?file=a.txt&version=1", nil))
+	require.Equal(t, "one", w.Body.String())
+}
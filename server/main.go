@@ -1,6 +1,11 @@
 package main
 
 import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+
 	"filestore/helper"
 	"filestore/server/filestore"
 	"github.com/spf13/pflag"
@@ -13,8 +18,23 @@ func main() {
 	config.BindFlags(pflag.CommandLine)
 	pflag.Parse()
 
+	// Stop serving on SIGINT or SIGTERM, in-flight requests are drained first
+	ctx, cancel := context.WithCancel(context.Background())
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		sig := <-signals
+		logger.Infof("Received %s, stopping the filestore server", sig)
+		cancel()
+	}()
 
-	fs := filestore.NewFileStore(config)
+	fs, err := filestore.NewFileStore(config)
+	if err != nil {
+		logger.Fatalf("%v", err)
+	}
 	logger.Infof("Starting the filestore server")
-	fs.Run()
-}
\ No newline at end of file
+	if err := fs.Run(ctx); err != nil {
+		logger.Fatalf("%v", err)
+	}
+	logger.Infof("Filestore server stopped")
+}